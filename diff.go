@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/Financial-Times/generic-rw-s3/service"
+	"github.com/Financial-Times/go-logger/v2"
+	cli "github.com/jawher/mow.cli"
+)
+
+func diffCommand(log *logger.UPPLogger) func(cmd *cli.Cmd) {
+	return func(cmd *cli.Cmd) {
+		sourceBucket := cmd.String(cli.StringOpt{
+			Name:   "source-bucket",
+			Desc:   "Bucket holding the expected objects",
+			EnvVar: "DIFF_SOURCE_BUCKET",
+		})
+		sourcePrefix := cmd.String(cli.StringOpt{
+			Name:   "source-prefix",
+			Value:  "",
+			Desc:   "Prefix of the objects in the source bucket",
+			EnvVar: "DIFF_SOURCE_PREFIX",
+		})
+		sourceRegion := cmd.String(cli.StringOpt{
+			Name:   "source-region",
+			Value:  "eu-west-1",
+			Desc:   "AWS Region of the source bucket",
+			EnvVar: "DIFF_SOURCE_REGION",
+		})
+		sourceLayout := cmd.String(cli.StringOpt{
+			Name:   "source-layout",
+			Value:  service.LayoutPartitioned,
+			Desc:   "Key layout in the source bucket, partitioned or flat",
+			EnvVar: "DIFF_SOURCE_LAYOUT",
+		})
+		targetBucket := cmd.String(cli.StringOpt{
+			Name:   "target-bucket",
+			Desc:   "Bucket compared to the source",
+			EnvVar: "DIFF_TARGET_BUCKET",
+		})
+		targetPrefix := cmd.String(cli.StringOpt{
+			Name:   "target-prefix",
+			Value:  "",
+			Desc:   "Prefix of the objects in the target bucket",
+			EnvVar: "DIFF_TARGET_PREFIX",
+		})
+		targetRegion := cmd.String(cli.StringOpt{
+			Name:   "target-region",
+			Value:  "",
+			Desc:   "AWS Region of the target bucket, defaults to source-region",
+			EnvVar: "DIFF_TARGET_REGION",
+		})
+		targetLayout := cmd.String(cli.StringOpt{
+			Name:   "target-layout",
+			Value:  service.LayoutPartitioned,
+			Desc:   "Key layout in the target bucket, partitioned or flat",
+			EnvVar: "DIFF_TARGET_LAYOUT",
+		})
+		repair := cmd.Bool(cli.BoolOpt{
+			Name:   "repair",
+			Value:  false,
+			Desc:   "Copy missing and differing objects from the source to the target",
+			EnvVar: "DIFF_REPAIR",
+		})
+
+		cmd.Action = func() {
+			hc := newHTTPClient(spareWorkers)
+			srcSvc, err := newS3Client(*sourceRegion, hc)
+			if err != nil {
+				log.WithError(err).Fatal("Failed to create AWS session for source bucket")
+			}
+			dstSvc, err := newS3Client(regionOrDefault(*targetRegion, *sourceRegion), hc)
+			if err != nil {
+				log.WithError(err).Fatal("Failed to create AWS session for target bucket")
+			}
+
+			d, err := service.NewBucketDiffer(
+				service.BucketLocation{Svc: srcSvc, Bucket: *sourceBucket, Prefix: *sourcePrefix, Layout: *sourceLayout},
+				service.BucketLocation{Svc: dstSvc, Bucket: *targetBucket, Prefix: *targetPrefix, Layout: *targetLayout},
+				*repair, log)
+			if err != nil {
+				log.WithError(err).Fatal("Invalid diff")
+			}
+
+			summary, err := service.WriteDiffReport(d, json.NewEncoder(os.Stdout))
+			if err != nil {
+				log.WithError(err).Fatal("Diff failed")
+			}
+			if summary.RepairFailures > 0 || (!*repair && summary.Missing+summary.Extra+summary.Differing > 0) {
+				cli.Exit(1)
+			}
+		}
+	}
+}
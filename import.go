@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+
+	"github.com/Financial-Times/generic-rw-s3/service"
+	"github.com/Financial-Times/go-logger/v2"
+	transactionid "github.com/Financial-Times/transactionid-utils-go"
+	cli "github.com/jawher/mow.cli"
+)
+
+func importCommand(log *logger.UPPLogger) func(cmd *cli.Cmd) {
+	return func(cmd *cli.Cmd) {
+		bucket := cmd.String(cli.StringOpt{
+			Name:   "bucket",
+			Desc:   "Bucket to write the items to",
+			EnvVar: "IMPORT_BUCKET",
+		})
+		prefix := cmd.String(cli.StringOpt{
+			Name:   "prefix",
+			Value:  "",
+			Desc:   "Prefix of the objects in the bucket",
+			EnvVar: "IMPORT_PREFIX",
+		})
+		region := cmd.String(cli.StringOpt{
+			Name:   "region",
+			Value:  "eu-west-1",
+			Desc:   "AWS Region of the bucket",
+			EnvVar: "IMPORT_REGION",
+		})
+		file := cmd.String(cli.StringOpt{
+			Name:   "file",
+			Value:  "-",
+			Desc:   "NDJSON export, or tar, tar.gz or zip archive, to import. Reads stdin when set to -",
+			EnvVar: "IMPORT_FILE",
+		})
+		format := cmd.String(cli.StringOpt{
+			Name:   "format",
+			Value:  "",
+			Desc:   "Format of the file, ndjson, tar, tar.gz or zip. Defaults to the format of the file's extension",
+			EnvVar: "IMPORT_FORMAT",
+		})
+		path := cmd.String(cli.StringOpt{
+			Name:   "path",
+			Value:  "",
+			Desc:   "Path to write the items under, defaults to the path each item was exported from",
+			EnvVar: "IMPORT_PATH",
+		})
+		workers := cmd.Int(cli.IntOpt{
+			Name:   "workers",
+			Value:  10,
+			Desc:   "Number of items written in parallel",
+			EnvVar: "IMPORT_WORKERS",
+		})
+		onlyUpdatesEnabled := cmd.Bool(cli.BoolOpt{
+			Name:   "only-updates-enabled",
+			Value:  false,
+			Desc:   "Only write items whose hash differs from the stored one",
+			EnvVar: "IMPORT_ONLY_UPDATES_ENABLED",
+		})
+
+		cmd.Action = func() {
+			svc, err := newS3Client(*region, newHTTPClient(*workers+spareWorkers))
+			if err != nil {
+				log.WithError(err).Fatal("Failed to create AWS session")
+			}
+
+			var src io.Reader = os.Stdin
+			if *file != "-" {
+				f, err := os.Open(*file)
+				if err != nil {
+					log.WithError(err).Fatalf("Failed to open %s", *file)
+				}
+				defer f.Close()
+				src = f
+			}
+			if *format == "" {
+				*format = service.ImportFormat(*file)
+			}
+
+			// Items are written directly, without the mirror, index, aliases, references, count or events of the resource
+			im := service.NewImporter(service.NewS3Writer(svc, *bucket, *prefix, *onlyUpdatesEnabled, log), *workers, log)
+			summary, err := im.Import(src, *format, *path, transactionid.NewTransactionID())
+			json.NewEncoder(os.Stdout).Encode(summary)
+			if err != nil {
+				log.WithError(err).Fatal("Import failed")
+			}
+			if summary.Failed > 0 {
+				log.Errorf("Failed to write %d items", summary.Failed)
+				cli.Exit(1)
+			}
+		}
+	}
+}
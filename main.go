@@ -89,7 +89,7 @@ func main() {
 	kafkaClusterArn := app.String(cli.StringOpt{
 		Name:   "kafka_cluster_arn",
 		Value:  "",
-		Desc:   "Comma separated Kafka cluster ARN for consuming messages.",
+		Desc:   "Comma separated Kafka cluster ARN for consuming and producing messages.",
 		EnvVar: "KAFKA_CLUSTER_ARN",
 	})
 
@@ -114,6 +114,13 @@ func main() {
 		EnvVar: "CONSUMER_TOPIC",
 	})
 
+	producerTopic := app.String(cli.StringOpt{
+		Name:   "producer-topic",
+		Value:  "",
+		Desc:   "The topic to send an event to for every item created, updated or deleted, no events are sent when empty",
+		EnvVar: "PRODUCER_TOPIC",
+	})
+
 	logLevel := app.String(cli.StringOpt{
 		Name:   "log-level",
 		Value:  "INFO",
@@ -134,6 +141,132 @@ func main() {
 		EnvVar: "REQUEST_LOGGING_ENABLED",
 	})
 
+	mirrorBucketName := app.String(cli.StringOpt{
+		Name:   "mirrorBucketName",
+		Value:  "",
+		Desc:   "Secondary bucket that every write and delete is mirrored to",
+		EnvVar: "MIRROR_BUCKET_NAME",
+	})
+
+	mirrorBucketPrefix := app.String(cli.StringOpt{
+		Name:   "mirrorBucketPrefix",
+		Value:  "",
+		Desc:   "Prefix for content going into the mirror bucket",
+		EnvVar: "MIRROR_BUCKET_PREFIX",
+	})
+
+	mirrorAwsRegion := app.String(cli.StringOpt{
+		Name:   "mirrorAwsRegion",
+		Value:  "",
+		Desc:   "AWS Region of the mirror bucket, defaults to awsRegion",
+		EnvVar: "MIRROR_AWS_REGION",
+	})
+
+	mirrorMode := app.String(cli.StringOpt{
+		Name:   "mirror-mode",
+		Value:  service.MirrorSync,
+		Desc:   "Whether writes to the mirror bucket complete before responding (sync) or are queued (async)",
+		EnvVar: "MIRROR_MODE",
+	})
+
+	mirrorLagTolerance := app.Int(cli.IntOpt{
+		Name:   "mirror-lag-tolerance",
+		Value:  300,
+		Desc:   "Seconds a mirror write can be pending before the healthcheck fails",
+		EnvVar: "MIRROR_LAG_TOLERANCE",
+	})
+
+	statsRefreshInterval := app.Int(cli.IntOpt{
+		Name:   "stats-refresh-interval",
+		Value:  0,
+		Desc:   "Seconds between the computations of the statistics served by /__stats, 0 disables them",
+		EnvVar: "STATS_REFRESH_INTERVAL",
+	})
+
+	countRefreshInterval := app.Int(cli.IntOpt{
+		Name:   "count-refresh-interval",
+		Value:  3600,
+		Desc:   "Seconds between full recounts of the cached count served by /__count, 0 counts on every request",
+		EnvVar: "COUNT_REFRESH_INTERVAL",
+	})
+
+	fallbackBucketName := app.String(cli.StringOpt{
+		Name:   "fallbackBucketName",
+		Value:  "",
+		Desc:   "Secondary bucket that reads fall back to when the primary bucket errors",
+		EnvVar: "FALLBACK_BUCKET_NAME",
+	})
+
+	fallbackBucketPrefix := app.String(cli.StringOpt{
+		Name:   "fallbackBucketPrefix",
+		Value:  "",
+		Desc:   "Prefix for content in the fallback bucket",
+		EnvVar: "FALLBACK_BUCKET_PREFIX",
+	})
+
+	fallbackAwsRegion := app.String(cli.StringOpt{
+		Name:   "fallbackAwsRegion",
+		Value:  "",
+		Desc:   "AWS Region of the fallback bucket, defaults to awsRegion",
+		EnvVar: "FALLBACK_AWS_REGION",
+	})
+
+	legacyBucketName := app.String(cli.StringOpt{
+		Name:   "legacyBucketName",
+		Value:  "",
+		Desc:   "Bucket being migrated from, items missing from bucketName are read from it and copied across",
+		EnvVar: "LEGACY_BUCKET_NAME",
+	})
+
+	legacyBucketPrefix := app.String(cli.StringOpt{
+		Name:   "legacyBucketPrefix",
+		Value:  "",
+		Desc:   "Prefix for content in the legacy bucket",
+		EnvVar: "LEGACY_BUCKET_PREFIX",
+	})
+
+	legacyAwsRegion := app.String(cli.StringOpt{
+		Name:   "legacyAwsRegion",
+		Value:  "",
+		Desc:   "AWS Region of the legacy bucket, defaults to awsRegion",
+		EnvVar: "LEGACY_AWS_REGION",
+	})
+
+	indexFields := app.Strings(cli.StringsOpt{
+		Name:   "index-fields",
+		Value:  []string{},
+		Desc:   "JSON fields of the payloads to index for lookups by /__lookup, nested fields are separated by dots, e.g. prefLabel,alternativeIdentifiers.TME",
+		EnvVar: "INDEX_FIELDS",
+	})
+
+	aliasesEnabled := app.Bool(cli.BoolOpt{
+		Name:   "aliases-enabled",
+		Value:  false,
+		Desc:   "Whether items can be read by their aliases, which are set by PUT /{uuid}/__aliases or from alias-field",
+		EnvVar: "ALIASES_ENABLED",
+	})
+
+	aliasField := app.String(cli.StringOpt{
+		Name:   "alias-field",
+		Value:  "",
+		Desc:   "JSON field of the payloads holding the UUIDs of their aliases, nested fields are separated by dots, e.g. sourceRepresentations.uuid",
+		EnvVar: "ALIAS_FIELD",
+	})
+
+	referencePaths := app.Strings(cli.StringsOpt{
+		Name:   "reference-paths",
+		Value:  []string{},
+		Desc:   "JSON paths of the payloads holding UUIDs they refer to, for /{uuid}/__referencedBy, nested fields are separated by dots, e.g. brands.id,annotations.id",
+		EnvVar: "REFERENCE_PATHS",
+	})
+
+	resourcesConfig := app.String(cli.StringOpt{
+		Name:   "resources-config",
+		Value:  "",
+		Desc:   "Path to a JSON file configuring multiple resources, overrides resourcePath, bucketName, bucketPrefix, workers, only-updates-enabled, consumer-topic, producer-topic, index-fields, aliases-enabled, alias-field and reference-paths",
+		EnvVar: "RESOURCES_CONFIG",
+	})
+
 	log := logger.NewUPPLogger(serviceName, *logLevel)
 
 	app.Action = func() {
@@ -143,30 +276,251 @@ func main() {
 			ConsumerGroup:           *consumerGroup,
 			Options:                 kafka.DefaultConsumerOptions(),
 		}
-		runServer(*appName, *port, *appSystemCode, *resourcePath, *awsRegion, *bucketName, *bucketPrefix, *wrkSize, *consumerTopic, consumerLagTolerance, consumerConfig, *onlyUpdatesEnabled, *requestLoggingEnabled, log)
+		producerConfig := kafka.ProducerConfig{
+			ClusterArn:              kafkaClusterArn,
+			BrokersConnectionString: *kafkaAddress,
+			Options:                 kafka.DefaultProducerOptions(),
+		}
+		resources := []service.ResourceConfig{
+			{
+				ResourcePath:         *resourcePath,
+				BucketName:           *bucketName,
+				BucketPrefix:         *bucketPrefix,
+				Workers:              *wrkSize,
+				OnlyUpdatesEnabled:   *onlyUpdatesEnabled,
+				ConsumerTopic:        *consumerTopic,
+				ProducerTopic:        *producerTopic,
+				MirrorBucketName:     *mirrorBucketName,
+				MirrorBucketPrefix:   *mirrorBucketPrefix,
+				MirrorAwsRegion:      *mirrorAwsRegion,
+				MirrorMode:           *mirrorMode,
+				FallbackBucketName:   *fallbackBucketName,
+				FallbackBucketPrefix: *fallbackBucketPrefix,
+				FallbackAwsRegion:    *fallbackAwsRegion,
+				LegacyBucketName:     *legacyBucketName,
+				LegacyBucketPrefix:   *legacyBucketPrefix,
+				LegacyAwsRegion:      *legacyAwsRegion,
+				IndexFields:          *indexFields,
+				AliasesEnabled:       *aliasesEnabled,
+				AliasField:           *aliasField,
+				ReferencePaths:       *referencePaths,
+			},
+		}
+		if *resourcesConfig != "" {
+			var err error
+			resources, err = service.LoadResourcesConfig(*resourcesConfig)
+			if err != nil {
+				log.WithError(err).Fatal("Failed to load resources config")
+			}
+		}
+		runServer(*appName, *port, *appSystemCode, *awsRegion, resources, *resourcesConfig != "", consumerLagTolerance, consumerConfig, producerConfig, time.Duration(*mirrorLagTolerance)*time.Second, time.Duration(*statsRefreshInterval)*time.Second, time.Duration(*countRefreshInterval)*time.Second, *requestLoggingEnabled, log)
 	}
 
+	app.Command("migrate", "Copy every object from a source bucket to a destination bucket", migrateCommand(log))
+	app.Command("diff", "Report objects missing, extra or differing between a source and a target bucket", diffCommand(log))
+	app.Command("import", "Write the items of an NDJSON export or a tar or zip archive directly to a bucket", importCommand(log))
+	app.Command("rebuild-index", "Rebuild the index of the items of a bucket used by /__lookup and /{uuid}/__referencedBy", rebuildIndexCommand(log))
+
 	log.Infof("Application started with args %s", os.Args)
 
 	app.Run(os.Args)
 }
 
-func runServer(appName string, port string, appSystemCode string, resourcePath string, awsRegion string, bucketName string, bucketPrefix string, wrks int, readTopic string, consumerLagTolerance *int, qConf kafka.ConsumerConfig, onlyUpdatesEnabled bool, requestLoggingEnabled bool, log *logger.UPPLogger) {
-	hc := &http.Client{
+func runServer(appName string, port string, appSystemCode string, awsRegion string, resources []service.ResourceConfig, perResourceChecks bool, consumerLagTolerance *int, qConf kafka.ConsumerConfig, pConf kafka.ProducerConfig, mirrorLagTolerance time.Duration, statsRefreshInterval time.Duration, countRefreshInterval time.Duration, requestLoggingEnabled bool, log *logger.UPPLogger) {
+	wrks := 0
+	for _, rc := range resources {
+		wrks += rc.Workers
+	}
+
+	hc := newHTTPClient(wrks + spareWorkers)
+
+	svc, err := newS3Client(awsRegion, hc)
+	if err != nil {
+		log.WithError(err).Fatal("Failed to create AWS session")
+	}
+
+	servicesRouter := mux.NewRouter()
+
+	var healthcheck *service.HealthCheck
+	if perResourceChecks {
+		healthcheck = service.NewResourcesHealthCheck(svc, appName, appSystemCode, log)
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+
+	for _, rc := range resources {
+		store := service.NewS3Writer(svc, rc.BucketName, rc.BucketPrefix, rc.OnlyUpdatesEnabled, log)
+		w := store
+		if len(rc.IndexFields) > 0 {
+			idx, err := service.NewIndex(svc, rc.BucketName, rc.BucketPrefix, rc.IndexFields)
+			if err != nil {
+				log.WithError(err).Fatalf("Failed to create index for %s", rc.ResourcePath)
+			}
+			w = service.NewIndexWriter(w, idx, log)
+			service.IndexHandlers(servicesRouter, service.NewIndexHandler(idx, log), rc.ResourcePath)
+		}
+		if len(rc.ReferencePaths) > 0 {
+			refs, err := service.NewReferences(svc, rc.BucketName, rc.BucketPrefix, rc.ReferencePaths)
+			if err != nil {
+				log.WithError(err).Fatalf("Failed to create references for %s", rc.ResourcePath)
+			}
+			w = service.NewIndexWriter(w, refs, log)
+			service.ReferenceHandlers(servicesRouter, service.NewReferenceHandler(refs, log), rc.ResourcePath)
+		}
+		var aliases *service.Aliases
+		if rc.AliasesEnabled {
+			aliases = service.NewAliases(svc, rc.BucketName, rc.BucketPrefix)
+			w, err = service.NewAliasWriter(w, aliases, rc.AliasField, log)
+			if err != nil {
+				log.WithError(err).Fatalf("Failed to create alias writer for %s", rc.ResourcePath)
+			}
+			service.AliasHandlers(servicesRouter, service.NewAliasHandler(aliases, rc.AliasField, log), rc.ResourcePath)
+		}
+		var mw *service.MirrorWriter
+		var diffTarget service.BucketLocation
+		if rc.MirrorBucketName != "" {
+			mirrorSvc, err := newS3Client(regionOrDefault(rc.MirrorAwsRegion, awsRegion), hc)
+			if err != nil {
+				log.WithError(err).Fatal("Failed to create AWS session for mirror bucket")
+			}
+			mw, err = service.NewMirrorWriter(w, service.NewS3Writer(mirrorSvc, rc.MirrorBucketName, rc.MirrorBucketPrefix, false, log), rc.MirrorMode, log)
+			if err != nil {
+				log.WithError(err).Fatalf("Failed to create mirror writer for bucket %s", rc.MirrorBucketName)
+			}
+			go mw.Start(stop)
+			w = mw
+			diffTarget = service.BucketLocation{Svc: mirrorSvc, Bucket: rc.MirrorBucketName, Prefix: rc.MirrorBucketPrefix, Layout: service.LayoutPartitioned}
+		}
+		r := service.NewS3Reader(svc, rc.BucketName, rc.BucketPrefix, int16(rc.Workers), log)
+		if rc.FallbackBucketName != "" {
+			fallbackSvc, err := newS3Client(regionOrDefault(rc.FallbackAwsRegion, awsRegion), hc)
+			if err != nil {
+				log.WithError(err).Fatal("Failed to create AWS session for fallback bucket")
+			}
+			fr := service.NewS3Reader(fallbackSvc, rc.FallbackBucketName, rc.FallbackBucketPrefix, int16(rc.Workers), log)
+			r = service.NewFallbackReader(r, rc.BucketName, fr, rc.FallbackBucketName, log)
+		}
+		if rc.LegacyBucketName != "" {
+			legacySvc, err := newS3Client(regionOrDefault(rc.LegacyAwsRegion, awsRegion), hc)
+			if err != nil {
+				log.WithError(err).Fatal("Failed to create AWS session for legacy bucket")
+			}
+			lr := service.NewS3Reader(legacySvc, rc.LegacyBucketName, rc.LegacyBucketPrefix, int16(rc.Workers), log)
+			copier := service.NewS3ObjectCopier(svc, rc.LegacyBucketName, rc.LegacyBucketPrefix, rc.BucketName, rc.BucketPrefix)
+			mr := service.NewMigrationReader(r, lr, rc.LegacyBucketName, copier, service.NewTombstones(svc, rc.BucketName, rc.BucketPrefix), log)
+			go mr.Start(rc.Workers, stop)
+			service.MigrationHandlers(servicesRouter, service.NewMigrationHandler(mr, log), rc.ResourcePath)
+
+			w = service.NewMigrationWriter(w, mr)
+			r = mr
+		}
+		if countRefreshInterval > 0 {
+			cr := service.NewCachedCountReader(r, countRefreshInterval, log)
+			go cr.Start(stop)
+			w = service.NewCountingWriter(w, store, cr, log)
+			r = cr
+		}
+
+		var producer *kafka.Producer
+		if rc.ProducerTopic != "" {
+			pc := pConf
+			pc.Topic = rc.ProducerTopic
+			producer, err = kafka.NewProducer(pc)
+			if err != nil {
+				log.WithError(err).Fatalf("could not create Kafka producer for %s and topic %s", pConf.BrokersConnectionString, rc.ProducerTopic)
+			}
+			defer producer.Close()
+			w = service.NewEventWriter(w, store, producer, log)
+		}
+
+		if aliases != nil {
+			r = service.NewAliasReader(r, aliases)
+		}
+
+		wh := service.NewWriterHandler(w, r, log)
+		rh := service.NewReaderHandler(r, log)
+
+		service.Handlers(servicesRouter, wh, rh, rc.ResourcePath)
+		service.ImportHandlers(servicesRouter, service.NewImportHandler(service.NewImporter(w, rc.Workers, log), log), rc.ResourcePath)
+		service.PresignHandlers(servicesRouter, service.NewPresignHandler(service.NewPresigner(svc, rc.BucketName, rc.BucketPrefix), rc, log), rc.ResourcePath)
+
+		if statsRefreshInterval > 0 {
+			stats := service.NewStatsCollector(svc, rc.BucketName, rc.BucketPrefix, statsRefreshInterval, log)
+			go stats.Start(stop)
+			service.StatsHandlers(servicesRouter, service.NewStatsHandler(stats, log), rc.ResourcePath)
+		}
+
+		diffSource := service.BucketLocation{Svc: svc, Bucket: rc.BucketName, Prefix: rc.BucketPrefix, Layout: service.LayoutPartitioned}
+		service.DiffHandlers(servicesRouter, service.NewDiffHandler(diffSource, diffTarget, log), rc.ResourcePath)
+
+		var consumer *kafka.Consumer
+		if rc.ConsumerTopic != "" {
+			qp := service.NewQProcessor(w, log)
+			topics := []*kafka.Topic{kafka.NewTopic(rc.ConsumerTopic, kafka.WithLagTolerance(int64(*consumerLagTolerance)))}
+			consumer, err = kafka.NewConsumer(qConf, topics, log)
+			if err != nil {
+				log.WithError(err).Fatalf("could not create Kafka consumer for %s and topic %s", qConf.BrokersConnectionString, rc.ConsumerTopic)
+			}
+			go consumer.Start(qp.ProcessMsg)
+			defer consumer.Close()
+		}
+
+		if perResourceChecks {
+			healthcheck.AddResource(rc.ResourcePath, rc.BucketName, consumer)
+		} else {
+			healthcheck = service.NewHealthCheck(consumer, svc, appName, appSystemCode, rc.BucketName, log)
+		}
+		if mw != nil {
+			name := ""
+			if perResourceChecks {
+				name = rc.ResourcePath
+			}
+			healthcheck.AddMirror(name, mw, mirrorLagTolerance)
+		}
+		if producer != nil {
+			name := ""
+			if perResourceChecks {
+				name = rc.ResourcePath
+			}
+			healthcheck.AddProducer(name, producer)
+		}
+	}
+
+	log.Infof("listening on %v", port)
+
+	service.AddAdminHandlers(servicesRouter, requestLoggingEnabled, log, healthcheck)
+	if err := http.ListenAndServe(":"+port, nil); err != nil {
+		log.WithError(err).Fatal("Unable to start server.")
+	}
+
+}
+
+func newHTTPClient(maxIdleConns int) *http.Client {
+	return &http.Client{
 		Transport: &http.Transport{
 			Proxy: http.ProxyFromEnvironment,
 			DialContext: (&net.Dialer{
 				Timeout:   30 * time.Second,
 				KeepAlive: 30 * time.Second,
 			}).DialContext,
-			MaxIdleConns:          wrks + spareWorkers,
+			MaxIdleConns:          maxIdleConns,
 			IdleConnTimeout:       90 * time.Second,
-			MaxIdleConnsPerHost:   wrks + spareWorkers,
+			MaxIdleConnsPerHost:   maxIdleConns,
 			TLSHandshakeTimeout:   3 * time.Second,
 			ExpectContinueTimeout: 1 * time.Second,
 		},
 	}
+}
 
+func regionOrDefault(region string, defaultRegion string) string {
+	if region == "" {
+		return defaultRegion
+	}
+	return region
+}
+
+func newS3Client(awsRegion string, hc *http.Client) (*s3.S3, error) {
 	var sess *session.Session
 	var err error
 	if os.Getenv("ENV") == "local" {
@@ -188,41 +542,10 @@ func runServer(appName string, port string, appSystemCode string, resourcePath s
 		sess, err = session.NewSession(cfg)
 	} else {
 		// NewSession will read envvars set by the EKS Pod Identity webhook
-		sess, err = session.NewSession()
+		sess, err = session.NewSession(&aws.Config{Region: aws.String(awsRegion)})
 	}
-
 	if err != nil {
-		log.WithError(err).Fatal("Failed to create AWS session")
+		return nil, err
 	}
-	svc := s3.New(sess)
-
-	w := service.NewS3Writer(svc, bucketName, bucketPrefix, onlyUpdatesEnabled, log)
-	r := service.NewS3Reader(svc, bucketName, bucketPrefix, int16(wrks), log)
-
-	wh := service.NewWriterHandler(w, r, log)
-	rh := service.NewReaderHandler(r, log)
-
-	servicesRouter := mux.NewRouter()
-
-	service.Handlers(servicesRouter, wh, rh, resourcePath)
-
-	log.Infof("listening on %v", port)
-
-	var consumer *kafka.Consumer
-	if readTopic != "" {
-		qp := service.NewQProcessor(w, log)
-		topics := []*kafka.Topic{kafka.NewTopic(readTopic, kafka.WithLagTolerance(int64(*consumerLagTolerance)))}
-		consumer, err = kafka.NewConsumer(qConf, topics, log)
-		if err != nil {
-			log.WithError(err).Fatalf("could not create Kafka consumer for %s and topic %s", qConf.BrokersConnectionString, readTopic)
-		}
-		go consumer.Start(qp.ProcessMsg)
-		defer consumer.Close()
-	}
-	healthcheck := service.NewHealthCheck(consumer, svc, appName, appSystemCode, bucketName, log)
-	service.AddAdminHandlers(servicesRouter, requestLoggingEnabled, log, healthcheck)
-	if err := http.ListenAndServe(":"+port, nil); err != nil {
-		log.WithError(err).Fatal("Unable to start server.")
-	}
-
+	return s3.New(sess), nil
 }
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/Financial-Times/generic-rw-s3/service"
+	"github.com/Financial-Times/go-logger/v2"
+	cli "github.com/jawher/mow.cli"
+)
+
+func migrateCommand(log *logger.UPPLogger) func(cmd *cli.Cmd) {
+	return func(cmd *cli.Cmd) {
+		sourceBucket := cmd.String(cli.StringOpt{
+			Name:   "source-bucket",
+			Desc:   "Bucket to copy objects from",
+			EnvVar: "MIGRATE_SOURCE_BUCKET",
+		})
+		sourcePrefix := cmd.String(cli.StringOpt{
+			Name:   "source-prefix",
+			Value:  "",
+			Desc:   "Prefix of the objects in the source bucket",
+			EnvVar: "MIGRATE_SOURCE_PREFIX",
+		})
+		sourceRegion := cmd.String(cli.StringOpt{
+			Name:   "source-region",
+			Value:  "eu-west-1",
+			Desc:   "AWS Region of the source bucket",
+			EnvVar: "MIGRATE_SOURCE_REGION",
+		})
+		sourceLayout := cmd.String(cli.StringOpt{
+			Name:   "source-layout",
+			Value:  service.LayoutPartitioned,
+			Desc:   "Key layout in the source bucket, partitioned or flat",
+			EnvVar: "MIGRATE_SOURCE_LAYOUT",
+		})
+		destBucket := cmd.String(cli.StringOpt{
+			Name:   "dest-bucket",
+			Desc:   "Bucket to copy objects to",
+			EnvVar: "MIGRATE_DEST_BUCKET",
+		})
+		destPrefix := cmd.String(cli.StringOpt{
+			Name:   "dest-prefix",
+			Value:  "",
+			Desc:   "Prefix of the objects in the destination bucket",
+			EnvVar: "MIGRATE_DEST_PREFIX",
+		})
+		destRegion := cmd.String(cli.StringOpt{
+			Name:   "dest-region",
+			Value:  "",
+			Desc:   "AWS Region of the destination bucket, defaults to source-region",
+			EnvVar: "MIGRATE_DEST_REGION",
+		})
+		destLayout := cmd.String(cli.StringOpt{
+			Name:   "dest-layout",
+			Value:  service.LayoutPartitioned,
+			Desc:   "Key layout in the destination bucket, partitioned or flat",
+			EnvVar: "MIGRATE_DEST_LAYOUT",
+		})
+		workers := cmd.Int(cli.IntOpt{
+			Name:   "workers",
+			Value:  10,
+			Desc:   "Number of objects copied in parallel",
+			EnvVar: "MIGRATE_WORKERS",
+		})
+		checkpointFile := cmd.String(cli.StringOpt{
+			Name:   "checkpoint-file",
+			Value:  "",
+			Desc:   "File progress is saved to and resumed from",
+			EnvVar: "MIGRATE_CHECKPOINT_FILE",
+		})
+		dryRun := cmd.Bool(cli.BoolOpt{
+			Name:   "dry-run",
+			Value:  false,
+			Desc:   "List the objects that would be copied without copying them",
+			EnvVar: "MIGRATE_DRY_RUN",
+		})
+		overwrite := cmd.Bool(cli.BoolOpt{
+			Name:   "overwrite",
+			Value:  false,
+			Desc:   "Overwrite objects already present in the destination",
+			EnvVar: "MIGRATE_OVERWRITE",
+		})
+		verify := cmd.Bool(cli.BoolOpt{
+			Name:   "verify",
+			Value:  true,
+			Desc:   "Compare every source object to its copy once copying is done",
+			EnvVar: "MIGRATE_VERIFY",
+		})
+
+		cmd.Action = func() {
+			hc := newHTTPClient(*workers + spareWorkers)
+			srcSvc, err := newS3Client(*sourceRegion, hc)
+			if err != nil {
+				log.WithError(err).Fatal("Failed to create AWS session for source bucket")
+			}
+			dstSvc, err := newS3Client(regionOrDefault(*destRegion, *sourceRegion), hc)
+			if err != nil {
+				log.WithError(err).Fatal("Failed to create AWS session for destination bucket")
+			}
+
+			m, err := service.NewBucketMigrator(
+				service.BucketLocation{Svc: srcSvc, Bucket: *sourceBucket, Prefix: *sourcePrefix, Layout: *sourceLayout},
+				service.BucketLocation{Svc: dstSvc, Bucket: *destBucket, Prefix: *destPrefix, Layout: *destLayout},
+				*workers, *checkpointFile, *dryRun, *overwrite, log)
+			if err != nil {
+				log.WithError(err).Fatal("Invalid migration")
+			}
+
+			encoder := json.NewEncoder(os.Stdout)
+			summary, err := m.Migrate()
+			encoder.Encode(summary)
+			if err != nil {
+				log.WithError(err).Fatal("Migration failed")
+			}
+			if summary.Failed > 0 {
+				log.Errorf("Failed to copy %d objects, run again to retry them", summary.Failed)
+				cli.Exit(1)
+			}
+			if *dryRun || !*verify {
+				return
+			}
+
+			vs, err := m.Verify()
+			encoder.Encode(vs)
+			if err != nil {
+				log.WithError(err).Fatal("Verification failed")
+			}
+			if vs.Missing > 0 || vs.Mismatched > 0 || vs.Failed > 0 {
+				log.Errorf("Verification found %d missing and %d mismatched objects", vs.Missing, vs.Mismatched)
+				cli.Exit(1)
+			}
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/Financial-Times/generic-rw-s3/service"
+	"github.com/Financial-Times/go-logger/v2"
+	cli "github.com/jawher/mow.cli"
+)
+
+func rebuildIndexCommand(log *logger.UPPLogger) func(cmd *cli.Cmd) {
+	return func(cmd *cli.Cmd) {
+		bucket := cmd.String(cli.StringOpt{
+			Name:   "bucket",
+			Desc:   "Bucket of the items to index",
+			EnvVar: "REBUILD_INDEX_BUCKET",
+		})
+		prefix := cmd.String(cli.StringOpt{
+			Name:   "prefix",
+			Value:  "",
+			Desc:   "Prefix of the objects in the bucket",
+			EnvVar: "REBUILD_INDEX_PREFIX",
+		})
+		region := cmd.String(cli.StringOpt{
+			Name:   "region",
+			Value:  "eu-west-1",
+			Desc:   "AWS Region of the bucket",
+			EnvVar: "REBUILD_INDEX_REGION",
+		})
+		fields := cmd.Strings(cli.StringsOpt{
+			Name:   "fields",
+			Value:  []string{},
+			Desc:   "JSON fields to index, as configured with index-fields",
+			EnvVar: "REBUILD_INDEX_FIELDS",
+		})
+		referencePaths := cmd.Strings(cli.StringsOpt{
+			Name:   "reference-paths",
+			Value:  []string{},
+			Desc:   "JSON paths of the references to record, as configured with reference-paths",
+			EnvVar: "REBUILD_INDEX_REFERENCE_PATHS",
+		})
+		path := cmd.String(cli.StringOpt{
+			Name:   "path",
+			Value:  "",
+			Desc:   "Path of the items to index when the prefix is empty",
+			EnvVar: "REBUILD_INDEX_PATH",
+		})
+		workers := cmd.Int(cli.IntOpt{
+			Name:   "workers",
+			Value:  10,
+			Desc:   "Number of items indexed in parallel",
+			EnvVar: "REBUILD_INDEX_WORKERS",
+		})
+
+		cmd.Action = func() {
+			svc, err := newS3Client(*region, newHTTPClient(*workers+spareWorkers))
+			if err != nil {
+				log.WithError(err).Fatal("Failed to create AWS session")
+			}
+
+			summaries, err := service.RebuildIndexes(svc, *bucket, *prefix, *path, *fields, *referencePaths, *workers, log)
+			json.NewEncoder(os.Stdout).Encode(summaries)
+			if err != nil {
+				log.WithError(err).Fatal("Rebuild failed")
+			}
+			if failed := summaries.Failed(); failed > 0 {
+				log.Errorf("Failed to index %d items", failed)
+				cli.Exit(1)
+			}
+		}
+	}
+}
@@ -0,0 +1,409 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Financial-Times/go-logger/v2"
+	transactionid "github.com/Financial-Times/transactionid-utils-go"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/gorilla/mux"
+)
+
+const aliasesPrefix = "__aliases/"
+
+// Aliases maps the alias UUIDs of items, such as the UUIDs concorded into a concept, to the item's canonical UUID.
+// They are stored in the items' bucket under the reserved __aliases/ prefix: an object per alias holding its canonical
+// UUID, __aliases/<scope>/a/<alias>, and a record of the aliases of each canonical UUID, __aliases/<scope>/c/<uuid>.
+type Aliases struct {
+	svc          s3iface.S3API
+	bucketName   string
+	bucketPrefix string
+	// records serialises the updates of the alias and record objects, so concurrent updates cannot leave an alias
+	// pointing at the wrong UUID or in the wrong record. Updates made by other instances are not serialised.
+	records *keyLocks
+}
+
+func NewAliases(svc s3iface.S3API, bucketName string, bucketPrefix string) *Aliases {
+	return &Aliases{svc: svc, bucketName: bucketName, bucketPrefix: bucketPrefix, records: &keyLocks{}}
+}
+
+func (a *Aliases) aliasKey(path string, alias string) string {
+	return reservedPrefix(aliasesPrefix, a.bucketPrefix, path) + "a/" + alias
+}
+
+func (a *Aliases) recordKey(path string, uuid string) string {
+	return reservedPrefix(aliasesPrefix, a.bucketPrefix, path) + "c/" + uuid
+}
+
+// Resolve returns the canonical UUID of an alias, reporting whether it is one.
+func (a *Aliases) Resolve(alias string, path string) (string, bool, error) {
+	b, found, err := a.get(a.aliasKey(path, alias))
+	if err != nil || !found {
+		return "", false, err
+	}
+	return string(b), true, nil
+}
+
+// Get returns the aliases of a canonical UUID.
+func (a *Aliases) Get(uuid string, path string) ([]string, error) {
+	b, found, err := a.get(a.recordKey(path, uuid))
+	if err != nil || !found {
+		return []string{}, err
+	}
+	var aliases []string
+	if err := json.Unmarshal(b, &aliases); err != nil {
+		return nil, fmt.Errorf("could not decode aliases of %s: %w", uuid, err)
+	}
+	return aliases, nil
+}
+
+// Set replaces the aliases of a canonical UUID. Aliases are moved from any other UUID they were an alias of.
+// The record of the UUID, its old and new aliases and the records of the UUIDs they are moved from are locked while
+// they are updated. Which of those there are is only known once the locked objects have been read, so the update
+// starts again with more objects locked when it finds others.
+func (a *Aliases) Set(uuid string, path string, aliases []string) error {
+	aliases = append([]string(nil), aliases...)
+	sort.Strings(aliases)
+	aliases = slices.Compact(aliases)
+	for _, alias := range aliases {
+		if !uuidPattern.MatchString(alias) || alias == uuid {
+			return fmt.Errorf("invalid alias %q of %s", alias, uuid)
+		}
+	}
+
+	locked := a.setKeys(uuid, path, aliases, nil, nil)
+	for {
+		unlock := a.records.lockAll(locked)
+		old, moved, err := a.current(uuid, path, aliases)
+		if err != nil {
+			unlock()
+			return err
+		}
+		needed := a.setKeys(uuid, path, aliases, old, moved)
+		if isSubset(needed, locked) {
+			err = a.replace(uuid, path, aliases, old, moved)
+			unlock()
+			return err
+		}
+		unlock()
+		locked = append(locked, needed...)
+	}
+}
+
+// setKeys returns the keys of the objects updated when setting the aliases of a UUID.
+func (a *Aliases) setKeys(uuid string, path string, aliases []string, old []string, moved map[string]string) []string {
+	keys := []string{a.recordKey(path, uuid)}
+	for _, alias := range append(append([]string(nil), aliases...), old...) {
+		keys = append(keys, a.aliasKey(path, alias))
+	}
+	for _, canonical := range moved {
+		keys = append(keys, a.recordKey(path, canonical))
+	}
+	return keys
+}
+
+// current returns the aliases of a UUID and the other UUIDs the new aliases are moved from.
+func (a *Aliases) current(uuid string, path string, aliases []string) ([]string, map[string]string, error) {
+	old, err := a.Get(uuid, path)
+	if err != nil {
+		return nil, nil, err
+	}
+	moved := map[string]string{}
+	for _, alias := range aliases {
+		if slices.Contains(old, alias) {
+			continue
+		}
+		canonical, found, err := a.Resolve(alias, path)
+		if err != nil {
+			return nil, nil, err
+		}
+		if found && canonical != uuid {
+			moved[alias] = canonical
+		}
+	}
+	return old, moved, nil
+}
+
+func isSubset(keys []string, of []string) bool {
+	for _, key := range keys {
+		if !slices.Contains(of, key) {
+			return false
+		}
+	}
+	return true
+}
+
+// replace replaces the old aliases of a UUID, moving the aliases of other UUIDs.
+func (a *Aliases) replace(uuid string, path string, aliases []string, old []string, moved map[string]string) error {
+	for _, alias := range old {
+		if slices.Contains(aliases, alias) {
+			continue
+		}
+		// The alias may have been moved to another UUID since
+		canonical, found, err := a.Resolve(alias, path)
+		if err != nil {
+			return err
+		}
+		if found && canonical == uuid {
+			if err := a.delete(a.aliasKey(path, alias)); err != nil {
+				return err
+			}
+		}
+	}
+	for _, alias := range aliases {
+		if slices.Contains(old, alias) {
+			continue
+		}
+		if canonical, ok := moved[alias]; ok {
+			if err := a.drop(canonical, path, alias); err != nil {
+				return err
+			}
+		}
+		if err := a.put(a.aliasKey(path, alias), []byte(uuid)); err != nil {
+			return err
+		}
+	}
+
+	if len(aliases) == 0 {
+		if len(old) == 0 {
+			return nil
+		}
+		return a.delete(a.recordKey(path, uuid))
+	}
+	b, err := json.Marshal(aliases)
+	if err != nil {
+		return err
+	}
+	return a.put(a.recordKey(path, uuid), b)
+}
+
+// drop removes an alias which has been moved to another UUID from the record of its previous canonical UUID.
+func (a *Aliases) drop(uuid string, path string, alias string) error {
+	old, err := a.Get(uuid, path)
+	if err != nil {
+		return err
+	}
+	aliases := slices.DeleteFunc(old, func(s string) bool { return s == alias })
+	if len(aliases) == 0 {
+		return a.delete(a.recordKey(path, uuid))
+	}
+	b, err := json.Marshal(aliases)
+	if err != nil {
+		return err
+	}
+	return a.put(a.recordKey(path, uuid), b)
+}
+
+func (a *Aliases) get(key string) ([]byte, bool, error) {
+	resp, err := a.svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(a.bucketName),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		if e, ok := err.(awserr.Error); ok && e.Code() == s3.ErrCodeNoSuchKey {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	return b, err == nil, err
+}
+
+func (a *Aliases) put(key string, body []byte) error {
+	_, err := a.svc.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(a.bucketName),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader(body),
+	})
+	return err
+}
+
+func (a *Aliases) delete(key string) error {
+	_, err := a.svc.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(a.bucketName),
+		Key:    aws.String(key),
+	})
+	return err
+}
+
+// AliasWriter removes the aliases of deleted items and, with a field configured, sets the aliases of written items
+// to the UUIDs in that field of their JSON payload. Failing to update the aliases does not fail the write.
+type AliasWriter struct {
+	Writer
+	aliases *Aliases
+	field   []string
+	log     *logger.UPPLogger
+}
+
+func NewAliasWriter(writer Writer, aliases *Aliases, field string, log *logger.UPPLogger) (*AliasWriter, error) {
+	w := &AliasWriter{Writer: writer, aliases: aliases, log: log}
+	if field == "" {
+		return w, nil
+	}
+	w.field = strings.Split(field, ".")
+	for _, key := range w.field {
+		if key == "" {
+			return nil, fmt.Errorf("invalid alias field %q", field)
+		}
+	}
+	return w, nil
+}
+
+func (w *AliasWriter) Write(uuid string, path string, b *[]byte, ct string, tid string, ignoreHash bool) (Status, error) {
+	status, err := w.Writer.Write(uuid, path, b, ct, tid, ignoreHash)
+	if err != nil || (status != CREATED && status != UPDATED) || w.field == nil {
+		return status, err
+	}
+
+	var doc map[string]interface{}
+	if err := json.Unmarshal(*b, &doc); err != nil {
+		doc = nil
+	}
+	var aliases []string
+	for _, v := range fieldValues(doc, w.field) {
+		if uuidPattern.MatchString(v) && v != uuid {
+			aliases = append(aliases, v)
+		}
+	}
+	if err := w.aliases.Set(uuid, path, aliases); err != nil {
+		w.log.WithError(err).WithTransactionID(tid).WithUUID(uuid).Error("Failed to update aliases")
+	}
+	return status, nil
+}
+
+func (w *AliasWriter) Delete(uuid string, path string, tid string) error {
+	if err := w.Writer.Delete(uuid, path, tid); err != nil {
+		return err
+	}
+	if err := w.aliases.Set(uuid, path, nil); err != nil {
+		w.log.WithError(err).WithTransactionID(tid).WithUUID(uuid).Error("Failed to remove aliases")
+	}
+	return nil
+}
+
+type aliasResolver interface {
+	Resolve(alias string, path string) (string, bool, error)
+}
+
+// AliasReader is a Reader which can resolve aliases, so items requested by an alias are read by their canonical UUID.
+type AliasReader struct {
+	Reader
+	aliases *Aliases
+}
+
+func NewAliasReader(reader Reader, aliases *Aliases) *AliasReader {
+	return &AliasReader{Reader: reader, aliases: aliases}
+}
+
+func (r *AliasReader) withServeHook(onServe func(bucket string)) Reader {
+	c := *r
+	c.Reader = hookServe(r.Reader, onServe)
+	return &c
+}
+
+// CachedCount returns the cached count of the wrapped reader, when it has one.
+func (r *AliasReader) CachedCount(fresh bool) (int64, time.Duration, error) {
+	if cc, ok := r.Reader.(cachedCounter); ok {
+		return cc.CachedCount(fresh)
+	}
+	return 0, 0, errCountNotCached
+}
+
+func (r *AliasReader) Resolve(alias string, path string) (string, bool, error) {
+	return r.aliases.Resolve(alias, path)
+}
+
+// getAlias reads the canonical item of an alias, pointing the response's Content-Location at the canonical UUID.
+func getAlias(rw http.ResponseWriter, r *http.Request, reader Reader, alias string, path string) (bool, io.ReadCloser, *string, error) {
+	ar, ok := reader.(aliasResolver)
+	if !ok {
+		return false, nil, nil, nil
+	}
+	canonical, found, err := ar.Resolve(alias, path)
+	if err != nil || !found {
+		return false, nil, nil, err
+	}
+
+	f, i, ct, err := reader.Get(canonical, path)
+	if f {
+		location := strings.TrimSuffix(r.URL.Path, alias) + canonical
+		if path != "" {
+			location += "?" + url.Values{"path": {path}}.Encode()
+		}
+		rw.Header().Set("Content-Location", location)
+	}
+	return f, i, ct, err
+}
+
+type AliasHandler struct {
+	aliases *Aliases
+	field   string
+	log     *logger.UPPLogger
+}
+
+// NewAliasHandler serves the aliases of items. With an alias field configured the aliases are set from the payloads
+// only, as setting them by request would be undone by the next write of the item.
+func NewAliasHandler(aliases *Aliases, field string, log *logger.UPPLogger) AliasHandler {
+	return AliasHandler{aliases: aliases, field: field, log: log}
+}
+
+// HandleGetAliases returns the aliases of a UUID.
+func (ah *AliasHandler) HandleGetAliases(rw http.ResponseWriter, r *http.Request) {
+	tid := transactionid.GetTransactionIDFromRequest(r)
+	uuid := mux.Vars(r)["uuid"]
+	aliases, err := ah.aliases.Get(uuid, r.URL.Query().Get("path"))
+	if err != nil {
+		readerServiceUnavailable(r.URL.RequestURI(), err, rw, tid, ah.log)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(rw).Encode(aliases); err != nil {
+		ah.log.WithError(err).WithTransactionID(tid).WithUUID(uuid).Error("Error writing aliases")
+	}
+}
+
+// HandlePutAliases replaces the aliases of a UUID with the JSON array of UUIDs in the body.
+func (ah *AliasHandler) HandlePutAliases(rw http.ResponseWriter, r *http.Request) {
+	tid := transactionid.GetTransactionIDFromRequest(r)
+	uuid := mux.Vars(r)["uuid"]
+	if ah.field != "" {
+		respondBadRequest(fmt.Errorf("aliases are set from the %s field of the payloads", ah.field), rw)
+		return
+	}
+	var aliases []string
+	if err := json.NewDecoder(r.Body).Decode(&aliases); err != nil {
+		respondBadRequest(errors.New("body must be a JSON array of alias UUIDs"), rw)
+		return
+	}
+	for _, alias := range aliases {
+		if !uuidPattern.MatchString(alias) || alias == uuid {
+			respondBadRequest(fmt.Errorf("invalid alias %q", alias), rw)
+			return
+		}
+	}
+
+	if err := ah.aliases.Set(uuid, r.URL.Query().Get("path"), aliases); err != nil {
+		writerServiceUnavailable(uuid, err, rw, tid, ah.log)
+		return
+	}
+	ah.log.WithTransactionID(tid).WithUUID(uuid).Infof("Aliases set to %v", aliases)
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	rw.Write([]byte("{\"message\":\"Updated aliases\"}"))
+}
@@ -0,0 +1,277 @@
+package service
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Financial-Times/go-logger/v2"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	canonicalUUID  = "2136f8ad-e94e-45cb-b616-336f38533214"
+	aliasUUID      = "5b0be968-b8a3-4d66-8f1b-7b2b7ae1ff7c"
+	otherAliasUUID = "9a1c3f5e-2b4d-4e6f-8a0b-1c2d3e4f5a6b"
+)
+
+func TestAliasesSetAndResolve(t *testing.T) {
+	s := newBucketMock(nil)
+	a := NewAliases(s, "bucketName", "concepts")
+
+	assert.NoError(t, a.Set(canonicalUUID, "", []string{otherAliasUUID, aliasUUID, aliasUUID}))
+	assert.Equal(t, []string{
+		"__aliases/concepts/a/" + aliasUUID,
+		"__aliases/concepts/a/" + otherAliasUUID,
+		"__aliases/concepts/c/" + canonicalUUID,
+	}, s.keys(""))
+	aliases, err := a.Get(canonicalUUID, "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{aliasUUID, otherAliasUUID}, aliases)
+
+	canonical, found, err := a.Resolve(aliasUUID, "")
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, canonicalUUID, canonical)
+	_, found, err = a.Resolve(aliasUUID, "TestDirectory")
+	assert.NoError(t, err)
+	assert.True(t, found, "paths are ignored with a bucket prefix, as for items")
+
+	assert.NoError(t, a.Set(canonicalUUID, "", []string{aliasUUID}))
+	_, found, err = a.Resolve(otherAliasUUID, "")
+	assert.NoError(t, err)
+	assert.False(t, found)
+
+	assert.NoError(t, a.Set(canonicalUUID, "", nil))
+	assert.Empty(t, s.keys(""))
+	aliases, err = a.Get(canonicalUUID, "")
+	assert.NoError(t, err)
+	assert.Empty(t, aliases)
+
+	assert.EqualError(t, a.Set(canonicalUUID, "", []string{canonicalUUID}), `invalid alias "`+canonicalUUID+`" of `+canonicalUUID)
+	assert.EqualError(t, a.Set(canonicalUUID, "", []string{"TME-1"}), `invalid alias "TME-1" of `+canonicalUUID)
+}
+
+func TestAliasesMovedToAnotherUUID(t *testing.T) {
+	s := newBucketMock(nil)
+	a := NewAliases(s, "bucketName", "")
+
+	assert.NoError(t, a.Set(canonicalUUID, "", []string{aliasUUID}))
+	assert.NoError(t, a.Set(otherAliasUUID, "", []string{aliasUUID}))
+	canonical, _, err := a.Resolve(aliasUUID, "")
+	assert.NoError(t, err)
+	assert.Equal(t, otherAliasUUID, canonical)
+	aliases, err := a.Get(canonicalUUID, "")
+	assert.NoError(t, err)
+	assert.Empty(t, aliases)
+
+	assert.NoError(t, a.Set(canonicalUUID, "", nil))
+	canonical, _, err = a.Resolve(aliasUUID, "")
+	assert.NoError(t, err)
+	assert.Equal(t, otherAliasUUID, canonical, "removing aliases leaves those moved to another UUID")
+}
+
+func TestAliasesConcurrentUpdatesStayConsistent(t *testing.T) {
+	s := newBucketMock(nil)
+	s.latency = time.Millisecond
+	a := NewAliases(s, "bucketName", "")
+	canonicals := []string{canonicalUUID, "b8e2a6c4-1f0d-4e3a-9c7b-5d2f8e1a3b4c", "c9f3b7d5-2a1e-4f4b-8d8c-6e3a9f2b4c5d"}
+	aliases := []string{aliasUUID, otherAliasUUID, "d1a4c8e6-3b2f-4a5c-9e9d-7f4b1a3c5d6e"}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 60; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var set []string
+			for j, alias := range aliases {
+				if (i>>j)&1 == 1 {
+					set = append(set, alias)
+				}
+			}
+			assert.NoError(t, a.Set(canonicals[i%len(canonicals)], "", set))
+		}(i)
+	}
+	wg.Wait()
+
+	for _, alias := range aliases {
+		canonical, found, err := a.Resolve(alias, "")
+		assert.NoError(t, err)
+		if !found {
+			continue
+		}
+		recorded, err := a.Get(canonical, "")
+		assert.NoError(t, err)
+		assert.Contains(t, recorded, alias, "an alias is in the record of the UUID it points at")
+	}
+	for _, canonical := range canonicals {
+		recorded, err := a.Get(canonical, "")
+		assert.NoError(t, err)
+		for _, alias := range recorded {
+			resolved, _, err := a.Resolve(alias, "")
+			assert.NoError(t, err)
+			assert.Equal(t, canonical, resolved, "an alias is only recorded for the UUID it points at")
+		}
+	}
+}
+
+func TestAliasWriter(t *testing.T) {
+	log := logger.NewUPPLogger("alias_test", "Debug")
+	s := newBucketMock(nil)
+	a := NewAliases(s, "bucketName", "")
+	mw := &mockWriter{writeStatus: CREATED}
+	w, err := NewAliasWriter(mw, a, "sourceRepresentations.uuid", log)
+	assert.NoError(t, err)
+
+	b := []byte(`{"uuid": "` + canonicalUUID + `", "sourceRepresentations": [{"uuid": "` + canonicalUUID + `"}, {"uuid": "` + aliasUUID + `"}, {"uuid": "TME-1"}]}`)
+	status, err := w.Write(canonicalUUID, "", &b, "application/json", "tid_test", false)
+	assert.NoError(t, err)
+	assert.Equal(t, CREATED, status)
+	aliases, err := a.Get(canonicalUUID, "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{aliasUUID}, aliases)
+
+	mw.writeStatus = UNCHANGED
+	b = []byte(`{"sourceRepresentations": []}`)
+	_, err = w.Write(canonicalUUID, "", &b, "application/json", "tid_test", false)
+	assert.NoError(t, err)
+	aliases, err = a.Get(canonicalUUID, "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{aliasUUID}, aliases)
+
+	assert.NoError(t, w.Delete(canonicalUUID, "", "tid_test"))
+	assert.Empty(t, s.keys(""))
+
+	_, err = NewAliasWriter(mw, a, "sourceRepresentations.", log)
+	assert.EqualError(t, err, `invalid alias field "sourceRepresentations."`)
+}
+
+func TestAliasWriterWithoutField(t *testing.T) {
+	log := logger.NewUPPLogger("alias_test", "Debug")
+	s := newBucketMock(nil)
+	a := NewAliases(s, "bucketName", "")
+	assert.NoError(t, a.Set(canonicalUUID, "", []string{aliasUUID}))
+	w, err := NewAliasWriter(&mockWriter{writeStatus: UPDATED}, a, "", log)
+	assert.NoError(t, err)
+
+	b := []byte(`{}`)
+	_, err = w.Write(canonicalUUID, "", &b, "application/json", "tid_test", false)
+	assert.NoError(t, err)
+	assert.Len(t, s.keys(""), 2, "aliases are only set from payloads with a field configured")
+}
+
+func TestReadHandlerResolvesAliases(t *testing.T) {
+	log := logger.NewUPPLogger("alias_test", "Debug")
+	s := newBucketMock(map[string]string{
+		"/2136f8ad/e94e/45cb/b616/336f38533214":              `{"uuid": "` + canonicalUUID + `"}`,
+		"TestDirectory/2136f8ad/e94e/45cb/b616/336f38533214": `{"uuid": "` + canonicalUUID + `", "path": true}`,
+	})
+	s.contentTypes["/2136f8ad/e94e/45cb/b616/336f38533214"] = "application/json"
+	s.contentTypes["TestDirectory/2136f8ad/e94e/45cb/b616/336f38533214"] = "application/json"
+	a := NewAliases(s, "bucketName", "")
+	assert.NoError(t, a.Set(canonicalUUID, "", []string{aliasUUID}))
+	assert.NoError(t, a.Set(canonicalUUID, "TestDirectory", []string{aliasUUID}))
+	_, found, err := a.Resolve(aliasUUID, "OtherDirectory")
+	assert.NoError(t, err)
+	assert.False(t, found)
+
+	r := mux.NewRouter()
+	Handlers(r, WriterHandler{}, NewReaderHandler(NewAliasReader(NewS3Reader(s, "bucketName", "", 1, log), a), log), ExpectedResourcePath)
+
+	rec := assertRequestAndResponseFromRouter(t, r, withExpectedResourcePath("/"+aliasUUID), http.StatusOK, `{"uuid": "`+canonicalUUID+`"}`, "application/json")
+	assert.Equal(t, withExpectedResourcePath("/"+canonicalUUID), rec.Header().Get("Content-Location"))
+
+	rec = assertRequestAndResponseFromRouter(t, r, withExpectedResourcePath("/"+aliasUUID+"?path=TestDirectory"), http.StatusOK, `{"uuid": "`+canonicalUUID+`", "path": true}`, "application/json")
+	assert.Equal(t, withExpectedResourcePath("/"+canonicalUUID+"?path=TestDirectory"), rec.Header().Get("Content-Location"))
+
+	rec = assertRequestAndResponseFromRouter(t, r, withExpectedResourcePath("/"+canonicalUUID), http.StatusOK, `{"uuid": "`+canonicalUUID+`"}`, "application/json")
+	assert.Empty(t, rec.Header().Get("Content-Location"))
+
+	assertRequestAndResponseFromRouter(t, r, withExpectedResourcePath("/"+otherAliasUUID), http.StatusNotFound, "{\"message\":\"Item not found\"}", ExpectedContentType)
+
+	s.err = errors.New("S3 unavailable")
+	assertRequestAndResponseFromRouter(t, r, withExpectedResourcePath("/"+aliasUUID), http.StatusServiceUnavailable, "", ExpectedContentType)
+}
+
+func TestAliasHandlers(t *testing.T) {
+	log := logger.NewUPPLogger("alias_test", "Debug")
+	s := newBucketMock(nil)
+	a := NewAliases(s, "bucketName", "")
+	r := mux.NewRouter()
+	AliasHandlers(r, NewAliasHandler(a, "", log), ExpectedResourcePath)
+
+	tests := []struct {
+		name         string
+		body         string
+		expectedCode int
+		expectedBody string
+	}{
+		{name: "set", body: `["` + aliasUUID + `"]`, expectedCode: http.StatusOK, expectedBody: `{"message":"Updated aliases"}`},
+		{name: "not an array", body: `{"aliases": []}`, expectedCode: http.StatusBadRequest, expectedBody: "{\"message\":\"body must be a JSON array of alias UUIDs\"}\n"},
+		{name: "not a uuid", body: `["TME-1"]`, expectedCode: http.StatusBadRequest, expectedBody: "{\"message\":\"invalid alias \\\"TME-1\\\"\"}\n"},
+		{name: "itself", body: `["` + canonicalUUID + `"]`, expectedCode: http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, newRequest("PUT", withExpectedResourcePath("/"+canonicalUUID+"/__aliases"), test.body))
+			assert.Equal(t, test.expectedCode, rec.Code)
+			if test.expectedBody != "" {
+				assert.Equal(t, test.expectedBody, rec.Body.String())
+			}
+		})
+	}
+
+	assertRequestAndResponseFromRouter(t, r, withExpectedResourcePath("/"+canonicalUUID+"/__aliases"), http.StatusOK, `["`+aliasUUID+`"]`+"\n", "application/json")
+	assertRequestAndResponseFromRouter(t, r, withExpectedResourcePath("/"+aliasUUID+"/__aliases"), http.StatusOK, "[]\n", "application/json")
+
+	s.err = errors.New("S3 unavailable")
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, newRequest("PUT", withExpectedResourcePath("/"+canonicalUUID+"/__aliases"), "[]"))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+}
+
+func TestAliasHandlersWithField(t *testing.T) {
+	log := logger.NewUPPLogger("alias_test", "Debug")
+	s := newBucketMock(nil)
+	a := NewAliases(s, "bucketName", "")
+	assert.NoError(t, a.Set(canonicalUUID, "", []string{aliasUUID}))
+	r := mux.NewRouter()
+	AliasHandlers(r, NewAliasHandler(a, "sourceRepresentations.uuid", log), ExpectedResourcePath)
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, newRequest("PUT", withExpectedResourcePath("/"+canonicalUUID+"/__aliases"), "[]"))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, "{\"message\":\"aliases are set from the sourceRepresentations.uuid field of the payloads\"}\n", rec.Body.String())
+	assertRequestAndResponseFromRouter(t, r, withExpectedResourcePath("/"+canonicalUUID+"/__aliases"), http.StatusOK, `["`+aliasUUID+`"]`+"\n", "application/json")
+}
+
+func TestReadHandlerCountsThroughAliasReader(t *testing.T) {
+	log := logger.NewUPPLogger("alias_test", "Debug")
+	a := NewAliases(newBucketMock(nil), "bucketName", "")
+	mr := &mockReader{count: 10, log: log}
+	cr := NewCachedCountReader(mr, time.Hour, log)
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	cr.now = func() time.Time { return now }
+	r := mux.NewRouter()
+	Handlers(r, WriterHandler{}, NewReaderHandler(NewAliasReader(cr, a), log), ExpectedResourcePath)
+
+	rec := assertRequestAndResponseFromRouter(t, r, withExpectedResourcePath("/__count"), 200, "10", ExpectedContentType)
+	assert.Equal(t, "0", rec.Header().Get("Age"))
+
+	setCount(mr, 20)
+	now = now.Add(time.Minute)
+	rec = assertRequestAndResponseFromRouter(t, r, withExpectedResourcePath("/__count"), 200, "10", ExpectedContentType)
+	assert.Equal(t, "60", rec.Header().Get("Age"))
+	rec = assertRequestAndResponseFromRouter(t, r, withExpectedResourcePath("/__count?fresh=true"), 200, "20", ExpectedContentType)
+	assert.Equal(t, "0", rec.Header().Get("Age"))
+
+	r = mux.NewRouter()
+	Handlers(r, WriterHandler{}, NewReaderHandler(NewAliasReader(mr, a), log), ExpectedResourcePath)
+	rec = assertRequestAndResponseFromRouter(t, r, withExpectedResourcePath("/__count"), 200, "20", ExpectedContentType)
+	assert.Empty(t, rec.Header().Get("Age"), "counts which are not cached have no age")
+}
@@ -0,0 +1,182 @@
+package service
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+const (
+	formatTarGz = "tar.gz"
+	formatZip   = "zip"
+)
+
+var archiveExtensions = map[string]string{
+	"application/json":         ".json",
+	"application/xml":          ".xml",
+	"text/xml":                 ".xml",
+	"text/plain":               ".txt",
+	"text/html":                ".html",
+	"application/octet-stream": ".bin",
+}
+
+var archiveContentTypes = map[string]string{
+	".json": "application/json",
+	".xml":  "application/xml",
+	".txt":  "text/plain",
+	".html": "text/html",
+	".bin":  "application/octet-stream",
+}
+
+func isArchive(format string) bool {
+	return format == formatTarGz || format == formatZip
+}
+
+func parseExportOptions(r *http.Request) (GetAllOptions, error) {
+	var opts GetAllOptions
+	var err error
+	if opts.ListFilter, err = parseListFilter(r); err != nil {
+		return opts, err
+	}
+	opts.Format = r.URL.Query().Get("format")
+	if opts.Format == "" {
+		opts.Format = formatTarGz
+	}
+	if !isArchive(opts.Format) {
+		return opts, fmt.Errorf("format must be %s or %s", formatTarGz, formatZip)
+	}
+	opts.StreamOptions = parseStreamOptions(r)
+	return opts, nil
+}
+
+// archiveEntryName names an item's archive entry by its UUID, with the extension of its content type.
+func archiveEntryName(uuid string, contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return uuid + ".bin"
+	}
+	if ext, ok := archiveExtensions[mediaType]; ok {
+		return uuid + ext
+	}
+	switch {
+	case strings.HasSuffix(mediaType, "+json"):
+		return uuid + ".json"
+	case strings.HasSuffix(mediaType, "+xml"):
+		return uuid + ".xml"
+	}
+	if exts, _ := mime.ExtensionsByType(mediaType); len(exts) > 0 {
+		return uuid + exts[0]
+	}
+	return uuid + ".bin"
+}
+
+// archiveContentType returns the content type of an archive entry from its extension.
+func archiveContentType(ext string) string {
+	if ct, ok := archiveContentTypes[ext]; ok {
+		return ct
+	}
+	if ct := mime.TypeByExtension(ext); ct != "" {
+		return ct
+	}
+	return "application/octet-stream"
+}
+
+// archive writes items as the entries of an archive file.
+type archive interface {
+	add(name string, modified time.Time, body []byte) error
+	Close() error
+}
+
+func newArchive(format string, w io.Writer) archive {
+	if format == formatZip {
+		return &zipArchive{zw: zip.NewWriter(w)}
+	}
+	gz := gzip.NewWriter(w)
+	return &tarGzArchive{gz: gz, tw: tar.NewWriter(gz)}
+}
+
+type tarGzArchive struct {
+	gz *gzip.Writer
+	tw *tar.Writer
+}
+
+func (a *tarGzArchive) add(name string, modified time.Time, body []byte) error {
+	err := a.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0644,
+		Size:     int64(len(body)),
+		ModTime:  modified,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = a.tw.Write(body)
+	return err
+}
+
+func (a *tarGzArchive) Close() error {
+	return errors.Join(a.tw.Close(), a.gz.Close())
+}
+
+type zipArchive struct {
+	zw *zip.Writer
+}
+
+func (a *zipArchive) add(name string, modified time.Time, body []byte) error {
+	w, err := a.zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modified})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(body)
+	return err
+}
+
+func (a *zipArchive) Close() error {
+	return a.zw.Close()
+}
+
+// archiveItems writes the items to an archive as they arrive, so only one item at a time is held in memory.
+func (r *S3Reader) archiveItems(results <-chan chan exportItem, st *stream, format string) {
+	a := newArchive(format, st)
+	for result := range results {
+		item := <-result
+		if item.err != nil {
+			st.fail(item.err)
+			continue
+		}
+		if item.body == nil {
+			continue
+		}
+		if st.aborted() {
+			item.body.Close()
+			continue
+		}
+
+		body, err := io.ReadAll(item.body)
+		item.body.Close()
+		if err == nil {
+			err = a.add(archiveEntryName(item.uuid, item.contentType), aws.TimeValue(item.lastModified), body)
+		}
+		if err != nil {
+			r.log.WithError(err).WithUUID(item.uuid).Error("Error archiving item")
+			st.fail(err)
+			continue
+		}
+		st.Report.addItem()
+	}
+
+	if err := a.Close(); err != nil {
+		r.log.WithError(err).Error("Error closing archive")
+	}
+	st.pw.Close()
+}
@@ -0,0 +1,87 @@
+package service
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/Financial-Times/go-logger/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestArchiveEntryName(t *testing.T) {
+	tests := map[string]string{
+		"application/json":                 expectedUUID + ".json",
+		"application/json; charset=utf-8":  expectedUUID + ".json",
+		"application/vnd.ft-upp-list+json": expectedUUID + ".json",
+		"application/xml":                  expectedUUID + ".xml",
+		"text/plain":                       expectedUUID + ".txt",
+		"image/png":                        expectedUUID + ".png",
+		"application/x-unknown":            expectedUUID + ".bin",
+		"":                                 expectedUUID + ".bin",
+	}
+	for ct, expected := range tests {
+		assert.Equal(t, expected, archiveEntryName(expectedUUID, ct), ct)
+	}
+}
+
+func TestS3Reader_GetAllTarGz(t *testing.T) {
+	r, _ := exportMock(logger.NewUPPLogger("archive_test", "Debug"))
+	report := &StreamReport{}
+	p, err := r.GetAll(GetAllOptions{Format: formatTarGz, StreamOptions: StreamOptions{Report: report}})
+	assert.NoError(t, err)
+
+	gz, err := gzip.NewReader(p)
+	assert.NoError(t, err)
+	tr := tar.NewReader(gz)
+	entries := map[string]string{}
+	var modified []time.Time
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+		b, err := io.ReadAll(tr)
+		assert.NoError(t, err)
+		entries[h.Name] = string(b)
+		modified = append(modified, h.ModTime.UTC())
+	}
+
+	assert.Equal(t, map[string]string{
+		"123e4567-e89b-12d3-a456-426655440001.json": `{"id":1}`,
+		"123e4567-e89b-12d3-a456-426655440002.json": "not json",
+	}, entries)
+	assert.Equal(t, time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), modified[0])
+	assert.Equal(t, int64(2), report.Items())
+}
+
+func TestS3Reader_GetAllZip(t *testing.T) {
+	r, s := exportMock(logger.NewUPPLogger("archive_test", "Debug"))
+	s.ct = "text/plain"
+	p, err := r.GetAll(GetAllOptions{Format: formatZip})
+	assert.NoError(t, err)
+	b, err := io.ReadAll(p)
+	assert.NoError(t, err)
+
+	zr, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	assert.NoError(t, err)
+	entries := map[string]string{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		assert.NoError(t, err)
+		body, err := io.ReadAll(rc)
+		assert.NoError(t, err)
+		rc.Close()
+		entries[f.Name] = string(body)
+	}
+
+	assert.Equal(t, map[string]string{
+		"123e4567-e89b-12d3-a456-426655440001.txt": `{"id":1}`,
+		"123e4567-e89b-12d3-a456-426655440002.txt": "not json",
+	}, entries)
+}
@@ -0,0 +1,169 @@
+package service
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const compressionFlushInterval = time.Second
+
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// responseEncodings are the content encodings responses can be compressed with, in order of preference.
+var responseEncodings = []struct {
+	name string
+	new  func(w io.Writer) compressor
+}{
+	{name: "gzip", new: func(w io.Writer) compressor { return gzip.NewWriter(w) }},
+	{name: "zstd", new: newZstdWriter},
+}
+
+// newZstdWriter returns a zstd encoder compressing on the writing goroutine, as responses are written sequentially.
+func newZstdWriter(w io.Writer) compressor {
+	// NewWriter only fails for invalid options.
+	enc, _ := zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+	return enc
+}
+
+// negotiateEncoding returns the encoding an Accept-Encoding header gives the highest quality, preferring the earlier
+// responseEncodings when several have the same quality, or an empty string for none.
+func negotiateEncoding(acceptEncoding string) string {
+	accepted := map[string]float64{}
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		accepted[strings.ToLower(strings.TrimSpace(name))] = q
+	}
+
+	best, bestQ := "", 0.0
+	for _, e := range responseEncodings {
+		q, listed := accepted[e.name]
+		if !listed {
+			q = accepted["*"]
+		}
+		if q > bestQ {
+			best, bestQ = e.name, q
+		}
+	}
+	return best
+}
+
+// withCompression compresses responses with an encoding the client accepts.
+func withCompression(next http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Add("Vary", "Accept-Encoding")
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" {
+			next(rw, r)
+			return
+		}
+		cw := &compressedResponseWriter{ResponseWriter: rw, encoding: encoding}
+		defer cw.Close()
+		next(cw, r)
+	}
+}
+
+// compressedResponseWriter compresses a response body, flushing it every second so clients see the progress of streams.
+type compressedResponseWriter struct {
+	http.ResponseWriter
+	encoding    string
+	mu          sync.Mutex
+	wroteHeader bool
+	c           compressor // nil until the body is written, and for responses without a body
+	stop        chan struct{}
+	stopped     sync.WaitGroup
+}
+
+func (cw *compressedResponseWriter) WriteHeader(code int) {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	cw.writeHeader(code)
+}
+
+func (cw *compressedResponseWriter) writeHeader(code int) {
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+	if code >= http.StatusOK && code != http.StatusNoContent && code != http.StatusNotModified && cw.Header().Get("Content-Encoding") == "" {
+		cw.Header().Set("Content-Encoding", cw.encoding)
+		cw.Header().Del("Content-Length")
+		for _, e := range responseEncodings {
+			if e.name == cw.encoding {
+				cw.c = e.new(cw.ResponseWriter)
+			}
+		}
+		cw.stop = make(chan struct{})
+		cw.stopped.Add(1)
+		go cw.flushPeriodically()
+	}
+	cw.ResponseWriter.WriteHeader(code)
+}
+
+func (cw *compressedResponseWriter) Write(b []byte) (int, error) {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	cw.writeHeader(http.StatusOK)
+	if cw.c == nil {
+		return cw.ResponseWriter.Write(b)
+	}
+	return cw.c.Write(b)
+}
+
+func (cw *compressedResponseWriter) Flush() {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	cw.flush()
+}
+
+func (cw *compressedResponseWriter) flush() {
+	if cw.c != nil {
+		cw.c.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (cw *compressedResponseWriter) flushPeriodically() {
+	defer cw.stopped.Done()
+	ticker := time.NewTicker(compressionFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			cw.Flush()
+		case <-cw.stop:
+			return
+		}
+	}
+}
+
+// Close writes the end of the compressed body once the handler is done.
+func (cw *compressedResponseWriter) Close() error {
+	cw.mu.Lock()
+	c, stop := cw.c, cw.stop
+	cw.mu.Unlock()
+	if c == nil {
+		return nil
+	}
+	close(stop)
+	cw.stopped.Wait()
+	return c.Close()
+}
@@ -0,0 +1,140 @@
+package service
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Financial-Times/go-logger/v2"
+	"github.com/gorilla/mux"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := map[string]string{
+		"":                       "",
+		"gzip":                   "gzip",
+		"deflate, gzip;q=1.0":    "gzip",
+		"GZIP":                   "gzip",
+		"gzip;q=0":               "",
+		"*":                      "gzip",
+		"*, gzip;q=0":            "zstd",
+		"br, identity":           "",
+		"gzip;q=bad":             "",
+		"zstd":                   "zstd",
+		"zstd, gzip":             "gzip",
+		"zstd, gzip;q=0":         "zstd",
+		"gzip;q=0.1, zstd":       "zstd",
+		"gzip;q=0.5, zstd;q=0.5": "gzip",
+		"*;q=0.2, gzip;q=0.1":    "zstd",
+	}
+	for header, expected := range tests {
+		assert.Equal(t, expected, negotiateEncoding(header), header)
+	}
+}
+
+func compressedRequest(t *testing.T, r *mux.Router, url string, acceptEncoding string) *httptest.ResponseRecorder {
+	req := newRequest("GET", url, "")
+	req.Header.Set("Accept-Encoding", acceptEncoding)
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	return rec
+}
+
+func gunzip(t *testing.T, b []byte) string {
+	gz, err := gzip.NewReader(bytes.NewReader(b))
+	assert.NoError(t, err)
+	body, err := io.ReadAll(gz)
+	assert.NoError(t, err)
+	return string(body)
+}
+
+func unzstd(t *testing.T, b []byte) string {
+	dec, err := zstd.NewReader(bytes.NewReader(b))
+	assert.NoError(t, err)
+	defer dec.Close()
+	body, err := io.ReadAll(dec)
+	assert.NoError(t, err)
+	return string(body)
+}
+
+func TestCompressedStreams(t *testing.T) {
+	log := logger.NewUPPLogger("compress_test", "Debug")
+	r := mux.NewRouter()
+	mr := &mockReader{payload: "PAYLOAD", log: log}
+	Handlers(r, WriterHandler{}, NewReaderHandler(mr, log), ExpectedResourcePath)
+
+	for _, endpoint := range []string{"/", "/__ids"} {
+		rec := compressedRequest(t, r, withExpectedResourcePath(endpoint), "gzip")
+		assert.Equal(t, http.StatusOK, rec.Code, endpoint)
+		assert.Equal(t, "gzip", rec.Header().Get("Content-Encoding"), endpoint)
+		assert.Equal(t, "Accept-Encoding", rec.Header().Get("Vary"), endpoint)
+		assert.Equal(t, "PAYLOAD", gunzip(t, rec.Body.Bytes()), endpoint)
+		assert.Equal(t, "1", rec.Result().Trailer.Get("X-Item-Count"), endpoint)
+	}
+}
+
+func TestCompressedGet(t *testing.T) {
+	log := logger.NewUPPLogger("compress_test", "Debug")
+	r := mux.NewRouter()
+	mr := &mockReader{payload: "Some content", returnCT: "return/type", log: log}
+	Handlers(r, WriterHandler{}, NewReaderHandler(mr, log), ExpectedResourcePath)
+
+	rec := compressedRequest(t, r, withExpectedResourcePath("/"+expectedUUID), "gzip")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "gzip", rec.Header().Get("Content-Encoding"))
+	assert.Equal(t, "return/type", rec.Header().Get("Content-Type"))
+	assert.Equal(t, "Some content", gunzip(t, rec.Body.Bytes()))
+
+	rec = compressedRequest(t, r, withExpectedResourcePath("/"+expectedUUID), "zstd")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "zstd", rec.Header().Get("Content-Encoding"))
+	assert.Equal(t, "Some content", unzstd(t, rec.Body.Bytes()))
+
+	rec = compressedRequest(t, r, withExpectedResourcePath("/"+expectedUUID), "")
+	assert.Empty(t, rec.Header().Get("Content-Encoding"))
+	assert.Equal(t, "Some content", rec.Body.String())
+}
+
+func TestCompressionFlushesStreamedBody(t *testing.T) {
+	flushed := make(chan string, 1)
+	h := withCompression(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Write([]byte("first"))
+		rw.(http.Flusher).Flush()
+		gz, err := gzip.NewReader(bytes.NewReader(rw.(*compressedResponseWriter).ResponseWriter.(*httptest.ResponseRecorder).Body.Bytes()))
+		assert.NoError(t, err)
+		b := make([]byte, 5)
+		_, err = io.ReadFull(gz, b)
+		assert.NoError(t, err)
+		flushed <- string(b)
+		rw.Write([]byte(" second"))
+	})
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	h(rec, req)
+
+	assert.Equal(t, "first", <-flushed)
+	assert.True(t, rec.Flushed)
+	assert.Equal(t, "first second", gunzip(t, rec.Body.Bytes()))
+}
+
+func TestCompressionSkipsResponsesWithoutBody(t *testing.T) {
+	h := withCompression(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusNoContent)
+	})
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	h(rec, req)
+
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Empty(t, rec.Header().Get("Content-Encoding"))
+	assert.Empty(t, rec.Body.Bytes())
+}
@@ -0,0 +1,107 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+const defaultWorkers = 10
+
+// ResourceConfig describes a single resource path served by the app and the bucket backing it.
+type ResourceConfig struct {
+	ResourcePath         string   `json:"resourcePath"`
+	BucketName           string   `json:"bucketName"`
+	BucketPrefix         string   `json:"bucketPrefix"`
+	Workers              int      `json:"workers"`
+	OnlyUpdatesEnabled   bool     `json:"onlyUpdatesEnabled"`
+	ConsumerTopic        string   `json:"consumerTopic"`
+	ProducerTopic        string   `json:"producerTopic"`
+	MirrorBucketName     string   `json:"mirrorBucketName"`
+	MirrorBucketPrefix   string   `json:"mirrorBucketPrefix"`
+	MirrorAwsRegion      string   `json:"mirrorAwsRegion"`
+	MirrorMode           string   `json:"mirrorMode"`
+	FallbackBucketName   string   `json:"fallbackBucketName"`
+	FallbackBucketPrefix string   `json:"fallbackBucketPrefix"`
+	FallbackAwsRegion    string   `json:"fallbackAwsRegion"`
+	LegacyBucketName     string   `json:"legacyBucketName"`
+	LegacyBucketPrefix   string   `json:"legacyBucketPrefix"`
+	LegacyAwsRegion      string   `json:"legacyAwsRegion"`
+	IndexFields          []string `json:"indexFields"`
+	AliasesEnabled       bool     `json:"aliasesEnabled"`
+	AliasField           string   `json:"aliasField"`
+	ReferencePaths       []string `json:"referencePaths"`
+}
+
+// writeSideEffects lists what the service does when an item of the resource is written, other than storing it.
+// The cached count is left out, as its next recount corrects it.
+func (rc ResourceConfig) writeSideEffects() []string {
+	var effects []string
+	for _, e := range []struct {
+		name       string
+		configured bool
+	}{
+		{"mirror", rc.MirrorBucketName != ""},
+		{"migration", rc.LegacyBucketName != ""},
+		{"index", len(rc.IndexFields) > 0},
+		{"references", len(rc.ReferencePaths) > 0},
+		{"aliases", rc.AliasesEnabled},
+		{"change events", rc.ProducerTopic != ""},
+	} {
+		if e.configured {
+			effects = append(effects, e.name)
+		}
+	}
+	return effects
+}
+
+// LoadResourcesConfig reads a JSON array of resource configurations from the given file.
+func LoadResourcesConfig(fileName string) ([]ResourceConfig, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var resources []ResourceConfig
+	if err := json.NewDecoder(f).Decode(&resources); err != nil {
+		return nil, fmt.Errorf("could not decode resources config %s: %w", fileName, err)
+	}
+
+	if err := validateResources(resources); err != nil {
+		return nil, err
+	}
+	return resources, nil
+}
+
+func validateResources(resources []ResourceConfig) error {
+	if len(resources) == 0 {
+		return errors.New("no resources configured")
+	}
+
+	paths := make(map[string]bool)
+	for i := range resources {
+		rc := &resources[i]
+		if rc.BucketName == "" {
+			return fmt.Errorf("resource %q has no bucket name", rc.ResourcePath)
+		}
+		if paths[rc.ResourcePath] {
+			return fmt.Errorf("resource path %q is configured more than once", rc.ResourcePath)
+		}
+		paths[rc.ResourcePath] = true
+
+		if rc.Workers <= 0 {
+			rc.Workers = defaultWorkers
+		}
+		if rc.MirrorBucketName != "" {
+			if rc.MirrorMode == "" {
+				rc.MirrorMode = MirrorSync
+			}
+			if rc.MirrorMode != MirrorSync && rc.MirrorMode != MirrorAsync {
+				return fmt.Errorf("resource %q has unknown mirror mode %q", rc.ResourcePath, rc.MirrorMode)
+			}
+		}
+	}
+	return nil
+}
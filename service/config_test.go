@@ -0,0 +1,67 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeResourcesConfig(t *testing.T, content string) string {
+	fileName := filepath.Join(t.TempDir(), "resources.json")
+	if err := os.WriteFile(fileName, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return fileName
+}
+
+func TestLoadResourcesConfig(t *testing.T) {
+	fileName := writeResourcesConfig(t, `[
+		{"resourcePath": "concepts", "bucketName": "conceptsBucket", "bucketPrefix": "concepts", "workers": 5, "onlyUpdatesEnabled": true, "consumerTopic": "Concepts", "producerTopic": "ConceptChanges"},
+		{"resourcePath": "", "bucketName": "genericBucket"}
+	]`)
+
+	resources, err := LoadResourcesConfig(fileName)
+	assert.NoError(t, err)
+	assert.Equal(t, []ResourceConfig{
+		{
+			ResourcePath:       "concepts",
+			BucketName:         "conceptsBucket",
+			BucketPrefix:       "concepts",
+			Workers:            5,
+			OnlyUpdatesEnabled: true,
+			ConsumerTopic:      "Concepts",
+			ProducerTopic:      "ConceptChanges",
+		},
+		{
+			ResourcePath: "",
+			BucketName:   "genericBucket",
+			Workers:      defaultWorkers,
+		},
+	}, resources)
+}
+
+func TestLoadResourcesConfigErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{name: "invalid json", content: `{"resourcePath":`},
+		{name: "no resources", content: `[]`},
+		{name: "missing bucket", content: `[{"resourcePath": "concepts"}]`},
+		{name: "duplicate path", content: `[{"resourcePath": "concepts", "bucketName": "a"}, {"resourcePath": "concepts", "bucketName": "b"}]`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := LoadResourcesConfig(writeResourcesConfig(t, test.content))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestLoadResourcesConfigMissingFile(t *testing.T) {
+	_, err := LoadResourcesConfig(filepath.Join(t.TempDir(), "missing.json"))
+	assert.Error(t, err)
+}
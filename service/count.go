@@ -0,0 +1,166 @@
+package service
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/Financial-Times/go-logger/v2"
+)
+
+type cachedCounter interface {
+	// CachedCount returns the item count and how long ago it was last recounted, recounting first when fresh is set.
+	CachedCount(fresh bool) (int64, time.Duration, error)
+}
+
+// errCountNotCached is returned by decorators of readers which do not cache the count.
+var errCountNotCached = errors.New("the count is not cached")
+
+type countCache struct {
+	sync.Mutex
+	count     int64
+	countedAt time.Time
+	valid     bool
+	adjusted  int64 // the sum of every adjustment, so those made while items are recounted can be applied afterwards
+}
+
+// CachedCountReader serves the count of all items from a cache which is rebuilt on a schedule
+// and adjusted by a CountingWriter as items are created and deleted in between.
+type CachedCountReader struct {
+	Reader
+	refreshInterval time.Duration
+	cache           *countCache
+	now             func() time.Time
+	log             *logger.UPPLogger
+}
+
+func NewCachedCountReader(reader Reader, refreshInterval time.Duration, log *logger.UPPLogger) *CachedCountReader {
+	return &CachedCountReader{
+		Reader:          reader,
+		refreshInterval: refreshInterval,
+		cache:           &countCache{},
+		now:             time.Now,
+		log:             log,
+	}
+}
+
+func (r *CachedCountReader) withServeHook(onServe func(bucket string)) Reader {
+	c := *r
+	c.Reader = hookServe(r.Reader, onServe)
+	return &c
+}
+
+// Count serves the count of all items from the cache, counts under a path are not cached.
+func (r *CachedCountReader) Count(path string) (int64, error) {
+	if path != "" {
+		return r.Reader.Count(path)
+	}
+	c, _, err := r.CachedCount(false)
+	return c, err
+}
+
+func (r *CachedCountReader) CachedCount(fresh bool) (int64, time.Duration, error) {
+	r.cache.Lock()
+	if r.cache.valid && !fresh {
+		defer r.cache.Unlock()
+		return r.cache.count, r.now().Sub(r.cache.countedAt), nil
+	}
+	r.cache.Unlock()
+
+	c, err := r.Recount()
+	return c, 0, err
+}
+
+// Recount counts every item and replaces the cached count. Items created and deleted while they are being counted are
+// applied to the new count, though the listing may already include some of them.
+func (r *CachedCountReader) Recount() (int64, error) {
+	r.cache.Lock()
+	adjusted := r.cache.adjusted
+	r.cache.Unlock()
+
+	c, err := r.Reader.Count("")
+	if err != nil {
+		return 0, err
+	}
+
+	r.cache.Lock()
+	defer r.cache.Unlock()
+	c += r.cache.adjusted - adjusted
+	if c < 0 {
+		c = 0
+	}
+	r.cache.count = c
+	r.cache.countedAt = r.now()
+	r.cache.valid = true
+	return c, nil
+}
+
+// Start recounts immediately and then every refresh interval until the stop channel is closed.
+func (r *CachedCountReader) Start(stop <-chan struct{}) {
+	ticker := time.NewTicker(r.refreshInterval)
+	defer ticker.Stop()
+	for {
+		if _, err := r.Recount(); err != nil {
+			r.log.WithError(err).Error("Failed to recount items")
+		}
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (r *CachedCountReader) adjust(delta int64) {
+	r.cache.Lock()
+	defer r.cache.Unlock()
+	r.cache.adjusted += delta
+	if !r.cache.valid {
+		return
+	}
+	r.cache.count += delta
+	if r.cache.count < 0 {
+		r.cache.count = 0
+	}
+}
+
+// CountingWriter adjusts a cached count as items are created and deleted.
+// Deletes only decrement the count when the item existed, which is found by reading it from the store first.
+type CountingWriter struct {
+	Writer
+	store  hashStore
+	counts *CachedCountReader
+	log    *logger.UPPLogger
+}
+
+// NewCountingWriter counts the items created and deleted by writer. The store is the S3Writer the items are written to,
+// other writers cannot tell whether an item existed, and every delete then decrements the count.
+func NewCountingWriter(writer Writer, store Writer, counts *CachedCountReader, log *logger.UPPLogger) *CountingWriter {
+	hs, _ := store.(hashStore)
+	return &CountingWriter{Writer: writer, store: hs, counts: counts, log: log}
+}
+
+func (w *CountingWriter) Write(uuid string, path string, b *[]byte, ct string, tid string, ignoreHash bool) (Status, error) {
+	status, err := w.Writer.Write(uuid, path, b, ct, tid, ignoreHash)
+	if err == nil && status == CREATED {
+		w.counts.adjust(1)
+	}
+	return status, err
+}
+
+func (w *CountingWriter) Delete(uuid string, path string, tid string) error {
+	existed := true
+	if w.store != nil {
+		var err error
+		if _, existed, err = w.store.storedHash(uuid, path); err != nil {
+			w.log.WithError(err).WithTransactionID(tid).WithUUID(uuid).Warn("Could not tell whether the item existed, the count is left until the next recount")
+		}
+	}
+	if err := w.Writer.Delete(uuid, path, tid); err != nil {
+		return err
+	}
+	if existed {
+		w.counts.adjust(-1)
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Financial-Times/go-logger/v2"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func setCount(mr *mockReader, count int64) {
+	mr.Lock()
+	defer mr.Unlock()
+	mr.count = count
+}
+
+func TestCachedCountReaderCachesCount(t *testing.T) {
+	log := logger.NewUPPLogger("count_test", "Debug")
+	mr := &mockReader{count: 10, log: log}
+	cr := NewCachedCountReader(mr, time.Hour, log)
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	cr.now = func() time.Time { return now }
+
+	c, err := cr.Count("")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10), c)
+
+	setCount(mr, 20)
+	now = now.Add(90 * time.Second)
+	c, age, err := cr.CachedCount(false)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10), c)
+	assert.Equal(t, 90*time.Second, age)
+
+	c, age, err = cr.CachedCount(true)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(20), c)
+	assert.Equal(t, time.Duration(0), age)
+}
+
+func TestCachedCountReaderDoesNotCachePathCounts(t *testing.T) {
+	log := logger.NewUPPLogger("count_test", "Debug")
+	mr := &mockReader{count: 10, log: log}
+	cr := NewCachedCountReader(mr, time.Hour, log)
+
+	_, err := cr.Count("")
+	assert.NoError(t, err)
+	setCount(mr, 3)
+	c, err := cr.Count("TestDirectory")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), c)
+	assert.Equal(t, "TestDirectory", mr.countPath)
+}
+
+func TestCachedCountReaderDoesNotCacheErrors(t *testing.T) {
+	log := logger.NewUPPLogger("count_test", "Debug")
+	mr := &mockReader{count: 10, returnError: errors.New("count failed"), log: log}
+	cr := NewCachedCountReader(mr, time.Hour, log)
+
+	_, err := cr.Count("")
+	assert.Error(t, err)
+	assert.False(t, cr.cache.valid)
+}
+
+func TestCountingWriterAdjustsCount(t *testing.T) {
+	log := logger.NewUPPLogger("count_test", "Debug")
+	mr := &mockReader{count: 1, log: log}
+	cr := NewCachedCountReader(mr, time.Hour, log)
+	mw := &mockWriter{writeStatus: CREATED}
+	w := NewCountingWriter(mw, mw, cr, log)
+	b := []byte("PAYLOAD")
+
+	// Adjustments are ignored until the count has been cached.
+	_, err := w.Write(expectedUUID, "", &b, "", "", false)
+	assert.NoError(t, err)
+	assert.False(t, cr.cache.valid)
+
+	_, err = cr.Recount()
+	assert.NoError(t, err)
+	_, err = w.Write(expectedUUID, "", &b, "", "", false)
+	assert.NoError(t, err)
+	assertCachedCount(t, cr, 2)
+
+	mw.writeStatus = UPDATED
+	_, err = w.Write(expectedUUID, "", &b, "", "", false)
+	assert.NoError(t, err)
+	assertCachedCount(t, cr, 2)
+
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, w.Delete(expectedUUID, "", ""))
+	}
+	assertCachedCount(t, cr, 0)
+
+	mw.returnError = errors.New("write failed")
+	mw.writeStatus = CREATED
+	_, err = w.Write(expectedUUID, "", &b, "", "", false)
+	assert.Error(t, err)
+	assertCachedCount(t, cr, 0)
+}
+
+func TestCountingWriterOnlyCountsDeletesOfExistingItems(t *testing.T) {
+	log := logger.NewUPPLogger("count_test", "Debug")
+	cr := NewCachedCountReader(&mockReader{count: 2, log: log}, time.Hour, log)
+	_, err := cr.Recount()
+	assert.NoError(t, err)
+	store := &hashStoreMock{hashes: map[string]uint64{indexedUUID: 1}}
+	w := NewCountingWriter(&mockWriter{}, store, cr, log)
+
+	assert.NoError(t, w.Delete(otherIndexedUUID, "", ""))
+	assertCachedCount(t, cr, 2)
+	assert.NoError(t, w.Delete(indexedUUID, "", ""))
+	assertCachedCount(t, cr, 1)
+
+	store.err = errors.New("S3 unavailable")
+	assert.NoError(t, w.Delete(otherIndexedUUID, "", ""))
+	assertCachedCount(t, cr, 1)
+}
+
+// recountingReader runs a function while it counts the items.
+type recountingReader struct {
+	Reader
+	count  int64
+	during func()
+}
+
+func (r *recountingReader) Count(path string) (int64, error) {
+	r.during()
+	return r.count, nil
+}
+
+func TestRecountAppliesAdjustmentsMadeWhileCounting(t *testing.T) {
+	log := logger.NewUPPLogger("count_test", "Debug")
+	rr := &recountingReader{count: 5, during: func() {}}
+	cr := NewCachedCountReader(rr, time.Hour, log)
+	mw := &mockWriter{writeStatus: CREATED}
+	w := NewCountingWriter(mw, mw, cr, log)
+	b := []byte("PAYLOAD")
+
+	_, err := cr.Recount()
+	assert.NoError(t, err)
+	rr.count = 10
+	rr.during = func() {
+		for i := 0; i < 2; i++ {
+			_, err := w.Write(expectedUUID, "", &b, "", "", false)
+			assert.NoError(t, err)
+		}
+		assert.NoError(t, w.Delete(expectedUUID, "", ""))
+	}
+	c, err := cr.Recount()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(11), c)
+	assertCachedCount(t, cr, 11)
+}
+
+func assertCachedCount(t *testing.T, cr *CachedCountReader, expected int64) {
+	c, _, err := cr.CachedCount(false)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, c)
+}
+
+func TestCachedCountReaderStart(t *testing.T) {
+	log := logger.NewUPPLogger("count_test", "Debug")
+	mr := &mockReader{count: 10, log: log}
+	cr := NewCachedCountReader(mr, time.Millisecond, log)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		cr.Start(stop)
+		close(done)
+	}()
+
+	setCount(mr, 20)
+	assert.Eventually(t, func() bool {
+		cr.cache.Lock()
+		defer cr.cache.Unlock()
+		return cr.cache.count == 20
+	}, time.Second, time.Millisecond)
+	close(stop)
+	<-done
+}
+
+func TestReadHandlerCachedCount(t *testing.T) {
+	log := logger.NewUPPLogger("count_test", "Debug")
+	r := mux.NewRouter()
+	mr := &mockReader{count: 10, log: log}
+	cr := NewCachedCountReader(mr, time.Hour, log)
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	cr.now = func() time.Time { return now }
+	Handlers(r, WriterHandler{}, NewReaderHandler(cr, log), ExpectedResourcePath)
+
+	rec := assertRequestAndResponseFromRouter(t, r, withExpectedResourcePath("/__count"), 200, "10", ExpectedContentType)
+	assert.Equal(t, "0", rec.Header().Get("Age"))
+
+	setCount(mr, 20)
+	now = now.Add(2 * time.Minute)
+	rec = assertRequestAndResponseFromRouter(t, r, withExpectedResourcePath("/__count"), 200, "10", ExpectedContentType)
+	assert.Equal(t, "120", rec.Header().Get("Age"))
+
+	rec = assertRequestAndResponseFromRouter(t, r, withExpectedResourcePath("/__count?fresh=true"), 200, "20", ExpectedContentType)
+	assert.Equal(t, "0", rec.Header().Get("Age"))
+
+	rec = assertRequestAndResponseFromRouter(t, r, withExpectedResourcePath("/__count?path=TestDirectory"), 200, "20", ExpectedContentType)
+	assert.Empty(t, rec.Header().Get("Age"))
+}
@@ -0,0 +1,263 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/Financial-Times/go-logger/v2"
+	transactionid "github.com/Financial-Times/transactionid-utils-go"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+const (
+	DiffMissing   = "missing"
+	DiffExtra     = "extra"
+	DiffDiffering = "differs"
+)
+
+// DiffRecord describes an item which is not the same in the source and target locations.
+type DiffRecord struct {
+	UUID       string `json:"uuid"`
+	Status     string `json:"status"`
+	SourceKey  string `json:"sourceKey,omitempty"`
+	TargetKey  string `json:"targetKey,omitempty"`
+	SourceHash string `json:"sourceHash,omitempty"`
+	TargetHash string `json:"targetHash,omitempty"`
+	Repaired   bool   `json:"repaired,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// DiffSummary counts the differences found between the source and target locations.
+type DiffSummary struct {
+	Compared       int64 `json:"compared"`
+	Missing        int64 `json:"missing"`
+	Extra          int64 `json:"extra"`
+	Differing      int64 `json:"differing"`
+	Repaired       int64 `json:"repaired"`
+	RepairFailures int64 `json:"repairFailures"`
+}
+
+// DiffResult is the last line of a diff report.
+type DiffResult struct {
+	Summary DiffSummary `json:"summary"`
+	Error   string      `json:"error,omitempty"`
+}
+
+type listedObject struct {
+	uuid         string
+	key          string
+	etag         string
+	lastModified *time.Time
+}
+
+var errDiffAborted = errors.New("diff aborted")
+
+// BucketDiffer compares the items of a source and target location by UUID and Current-Object-Hash,
+// optionally repairing the target by copying missing and differing items from the source.
+type BucketDiffer struct {
+	src    BucketLocation
+	dst    BucketLocation
+	repair bool
+	log    *logger.UPPLogger
+}
+
+func NewBucketDiffer(src BucketLocation, dst BucketLocation, repair bool, log *logger.UPPLogger) (*BucketDiffer, error) {
+	if err := validateLocations(src, dst); err != nil {
+		return nil, err
+	}
+	return &BucketDiffer{src: src, dst: dst, repair: repair, log: log}, nil
+}
+
+// Diff walks both listings in key order and calls emit for every difference.
+// Returning an error from emit stops the comparison.
+func (d *BucketDiffer) Diff(emit func(DiffRecord) error) (DiffSummary, error) {
+	var summary DiffSummary
+	done := make(chan struct{})
+	defer close(done)
+
+	srcObjects, srcErr := d.list(d.src, done)
+	dstObjects, dstErr := d.list(d.dst, done)
+
+	// A listing which ends early must not make the other side look missing or extra, so its error is checked as soon as it ends.
+	var s, t listedObject
+	var sok, tok bool
+	var err error
+	if s, sok, err = nextObject(srcObjects, srcErr); err != nil {
+		return summary, err
+	}
+	if t, tok, err = nextObject(dstObjects, dstErr); err != nil {
+		return summary, err
+	}
+	for sok || tok {
+		var rec *DiffRecord
+		advanceSrc, advanceDst := false, false
+		switch {
+		case tok && (!sok || t.uuid < s.uuid):
+			summary.Extra++
+			rec = &DiffRecord{UUID: t.uuid, Status: DiffExtra, TargetKey: t.key}
+			advanceDst = true
+		case sok && (!tok || s.uuid < t.uuid):
+			summary.Compared++
+			summary.Missing++
+			rec = &DiffRecord{UUID: s.uuid, Status: DiffMissing, SourceKey: s.key, TargetKey: d.dst.key(s.uuid)}
+			d.repairItem(rec, &summary)
+			advanceSrc = true
+		default:
+			summary.Compared++
+			rec = d.compare(s, t, &summary)
+			advanceSrc, advanceDst = true, true
+		}
+
+		if rec != nil {
+			if err := emit(*rec); err != nil {
+				return summary, err
+			}
+		}
+		if advanceSrc {
+			if s, sok, err = nextObject(srcObjects, srcErr); err != nil {
+				return summary, err
+			}
+		}
+		if advanceDst {
+			if t, tok, err = nextObject(dstObjects, dstErr); err != nil {
+				return summary, err
+			}
+		}
+	}
+	return summary, nil
+}
+
+// nextObject receives the next listed object, or the listing error once there are no more.
+func nextObject(objects <-chan listedObject, errs <-chan error) (listedObject, bool, error) {
+	o, ok := <-objects
+	if ok {
+		return o, true, nil
+	}
+	return o, false, <-errs
+}
+
+func (d *BucketDiffer) compare(s listedObject, t listedObject, summary *DiffSummary) *DiffRecord {
+	if s.etag != "" && s.etag == t.etag {
+		return nil
+	}
+
+	rec := &DiffRecord{UUID: s.uuid, Status: DiffDiffering, SourceKey: s.key, TargetKey: t.key}
+	src, err := headObject(d.src.Svc, d.src.Bucket, s.key)
+	if err != nil {
+		summary.Differing++
+		rec.Error = err.Error()
+		return rec
+	}
+	dst, err := headObject(d.dst.Svc, d.dst.Bucket, t.key)
+	if err != nil {
+		summary.Differing++
+		rec.Error = err.Error()
+		return rec
+	}
+
+	rec.SourceHash = aws.StringValue(src.Metadata["Current-Object-Hash"])
+	rec.TargetHash = aws.StringValue(dst.Metadata["Current-Object-Hash"])
+	if rec.SourceHash == rec.TargetHash {
+		return nil
+	}
+
+	summary.Differing++
+	d.repairItem(rec, summary)
+	return rec
+}
+
+func (d *BucketDiffer) repairItem(rec *DiffRecord, summary *DiffSummary) {
+	if !d.repair {
+		return
+	}
+	if _, err := copyObject(d.dst.Svc, d.src.Bucket, rec.SourceKey, d.dst.Bucket, rec.TargetKey, true); err != nil {
+		summary.RepairFailures++
+		rec.Error = err.Error()
+		d.log.WithError(err).WithUUID(rec.UUID).Errorf("Failed to repair %s/%s", d.dst.Bucket, rec.TargetKey)
+		return
+	}
+	summary.Repaired++
+	rec.Repaired = true
+}
+
+func (d *BucketDiffer) list(l BucketLocation, done <-chan struct{}) (<-chan listedObject, <-chan error) {
+	objects := make(chan listedObject, 3000) //  Three times the default Page size
+	errs := make(chan error, 1)
+	go func() {
+		defer close(objects)
+		aborted := false
+		err := l.Svc.ListObjectsV2Pages(l.listInput(), func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+			for _, o := range page.Contents {
+				if !isItemKey(*o.Key) {
+					continue
+				}
+				select {
+				case objects <- listedObject{uuid: l.uuid(*o.Key), key: *o.Key, etag: aws.StringValue(o.ETag)}:
+				case <-done:
+					aborted = true
+					return false
+				}
+			}
+			return true
+		})
+		if err == nil && aborted {
+			err = errDiffAborted
+		}
+		errs <- err
+	}()
+	return objects, errs
+}
+
+// WriteDiffReport runs the diff and writes every difference followed by a DiffResult as NDJSON.
+func WriteDiffReport(d *BucketDiffer, enc *json.Encoder) (DiffSummary, error) {
+	summary, err := d.Diff(func(rec DiffRecord) error {
+		return enc.Encode(rec)
+	})
+	result := DiffResult{Summary: summary}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	enc.Encode(result)
+	return summary, err
+}
+
+// DiffHandler compares a resource's bucket with its configured mirror bucket on request.
+// Targets are never taken from the request, as repairing overwrites the target's objects.
+type DiffHandler struct {
+	src    BucketLocation
+	target BucketLocation
+	log    *logger.UPPLogger
+}
+
+func NewDiffHandler(src BucketLocation, mirror BucketLocation, log *logger.UPPLogger) DiffHandler {
+	return DiffHandler{src: src, target: mirror, log: log}
+}
+
+// HandleDiff reports the differences as NDJSON. POST requests also repair the mirror.
+func (dh *DiffHandler) HandleDiff(rw http.ResponseWriter, r *http.Request) {
+	tid := transactionid.GetTransactionIDFromRequest(r)
+	target := dh.target
+	if target.Bucket == "" {
+		respondBadRequest(errors.New("no mirror bucket is configured for this resource"), rw)
+		return
+	}
+
+	d, err := NewBucketDiffer(dh.src, target, r.Method == http.MethodPost, dh.log)
+	if err != nil {
+		respondBadRequest(err, rw)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/x-ndjson")
+	rw.WriteHeader(http.StatusOK)
+	summary, err := WriteDiffReport(d, json.NewEncoder(rw))
+	if err != nil {
+		dh.log.WithError(err).WithTransactionID(tid).Errorf("Diff of %s against %s failed", dh.src.Bucket, target.Bucket)
+		return
+	}
+	dh.log.WithTransactionID(tid).Infof("Diff of %s against %s found %d missing, %d extra and %d differing items, repaired %d",
+		dh.src.Bucket, target.Bucket, summary.Missing, summary.Extra, summary.Differing, summary.Repaired)
+}
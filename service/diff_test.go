@@ -0,0 +1,209 @@
+package service
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Financial-Times/go-logger/v2"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/assert"
+)
+
+func diffMocks(log *logger.UPPLogger, sourceHash string, targetHash string) (*mockS3Client, *mockS3Client) {
+	src := &mockS3Client{
+		log:              log,
+		headObjectOutput: &s3.HeadObjectOutput{Metadata: map[string]*string{"Current-Object-Hash": aws.String(sourceHash)}},
+		listObjectsV2Outputs: []*s3.ListObjectsV2Output{
+			{
+				Contents: []*s3.Object{
+					{Key: aws.String("src/123e4567/e89b/12d3/a456/426655440001"), ETag: aws.String("e1")},
+					{Key: aws.String("src/123e4567/e89b/12d3/a456/426655440002"), ETag: aws.String("e2")},
+					{Key: aws.String("src/folder/")}, // ignored as ends with '/'
+				},
+			},
+			{
+				Contents: []*s3.Object{
+					{Key: aws.String("src/123e4567/e89b/12d3/a456/426655440003"), ETag: aws.String("e3")},
+				},
+			},
+		},
+	}
+	dst := &mockS3Client{
+		log:              log,
+		headObjectOutput: &s3.HeadObjectOutput{Metadata: map[string]*string{"Current-Object-Hash": aws.String(targetHash)}},
+		listObjectsV2Outputs: []*s3.ListObjectsV2Output{
+			{
+				Contents: []*s3.Object{
+					{Key: aws.String("dst/123e4567/e89b/12d3/a456/426655440002"), ETag: aws.String("e2")},
+					{Key: aws.String("dst/123e4567/e89b/12d3/a456/426655440003"), ETag: aws.String("other")},
+					{Key: aws.String("dst/123e4567/e89b/12d3/a456/426655440004"), ETag: aws.String("e4")},
+				},
+			},
+		},
+	}
+	return src, dst
+}
+
+func newTestBucketDiffer(t *testing.T, src *mockS3Client, dst *mockS3Client, repair bool) *BucketDiffer {
+	d, err := NewBucketDiffer(
+		BucketLocation{Svc: src, Bucket: "srcBucket", Prefix: "src", Layout: LayoutPartitioned},
+		BucketLocation{Svc: dst, Bucket: "dstBucket", Prefix: "dst", Layout: LayoutPartitioned},
+		repair, src.log)
+	assert.NoError(t, err)
+	return d
+}
+
+func collectDiff(d *BucketDiffer) ([]DiffRecord, DiffSummary, error) {
+	var recs []DiffRecord
+	summary, err := d.Diff(func(rec DiffRecord) error {
+		recs = append(recs, rec)
+		return nil
+	})
+	return recs, summary, err
+}
+
+func TestBucketDifferReportsDifferences(t *testing.T) {
+	src, dst := diffMocks(logger.NewUPPLogger("diff_test", "Debug"), "hashA", "hashB")
+	recs, summary, err := collectDiff(newTestBucketDiffer(t, src, dst, false))
+
+	assert.NoError(t, err)
+	assert.Equal(t, []DiffRecord{
+		{UUID: "123e4567-e89b-12d3-a456-426655440001", Status: DiffMissing, SourceKey: "src/123e4567/e89b/12d3/a456/426655440001", TargetKey: "dst/123e4567/e89b/12d3/a456/426655440001"},
+		{UUID: "123e4567-e89b-12d3-a456-426655440003", Status: DiffDiffering, SourceKey: "src/123e4567/e89b/12d3/a456/426655440003", TargetKey: "dst/123e4567/e89b/12d3/a456/426655440003", SourceHash: "hashA", TargetHash: "hashB"},
+		{UUID: "123e4567-e89b-12d3-a456-426655440004", Status: DiffExtra, TargetKey: "dst/123e4567/e89b/12d3/a456/426655440004"},
+	}, recs)
+	assert.Equal(t, DiffSummary{Compared: 3, Missing: 1, Extra: 1, Differing: 1}, summary)
+	assert.Empty(t, dst.copyObjectInputs)
+}
+
+func TestBucketDifferIgnoresDifferentETagsWithSameHash(t *testing.T) {
+	src, dst := diffMocks(logger.NewUPPLogger("diff_test", "Debug"), "hashA", "hashA")
+	recs, summary, err := collectDiff(newTestBucketDiffer(t, src, dst, false))
+
+	assert.NoError(t, err)
+	assert.Len(t, recs, 2)
+	assert.Equal(t, int64(0), summary.Differing)
+}
+
+func TestBucketDifferCountsHeadFailuresAsDiffering(t *testing.T) {
+	src, dst := diffMocks(logger.NewUPPLogger("diff_test", "Debug"), "hashA", "hashB")
+	dst.notFoundError = errors.New("head failed")
+	recs, summary, err := collectDiff(newTestBucketDiffer(t, src, dst, true))
+
+	assert.NoError(t, err)
+	assert.Equal(t, DiffRecord{
+		UUID: "123e4567-e89b-12d3-a456-426655440003", Status: DiffDiffering, Error: "head failed",
+		SourceKey: "src/123e4567/e89b/12d3/a456/426655440003", TargetKey: "dst/123e4567/e89b/12d3/a456/426655440003",
+	}, recs[1])
+	assert.Equal(t, DiffSummary{Compared: 3, Missing: 1, Extra: 1, Differing: 1, Repaired: 1}, summary)
+}
+
+func TestBucketDifferRepairsTarget(t *testing.T) {
+	src, dst := diffMocks(logger.NewUPPLogger("diff_test", "Debug"), "hashA", "hashB")
+	recs, summary, err := collectDiff(newTestBucketDiffer(t, src, dst, true))
+
+	assert.NoError(t, err)
+	for _, rec := range recs {
+		assert.Equal(t, rec.Status != DiffExtra, rec.Repaired, rec.UUID)
+	}
+	assert.Equal(t, int64(2), summary.Repaired)
+	assert.Equal(t, []string{
+		"srcBucket/src/123e4567/e89b/12d3/a456/426655440001 -> dst/123e4567/e89b/12d3/a456/426655440001",
+		"srcBucket/src/123e4567/e89b/12d3/a456/426655440003 -> dst/123e4567/e89b/12d3/a456/426655440003",
+	}, copiedKeys(dst))
+}
+
+func TestBucketDifferListingError(t *testing.T) {
+	src, dst := diffMocks(logger.NewUPPLogger("diff_test", "Debug"), "hashA", "hashB")
+	dst.listObjectsV2Outputs = nil
+	dst.s3error = errors.New("listing failed")
+	recs, _, err := collectDiff(newTestBucketDiffer(t, src, dst, true))
+
+	assert.EqualError(t, err, "listing failed")
+	assert.Empty(t, recs)
+	assert.Empty(t, dst.copyObjectInputs)
+}
+
+func TestBucketDifferStopsOnEmitError(t *testing.T) {
+	src, dst := diffMocks(logger.NewUPPLogger("diff_test", "Debug"), "hashA", "hashB")
+	calls := 0
+	_, err := newTestBucketDiffer(t, src, dst, false).Diff(func(rec DiffRecord) error {
+		calls++
+		return errors.New("client went away")
+	})
+
+	assert.EqualError(t, err, "client went away")
+	assert.Equal(t, 1, calls)
+}
+
+func TestNewBucketDifferErrors(t *testing.T) {
+	log := logger.NewUPPLogger("diff_test", "Debug")
+	_, err := NewBucketDiffer(BucketLocation{Bucket: "a", Layout: LayoutPartitioned}, BucketLocation{Layout: LayoutPartitioned}, false, log)
+	assert.Error(t, err)
+	_, err = NewBucketDiffer(BucketLocation{Bucket: "a", Layout: LayoutPartitioned}, BucketLocation{Bucket: "a", Layout: LayoutPartitioned}, false, log)
+	assert.Error(t, err)
+}
+
+func TestHandleDiff(t *testing.T) {
+	log := logger.NewUPPLogger("diff_test", "Debug")
+	tests := []struct {
+		name         string
+		method       string
+		query        string
+		repaired     int64
+		expectedCode int
+	}{
+		{name: "report", method: http.MethodGet, expectedCode: http.StatusOK},
+		{name: "repair", method: http.MethodPost, repaired: 2, expectedCode: http.StatusOK},
+		{name: "target from the request is ignored", method: http.MethodGet, query: "?targetBucket=otherBucket&targetPrefix=src&targetRegion=us-east-1", expectedCode: http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			src, dst := diffMocks(log, "hashA", "hashB")
+			dh := NewDiffHandler(
+				BucketLocation{Svc: src, Bucket: "srcBucket", Prefix: "src", Layout: LayoutPartitioned},
+				BucketLocation{Svc: dst, Bucket: "dstBucket", Prefix: "dst", Layout: LayoutPartitioned},
+				log)
+
+			rec := httptest.NewRecorder()
+			dh.HandleDiff(rec, httptest.NewRequest(test.method, "/__diff"+test.query, nil))
+
+			assert.Equal(t, test.expectedCode, rec.Code)
+			if test.expectedCode != http.StatusOK {
+				return
+			}
+			assert.Equal(t, "application/x-ndjson", rec.Header().Get("Content-Type"))
+
+			var lines []string
+			scanner := bufio.NewScanner(strings.NewReader(rec.Body.String()))
+			for scanner.Scan() {
+				lines = append(lines, scanner.Text())
+			}
+			assert.Len(t, lines, 4)
+
+			var result DiffResult
+			assert.NoError(t, json.Unmarshal([]byte(lines[len(lines)-1]), &result))
+			assert.Equal(t, DiffSummary{Compared: 3, Missing: 1, Extra: 1, Differing: 1, Repaired: test.repaired}, result.Summary)
+			assert.Empty(t, result.Error)
+		})
+	}
+}
+
+func TestHandleDiffWithoutTarget(t *testing.T) {
+	log := logger.NewUPPLogger("diff_test", "Debug")
+	src, _ := diffMocks(log, "hashA", "hashB")
+	dh := NewDiffHandler(BucketLocation{Svc: src, Bucket: "srcBucket", Prefix: "src", Layout: LayoutPartitioned}, BucketLocation{}, log)
+
+	rec := httptest.NewRecorder()
+	dh.HandleDiff(rec, httptest.NewRequest(http.MethodPost, "/__diff?targetBucket=otherBucket", nil))
+
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, "{\"message\":\"no mirror bucket is configured for this resource\"}\n", rec.Body.String())
+}
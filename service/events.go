@@ -0,0 +1,145 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/Financial-Times/go-logger/v2"
+	"github.com/Financial-Times/kafka-client-go/v4"
+	transactionid "github.com/Financial-Times/transactionid-utils-go"
+)
+
+const (
+	changeEventMessageType = "generic-rw-s3-change"
+	messageTimestampFormat = "2006-01-02T15:04:05.000Z"
+)
+
+// ChangeEvent announces an item created, updated or deleted through the service.
+type ChangeEvent struct {
+	UUID          string `json:"uuid"`
+	Path          string `json:"path,omitempty"`
+	Status        string `json:"status"`
+	Hash          string `json:"hash,omitempty"`
+	TransactionID string `json:"transactionId"`
+}
+
+type messageProducer interface {
+	SendMessage(message kafka.FTMessage) error
+}
+
+type hashStore interface {
+	storedHash(uuid string, path string) (uint64, bool, error)
+}
+
+// EventWriter sends a ChangeEvent for every item created, updated or deleted by a Writer, whether written over HTTP,
+// imported or consumed from Kafka. Failing to send an event does not fail the write.
+// Writes which leave an item as it was, and deletes of items which did not exist, are not announced. They are found by
+// reading the item's hash from the store before the change, so changes made by other writers in between may be missed.
+type EventWriter struct {
+	Writer
+	store    hashStore
+	producer messageProducer
+	log      *logger.UPPLogger
+}
+
+// NewEventWriter announces the changes made by writer. The store is the S3Writer the items are written to, other
+// writers cannot tell whether an item was changed, and every write and delete is then announced.
+func NewEventWriter(writer Writer, store Writer, producer messageProducer, log *logger.UPPLogger) *EventWriter {
+	hs, _ := store.(hashStore)
+	return &EventWriter{Writer: writer, store: hs, producer: producer, log: log}
+}
+
+// previousHash reads the hash of an item before it is changed, reporting whether it existed and whether it could be read.
+func (w *EventWriter) previousHash(uuid string, path string, tid string) (uint64, bool, bool) {
+	if w.store == nil {
+		return 0, false, false
+	}
+	hash, found, err := w.store.storedHash(uuid, path)
+	if err != nil {
+		w.log.WithError(err).WithTransactionID(tid).WithUUID(uuid).Warn("Could not read the stored hash, the change is announced")
+		return 0, false, false
+	}
+	return hash, found, true
+}
+
+func (w *EventWriter) Write(uuid string, path string, b *[]byte, ct string, tid string, ignoreHash bool) (Status, error) {
+	previous, existed, checked := w.previousHash(uuid, path, tid)
+	status, err := w.Writer.Write(uuid, path, b, ct, tid, ignoreHash)
+	if err != nil || (status != CREATED && status != UPDATED) {
+		return status, err
+	}
+
+	event := ChangeEvent{UUID: uuid, Path: path, Status: "UPDATED", TransactionID: tid}
+	if status == CREATED {
+		event.Status = "CREATED"
+	}
+	// The hash stored as the item's Current-Object-Hash
+	if hash, err := payloadHash(b); err == nil {
+		if status == UPDATED && checked && existed && hash == previous {
+			w.log.WithTransactionID(tid).WithUUID(uuid).Debug("Item was rewritten unchanged, no change event sent")
+			return status, nil
+		}
+		event.Hash = strconv.FormatUint(hash, 10)
+	}
+	w.send(event)
+	return status, nil
+}
+
+func (w *EventWriter) Delete(uuid string, path string, tid string) error {
+	_, existed, checked := w.previousHash(uuid, path, tid)
+	if err := w.Writer.Delete(uuid, path, tid); err != nil {
+		return err
+	}
+	if checked && !existed {
+		w.log.WithTransactionID(tid).WithUUID(uuid).Debug("Deleted item did not exist, no change event sent")
+		return nil
+	}
+	w.send(ChangeEvent{UUID: uuid, Path: path, Status: "DELETED", TransactionID: tid})
+	return nil
+}
+
+func (w *EventWriter) send(event ChangeEvent) {
+	log := w.log.WithTransactionID(event.TransactionID).WithUUID(event.UUID)
+	msg, err := newChangeMessage(event)
+	if err == nil {
+		err = w.producer.SendMessage(msg)
+	}
+	if err != nil {
+		log.WithError(err).Errorf("Failed to send %s change event", event.Status)
+		return
+	}
+	log.Debugf("Sent %s change event", event.Status)
+}
+
+func newChangeMessage(event ChangeEvent) (kafka.FTMessage, error) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return kafka.FTMessage{}, err
+	}
+	id, err := newMessageID()
+	if err != nil {
+		return kafka.FTMessage{}, err
+	}
+	headers := map[string]string{
+		transactionid.TransactionIDHeader: event.TransactionID,
+		"Message-Id":                      id,
+		"Message-Type":                    changeEventMessageType,
+		"Message-Timestamp":               time.Now().UTC().Format(messageTimestampFormat),
+		"Content-Type":                    "application/json",
+	}
+	return kafka.NewFTMessage(headers, string(body)), nil
+}
+
+// newMessageID returns a random (version 4) UUID identifying a message.
+func newMessageID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
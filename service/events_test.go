@@ -0,0 +1,187 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Financial-Times/go-logger/v2"
+	"github.com/Financial-Times/kafka-client-go/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockProducer struct {
+	sync.Mutex
+	messages []kafka.FTMessage
+	err      error
+}
+
+func (p *mockProducer) SendMessage(message kafka.FTMessage) error {
+	p.Lock()
+	defer p.Unlock()
+	if p.err != nil {
+		return p.err
+	}
+	p.messages = append(p.messages, message)
+	return nil
+}
+
+func (p *mockProducer) events(t *testing.T) []ChangeEvent {
+	p.Lock()
+	defer p.Unlock()
+	var events []ChangeEvent
+	for _, m := range p.messages {
+		var e ChangeEvent
+		assert.NoError(t, json.Unmarshal([]byte(m.Body), &e))
+		events = append(events, e)
+	}
+	return events
+}
+
+func TestEventWriter(t *testing.T) {
+	log := logger.NewUPPLogger("events_test", "Debug")
+	b := []byte(`{"prefLabel": "Brand"}`)
+	hash, err := payloadHash(&b)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name           string
+		writeStatus    Status
+		returnError    error
+		expectedEvents []ChangeEvent
+	}{
+		{
+			name:           "created",
+			writeStatus:    CREATED,
+			expectedEvents: []ChangeEvent{{UUID: indexedUUID, Path: "TestDirectory", Status: "CREATED", Hash: strconv.FormatUint(hash, 10), TransactionID: "tid_test"}},
+		},
+		{
+			name:           "updated",
+			writeStatus:    UPDATED,
+			expectedEvents: []ChangeEvent{{UUID: indexedUUID, Path: "TestDirectory", Status: "UPDATED", Hash: strconv.FormatUint(hash, 10), TransactionID: "tid_test"}},
+		},
+		{
+			name:        "unchanged",
+			writeStatus: UNCHANGED,
+		},
+		{
+			name:        "failed",
+			writeStatus: SERVICE_UNAVAILABLE,
+			returnError: errors.New("S3 unavailable"),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := &mockProducer{}
+			w := NewEventWriter(&mockWriter{writeStatus: test.writeStatus, returnError: test.returnError}, nil, p, log)
+
+			status, err := w.Write(indexedUUID, "TestDirectory", &b, "application/json", "tid_test", false)
+			assert.Equal(t, test.returnError, err)
+			assert.Equal(t, test.writeStatus, status)
+			assert.Equal(t, test.expectedEvents, p.events(t))
+		})
+	}
+}
+
+func TestEventWriterDelete(t *testing.T) {
+	p := &mockProducer{}
+	mw := &mockWriter{deleteError: errors.New("delete failed")}
+	w := NewEventWriter(mw, nil, p, logger.NewUPPLogger("events_test", "Debug"))
+
+	assert.EqualError(t, w.Delete(indexedUUID, "", "tid_test"), "delete failed")
+	assert.Empty(t, p.events(t))
+
+	mw.deleteError = nil
+	assert.NoError(t, w.Delete(indexedUUID, "", "tid_test"))
+	assert.Equal(t, []ChangeEvent{{UUID: indexedUUID, Status: "DELETED", TransactionID: "tid_test"}}, p.events(t))
+}
+
+// hashStoreMock is a store holding the hashes of the items which exist.
+type hashStoreMock struct {
+	mockWriter
+	hashes map[string]uint64
+	err    error
+}
+
+func (s *hashStoreMock) storedHash(uuid string, path string) (uint64, bool, error) {
+	hash, found := s.hashes[uuid]
+	return hash, found, s.err
+}
+
+func TestEventWriterSkipsUnchangedItems(t *testing.T) {
+	log := logger.NewUPPLogger("events_test", "Debug")
+	b := []byte(`{"prefLabel": "Brand"}`)
+	hash, err := payloadHash(&b)
+	assert.NoError(t, err)
+	p := &mockProducer{}
+	store := &hashStoreMock{hashes: map[string]uint64{indexedUUID: hash}}
+	w := NewEventWriter(&mockWriter{writeStatus: UPDATED}, store, p, log)
+
+	status, err := w.Write(indexedUUID, "", &b, "application/json", "tid_test", true)
+	assert.NoError(t, err)
+	assert.Equal(t, UPDATED, status)
+	assert.Empty(t, p.events(t), "rewriting the stored payload is not announced")
+
+	assert.NoError(t, w.Delete(otherIndexedUUID, "", "tid_test"))
+	assert.Empty(t, p.events(t), "deleting an item which did not exist is not announced")
+
+	changed := []byte(`{"prefLabel": "Changed"}`)
+	_, err = w.Write(indexedUUID, "", &changed, "application/json", "tid_test", false)
+	assert.NoError(t, err)
+	assert.NoError(t, w.Delete(indexedUUID, "", "tid_test"))
+	store.err = errors.New("S3 unavailable")
+	_, err = w.Write(indexedUUID, "", &b, "application/json", "tid_test", false)
+	assert.NoError(t, err)
+
+	var statuses []string
+	for _, e := range p.events(t) {
+		statuses = append(statuses, e.Status)
+	}
+	assert.Equal(t, []string{"UPDATED", "DELETED", "UPDATED"}, statuses, "changes are announced when the stored hash cannot be read")
+}
+
+func TestEventWriterSendFailure(t *testing.T) {
+	p := &mockProducer{err: errors.New("kafka unavailable")}
+	w := NewEventWriter(&mockWriter{writeStatus: CREATED}, nil, p, logger.NewUPPLogger("events_test", "Debug"))
+
+	b := []byte(`{}`)
+	status, err := w.Write(indexedUUID, "", &b, "application/json", "tid_test", false)
+	assert.NoError(t, err, "failing to send the event does not fail the write")
+	assert.Equal(t, CREATED, status)
+	assert.NoError(t, w.Delete(indexedUUID, "", "tid_test"))
+}
+
+func TestEventWriterFromQProcessor(t *testing.T) {
+	log := logger.NewUPPLogger("events_test", "Debug")
+	p := &mockProducer{}
+	qp := NewQProcessor(NewEventWriter(&mockWriter{writeStatus: UPDATED}, nil, p, log), log)
+
+	qp.ProcessMsg(kafka.NewFTMessage(map[string]string{"X-Request-Id": "tid_kafka"}, `{"uuid": "`+indexedUUID+`"}`))
+
+	events := p.events(t)
+	assert.Len(t, events, 1)
+	assert.Equal(t, indexedUUID, events[0].UUID)
+	assert.Equal(t, "UPDATED", events[0].Status)
+	assert.Equal(t, "tid_kafka", events[0].TransactionID)
+}
+
+func TestNewChangeMessage(t *testing.T) {
+	event := ChangeEvent{UUID: indexedUUID, Status: "DELETED", TransactionID: "tid_test"}
+	msg, err := newChangeMessage(event)
+	assert.NoError(t, err)
+
+	assert.JSONEq(t, `{"uuid":"`+indexedUUID+`","status":"DELETED","transactionId":"tid_test"}`, msg.Body)
+	assert.Equal(t, "tid_test", msg.Headers["X-Request-Id"])
+	assert.Equal(t, "generic-rw-s3-change", msg.Headers["Message-Type"])
+	assert.Equal(t, "application/json", msg.Headers["Content-Type"])
+	assert.Regexp(t, `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`, msg.Headers["Message-Id"])
+	_, err = time.Parse(messageTimestampFormat, msg.Headers["Message-Timestamp"])
+	assert.NoError(t, err)
+
+	other, err := newChangeMessage(event)
+	assert.NoError(t, err)
+	assert.NotEqual(t, msg.Headers["Message-Id"], other.Headers["Message-Id"])
+}
@@ -0,0 +1,305 @@
+package service
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	formatEnvelope  = "envelope"
+	formatJSONArray = "json-array"
+)
+
+// GetAllOptions filters the streamed items, sets how often a checkpoint marker is streamed and the format of the stream.
+// Raw streams payloads as they are stored, rather than one JSON record per line. Fields projects JSON payloads,
+// and Filter only streams the JSON payloads which match it.
+type GetAllOptions struct {
+	ListFilter
+	CheckpointEvery int
+	Format          string
+	Raw             bool
+	Fields          projection
+	Filter          payloadFilter
+	StreamOptions
+}
+
+func parseGetAllOptions(r *http.Request) (GetAllOptions, error) {
+	var opts GetAllOptions
+	var err error
+	if opts.ListFilter, err = parseListFilter(r); err != nil {
+		return opts, err
+	}
+	if c := r.URL.Query().Get("checkpointEvery"); c != "" {
+		if opts.CheckpointEvery, err = strconv.Atoi(c); err != nil || opts.CheckpointEvery < 1 {
+			return opts, errors.New("checkpointEvery must be a positive number")
+		}
+	}
+	opts.Raw = r.URL.Query().Get("raw") == "true"
+	if opts.Fields, err = parseProjection(r); err != nil {
+		return opts, err
+	}
+	if opts.Filter, err = parsePayloadFilter(r); err != nil {
+		return opts, err
+	}
+	opts.StreamOptions = parseStreamOptions(r)
+	opts.Format = r.URL.Query().Get("format")
+	if opts.Format != "" && opts.Format != formatEnvelope && opts.Format != formatJSONArray {
+		return opts, fmt.Errorf("format must be %s or %s", formatEnvelope, formatJSONArray)
+	}
+	return opts, nil
+}
+
+// getAllContentType returns the content type of a stream in the given format.
+func getAllContentType(format string) string {
+	switch format {
+	case formatEnvelope:
+		return "application/x-ndjson"
+	case formatJSONArray:
+		return "application/json"
+	case formatTarGz:
+		return "application/gzip"
+	case formatZip:
+		return "application/zip"
+	default:
+		return "application/octet-stream"
+	}
+}
+
+type exportJob struct {
+	item   listedObject
+	result chan<- exportItem
+}
+
+type exportItem struct {
+	listedObject
+	body        io.ReadCloser // nil when the item is no longer found
+	contentType string
+	err         error
+}
+
+type checkpoint struct {
+	UUID string `json:"checkpoint"`
+}
+
+// envelope is a streamed item along with its details. Bodies which are not JSON are base64 encoded.
+// Items which could not be read are streamed without a body, with an error instead.
+type envelope struct {
+	UUID         string          `json:"uuid"`
+	Path         string          `json:"path,omitempty"`
+	ContentType  string          `json:"contentType,omitempty"`
+	LastModified *time.Time      `json:"lastModified,omitempty"`
+	Body         json.RawMessage `json:"body,omitempty"`
+	BodyEncoding string          `json:"bodyEncoding,omitempty"`
+	Error        string          `json:"error,omitempty"`
+}
+
+func newEnvelope(item exportItem, path string, body []byte) envelope {
+	e := envelope{
+		UUID:         item.uuid,
+		Path:         path,
+		ContentType:  item.contentType,
+		LastModified: item.lastModified,
+	}
+	// Payloads are only inlined when encoding them leaves them as they are, so importing them keeps their hash
+	if inlined, err := json.Marshal(json.RawMessage(body)); err == nil && bytes.Equal(inlined, body) {
+		e.Body = body
+		return e
+	}
+	e.Body, _ = json.Marshal(base64.StdEncoding.EncodeToString(body))
+	e.BodyEncoding = "base64"
+	return e
+}
+
+const errItemUnreadable = "item could not be read"
+
+// GetAll streams the items in key order, fetching them in parallel. With CheckpointEvery set a checkpoint marker holding
+// the UUID of the last item is streamed after that many items, which can be passed as StartAfter to resume the stream.
+// No checkpoint is streamed once an item could not be read, so resuming from the last one reads that item again.
+// In the tar.gz and zip formats the stream is an archive with an entry per item.
+func (r *S3Reader) GetAll(opts GetAllOptions) (*io.PipeReader, error) {
+	err := r.checkListOk(opts.Path)
+	pv, pw := io.Pipe()
+	if err != nil {
+		pv.Close()
+		return pv, err
+	}
+
+	itemSize := float32(r.workers) * 1.5
+	// Results are queued in key order and the queue's size bounds how far workers can get ahead of the stream.
+	results := make(chan chan exportItem, int(itemSize))
+	jobs := make(chan exportJob)
+	keys := make(chan listedObject, 3000) //  Three times the default Page size
+	st := newStream(pw, opts.StreamOptions)
+	if isArchive(opts.Format) {
+		go r.archiveItems(results, st, opts.Format)
+	} else {
+		go r.processItems(results, st, opts)
+	}
+	tw := int(r.workers)
+	for w := 0; w < tw; w++ {
+		go r.getItemWorker(opts.Path, jobs, st)
+	}
+
+	go func() {
+		defer close(results)
+		defer close(jobs)
+		for item := range keys {
+			result := make(chan exportItem, 1)
+			results <- result
+			jobs <- exportJob{item: item, result: result}
+		}
+	}()
+
+	go func() {
+		defer close(keys)
+		if err := r.listObjects(keys, opts.ListFilter, st.done); err != nil {
+			r.log.WithError(err).Error("Got an error reading content of bucket")
+			st.fail(err)
+		}
+	}()
+
+	return pv, err
+}
+
+// getItemWorker reads the items of the jobs it is given, skipping them once the stream has been aborted.
+func (r *S3Reader) getItemWorker(path string, jobs <-chan exportJob, st *stream) {
+	for job := range jobs {
+		item := exportItem{listedObject: job.item}
+		if !st.aborted() {
+			found, i, ct, err := r.Get(job.item.uuid, path)
+			switch {
+			case err != nil:
+				r.log.WithError(err).WithUUID(job.item.uuid).Error("Error reading from S3")
+				item.err = err
+			case found:
+				item.body = i
+				if ct != nil {
+					item.contentType = *ct
+				}
+			}
+		}
+		job.result <- item
+	}
+}
+
+func (r *S3Reader) processItems(results <-chan chan exportItem, st *stream, opts GetAllOptions) {
+	encoder := json.NewEncoder(st)
+	if opts.Format == formatJSONArray {
+		io.WriteString(st, "[")
+	}
+
+	records := 0
+	streamed := 0
+	failed := false
+	// Items which could not be read are skipped, or streamed as error records in envelope formats.
+	fail := func(item exportItem, err error) {
+		st.fail(err)
+		failed = true
+		if opts.Format == "" || st.aborted() {
+			return
+		}
+		if r.writeRecord(st, encoder, envelope{UUID: item.uuid, Path: opts.Path, Error: errItemUnreadable}, opts.Format, records) == nil {
+			records++
+		}
+	}
+	for result := range results {
+		item := <-result
+		if item.err != nil {
+			fail(item, item.err)
+			continue
+		}
+		if item.body == nil {
+			continue
+		}
+		if st.aborted() {
+			item.body.Close()
+			continue
+		}
+		if len(opts.Filter) > 0 {
+			matched, err := opts.Filter.matchItem(&item)
+			if err != nil {
+				r.log.WithError(err).WithUUID(item.uuid).Error("Error reading from S3")
+				fail(item, err)
+				continue
+			}
+			if !matched {
+				item.body.Close()
+				continue
+			}
+		}
+
+		err := r.writeItem(st, encoder, item, opts, records)
+		item.body.Close()
+		if err != nil {
+			r.log.WithError(err).WithUUID(item.uuid).Error("Error reading from S3")
+			fail(item, err)
+			continue
+		}
+
+		records++
+		st.Report.addItem()
+		streamed++
+		if opts.Format != formatJSONArray && opts.CheckpointEvery > 0 && !failed && streamed%opts.CheckpointEvery == 0 {
+			encoder.Encode(checkpoint{UUID: item.uuid})
+		}
+	}
+
+	if opts.Format == formatJSONArray {
+		io.WriteString(st, "]\n")
+	}
+	st.pw.Close()
+}
+
+// writeItem writes an item as the given record of the stream.
+func (r *S3Reader) writeItem(w io.Writer, encoder *json.Encoder, item exportItem, opts GetAllOptions, record int) error {
+	if opts.Format == "" && opts.Raw && len(opts.Fields) == 0 {
+		if _, err := io.Copy(w, item.body); err != nil {
+			return err
+		}
+		_, err := io.WriteString(w, "\n")
+		return err
+	}
+
+	body, err := io.ReadAll(item.body)
+	if err != nil {
+		return err
+	}
+	body = opts.Fields.apply(body)
+	if opts.Format == "" && opts.Raw {
+		_, err := w.Write(append(body, '\n'))
+		return err
+	}
+	if opts.Format == "" {
+		// JSON payloads are compacted onto a single line, anything else is streamed in an envelope.
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, body); err == nil {
+			compact.WriteString("\n")
+			_, err = compact.WriteTo(w)
+			return err
+		}
+	}
+	return r.writeRecord(w, encoder, newEnvelope(item, opts.Path, body), opts.Format, record)
+}
+
+// writeRecord writes an envelope as a line of the stream, or as an element of the array in json-array format.
+func (r *S3Reader) writeRecord(w io.Writer, encoder *json.Encoder, e envelope, format string, record int) error {
+	if format != formatJSONArray {
+		return encoder.Encode(e)
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if record > 0 {
+		b = append([]byte(","), b...)
+	}
+	_, err = w.Write(b)
+	return err
+}
@@ -0,0 +1,191 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Financial-Times/go-logger/v2"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/assert"
+)
+
+func exportMock(log *logger.UPPLogger) (*S3Reader, *mockS3Client) {
+	r, s := getReader(log)
+	s.ct = "application/json"
+	s.payloads = map[string]string{
+		"test/prefix/123e4567/e89b/12d3/a456/426655440001": `{"id":1}`,
+		"test/prefix/123e4567/e89b/12d3/a456/426655440002": "not json",
+	}
+	s.listObjectsV2Outputs = []*s3.ListObjectsV2Output{
+		{KeyCount: aws.Int64(1)},
+		{
+			KeyCount: aws.Int64(2),
+			Contents: []*s3.Object{
+				{Key: aws.String("test/prefix/123e4567/e89b/12d3/a456/426655440001"), LastModified: aws.Time(time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC))},
+				{Key: aws.String("test/prefix/123e4567/e89b/12d3/a456/426655440002")},
+			},
+		},
+	}
+	return r.(*S3Reader), s
+}
+
+func TestS3Reader_GetAllEnvelope(t *testing.T) {
+	r, _ := exportMock(logger.NewUPPLogger("export_test", "Debug"))
+	p, err := r.GetAll(GetAllOptions{Format: formatEnvelope, CheckpointEvery: 2})
+	assert.NoError(t, err)
+	payload, err := io.ReadAll(p)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"uuid":"123e4567-e89b-12d3-a456-426655440001","contentType":"application/json","lastModified":"2024-03-01T00:00:00Z","body":{"id":1}}
+{"uuid":"123e4567-e89b-12d3-a456-426655440002","contentType":"application/json","body":"bm90IGpzb24=","bodyEncoding":"base64"}
+{"checkpoint":"123e4567-e89b-12d3-a456-426655440002"}
+`, string(payload))
+}
+
+func TestS3Reader_GetAllCheckpointsStopAtUnreadableItems(t *testing.T) {
+	r, s := exportMock(logger.NewUPPLogger("export_test", "Debug"))
+	s.getObjectErrors = map[string]error{"test/prefix/123e4567/e89b/12d3/a456/426655440001": errors.New("read failed")}
+	p, err := r.GetAll(GetAllOptions{Format: formatEnvelope, CheckpointEvery: 1})
+	assert.NoError(t, err)
+	payload, err := io.ReadAll(p)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"uuid":"123e4567-e89b-12d3-a456-426655440001","error":"item could not be read"}
+{"uuid":"123e4567-e89b-12d3-a456-426655440002","contentType":"application/json","body":"bm90IGpzb24=","bodyEncoding":"base64"}
+`, string(payload), "no checkpoint is streamed past an item which could not be read")
+}
+
+func TestS3Reader_GetAllEnvelopeKeepsPayloads(t *testing.T) {
+	r, s := exportMock(logger.NewUPPLogger("export_test", "Debug"))
+	stored := "{\n  \"id\": 1,\n  \"label\": \"<b>\"\n}\n"
+	s.payloads["test/prefix/123e4567/e89b/12d3/a456/426655440001"] = stored
+	p, err := r.GetAll(GetAllOptions{Format: formatEnvelope})
+	assert.NoError(t, err)
+	payload, err := io.ReadAll(p)
+	assert.NoError(t, err)
+
+	line, _, _ := strings.Cut(string(payload), "\n")
+	item, ok, err := ndjsonItem([]byte(line), "")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, stored, string(item.body), "payloads which encoding would change are base64 encoded")
+}
+
+func TestS3Reader_GetAllCompactsPayloads(t *testing.T) {
+	r, s := exportMock(logger.NewUPPLogger("export_test", "Debug"))
+	s.payloads["test/prefix/123e4567/e89b/12d3/a456/426655440001"] = "{\n  \"id\": 1,\n  \"tags\": [\"a\", \"b\"]\n}\n"
+	p, err := r.GetAll(GetAllOptions{})
+	assert.NoError(t, err)
+	payload, err := io.ReadAll(p)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"id":1,"tags":["a","b"]}
+{"uuid":"123e4567-e89b-12d3-a456-426655440002","contentType":"application/json","body":"bm90IGpzb24=","bodyEncoding":"base64"}
+`, string(payload))
+}
+
+func TestS3Reader_GetAllRaw(t *testing.T) {
+	r, s := exportMock(logger.NewUPPLogger("export_test", "Debug"))
+	s.payloads["test/prefix/123e4567/e89b/12d3/a456/426655440001"] = "{\n  \"id\": 1\n}"
+	p, err := r.GetAll(GetAllOptions{Raw: true})
+	assert.NoError(t, err)
+	payload, err := io.ReadAll(p)
+	assert.NoError(t, err)
+	assert.Equal(t, "{\n  \"id\": 1\n}\nnot json\n", string(payload))
+}
+
+func TestS3Reader_GetAllProjectsFields(t *testing.T) {
+	for _, opts := range []GetAllOptions{{}, {Raw: true}} {
+		r, s := exportMock(logger.NewUPPLogger("export_test", "Debug"))
+		s.payloads["test/prefix/123e4567/e89b/12d3/a456/426655440001"] = `{"id": 1, "prefLabel": "Brand", "type": {"id": "Brand", "label": "A brand"}}`
+		opts.Fields = projection{{"prefLabel"}, {"type", "id"}}
+		p, err := r.GetAll(opts)
+		assert.NoError(t, err)
+		payload, err := io.ReadAll(p)
+		assert.NoError(t, err)
+		assert.Contains(t, string(payload), `{"prefLabel":"Brand","type":{"id":"Brand"}}`+"\n")
+	}
+}
+
+func TestS3Reader_GetAllFiltersPayloads(t *testing.T) {
+	r, s := exportMock(logger.NewUPPLogger("export_test", "Debug"))
+	s.payloads["test/prefix/123e4567/e89b/12d3/a456/426655440002"] = `{"id": 2, "type": "Brand"}`
+	p, err := r.GetAll(GetAllOptions{Filter: payloadFilter{{path: []string{"type"}, op: predicateEquals, values: []interface{}{"Brand"}}}, CheckpointEvery: 1})
+	assert.NoError(t, err)
+	payload, err := io.ReadAll(p)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"id":2,"type":"Brand"}
+{"checkpoint":"123e4567-e89b-12d3-a456-426655440002"}
+`, string(payload))
+}
+
+func TestS3Reader_GetAllJSONArray(t *testing.T) {
+	r, _ := exportMock(logger.NewUPPLogger("export_test", "Debug"))
+	p, err := r.GetAll(GetAllOptions{Format: formatJSONArray, CheckpointEvery: 1})
+	assert.NoError(t, err)
+	payload, err := io.ReadAll(p)
+	assert.NoError(t, err)
+
+	var items []envelope
+	assert.NoError(t, json.Unmarshal(payload, &items))
+	assert.Len(t, items, 2)
+	assert.Equal(t, "123e4567-e89b-12d3-a456-426655440001", items[0].UUID)
+	assert.JSONEq(t, `{"id": 1}`, string(items[0].Body))
+	assert.Equal(t, "base64", items[1].BodyEncoding)
+}
+
+func TestS3Reader_GetAllJSONArrayEmpty(t *testing.T) {
+	r, s := exportMock(logger.NewUPPLogger("export_test", "Debug"))
+	s.listObjectsV2Outputs = []*s3.ListObjectsV2Output{{KeyCount: aws.Int64(1)}, {}}
+	p, err := r.GetAll(GetAllOptions{Format: formatJSONArray})
+	assert.NoError(t, err)
+	payload, err := io.ReadAll(p)
+	assert.NoError(t, err)
+	assert.Equal(t, "[]\n", string(payload))
+}
+
+func TestS3Reader_GetAllReportsErrors(t *testing.T) {
+	log := logger.NewUPPLogger("export_test", "Debug")
+	tests := []struct {
+		name     string
+		format   string
+		expected string
+	}{
+		{name: "skipped", expected: "{\"id\":1}\n"},
+		{name: "envelope", format: formatEnvelope, expected: `{"uuid":"123e4567-e89b-12d3-a456-426655440001","contentType":"application/json","lastModified":"2024-03-01T00:00:00Z","body":{"id":1}}
+{"uuid":"123e4567-e89b-12d3-a456-426655440002","error":"item could not be read"}
+`},
+		{name: "json-array", format: formatJSONArray, expected: `[{"uuid":"123e4567-e89b-12d3-a456-426655440001","contentType":"application/json","lastModified":"2024-03-01T00:00:00Z","body":{"id":1}},{"uuid":"123e4567-e89b-12d3-a456-426655440002","error":"item could not be read"}]
+`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r, s := exportMock(log)
+			s.getObjectErrors = map[string]error{"test/prefix/123e4567/e89b/12d3/a456/426655440002": errors.New("access denied")}
+			report := &StreamReport{}
+			p, err := r.GetAll(GetAllOptions{Format: test.format, StreamOptions: StreamOptions{Report: report}})
+			assert.NoError(t, err)
+			payload, err := io.ReadAll(p)
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, string(payload))
+			assert.Equal(t, int64(1), report.Items())
+			assert.Equal(t, int64(1), report.Errors())
+		})
+	}
+}
+
+func TestS3Reader_GetAllFailFast(t *testing.T) {
+	r, s := exportMock(logger.NewUPPLogger("export_test", "Debug"))
+	s.getObjectErrors = map[string]error{"test/prefix/123e4567/e89b/12d3/a456/426655440001": errors.New("access denied")}
+	report := &StreamReport{}
+	p, err := r.GetAll(GetAllOptions{Format: formatEnvelope, StreamOptions: StreamOptions{FailFast: true, Report: report}})
+	assert.NoError(t, err)
+	payload, err := io.ReadAll(p)
+	assert.EqualError(t, err, "access denied")
+	assert.Empty(t, payload)
+	assert.Equal(t, int64(0), report.Items())
+	assert.Equal(t, int64(1), report.Errors())
+}
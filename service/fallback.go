@@ -0,0 +1,149 @@
+package service
+
+import (
+	"io"
+
+	"github.com/Financial-Times/go-logger/v2"
+	"github.com/rcrowley/go-metrics"
+)
+
+const servedByBucketHeader = "X-Served-By-Bucket"
+
+// FallbackReader reads from a primary Reader and falls back to a secondary Reader when the primary errors.
+// Missing items are not retried against the fallback, only failed requests are.
+type FallbackReader struct {
+	primary        Reader
+	primaryBucket  string
+	fallback       Reader
+	fallbackBucket string
+	primaryReads   metrics.Meter
+	fallbackReads  metrics.Meter
+	log            *logger.UPPLogger
+	onServe        func(bucket string)
+}
+
+func NewFallbackReader(primary Reader, primaryBucket string, fallback Reader, fallbackBucket string, log *logger.UPPLogger) *FallbackReader {
+	return &FallbackReader{
+		primary:        primary,
+		primaryBucket:  primaryBucket,
+		fallback:       fallback,
+		fallbackBucket: fallbackBucket,
+		primaryReads:   metrics.GetOrRegisterMeter("reads."+primaryBucket+".primary", metrics.DefaultRegistry),
+		fallbackReads:  metrics.GetOrRegisterMeter("reads."+primaryBucket+".fallback", metrics.DefaultRegistry),
+		log:            log,
+	}
+}
+
+func (r *FallbackReader) withServeHook(onServe func(bucket string)) Reader {
+	c := *r
+	c.onServe = onServe
+	return &c
+}
+
+func (r *FallbackReader) served(fromFallback bool) {
+	bucket := r.primaryBucket
+	if fromFallback {
+		bucket = r.fallbackBucket
+		r.fallbackReads.Mark(1)
+	} else {
+		r.primaryReads.Mark(1)
+	}
+	if r.onServe != nil {
+		r.onServe(bucket)
+	}
+}
+
+func (r *FallbackReader) failedOver(err error, op string) {
+	r.log.WithError(err).Warnf("Primary bucket %s failed on %s, falling back to bucket %s", r.primaryBucket, op, r.fallbackBucket)
+}
+
+func (r *FallbackReader) Get(uuid string, path string) (bool, io.ReadCloser, *string, error) {
+	found, i, ct, err := r.primary.Get(uuid, path)
+	if err == nil {
+		r.served(false)
+		return found, i, ct, nil
+	}
+
+	r.failedOver(err, "get")
+	found, i, ct, err = r.fallback.Get(uuid, path)
+	if err == nil {
+		r.served(true)
+	}
+	return found, i, ct, err
+}
+
+func (r *FallbackReader) Count(path string) (int64, error) {
+	c, err := r.primary.Count(path)
+	if err == nil {
+		r.served(false)
+		return c, nil
+	}
+
+	r.failedOver(err, "count")
+	c, err = r.fallback.Count(path)
+	if err == nil {
+		r.served(true)
+	}
+	return c, err
+}
+
+func (r *FallbackReader) Ids(opts IdsOptions) (*io.PipeReader, error) {
+	pv, err := r.primary.Ids(opts)
+	if err == nil {
+		r.served(false)
+		return pv, nil
+	}
+
+	r.failedOver(err, "ids")
+	pv, err = r.fallback.Ids(opts)
+	if err == nil {
+		r.served(true)
+	}
+	return pv, err
+}
+
+// List reads pages from the bucket which issued the cursor. Only first pages fall back, as cursors are specific to a bucket.
+// The cursors of the fallback bucket's pages are marked, so the pages after them are read from it too.
+func (r *FallbackReader) List(opts ListOptions) (ListPage, error) {
+	if isFallbackCursor(opts.Cursor) {
+		return r.listFallback(opts)
+	}
+
+	page, err := r.primary.List(opts)
+	if err == nil {
+		r.served(false)
+		return page, nil
+	}
+	if opts.Cursor != "" {
+		return page, err
+	}
+
+	r.failedOver(err, "list")
+	return r.listFallback(opts)
+}
+
+func (r *FallbackReader) listFallback(opts ListOptions) (ListPage, error) {
+	opts.Cursor = markFallbackCursor(opts.Cursor, false)
+	page, err := r.fallback.List(opts)
+	if err != nil {
+		return page, err
+	}
+	r.served(true)
+	page.NextCursor = markFallbackCursor(page.NextCursor, true)
+	return page, nil
+}
+
+func (r *FallbackReader) GetAll(opts GetAllOptions) (*io.PipeReader, error) {
+	pv, err := r.primary.GetAll(opts)
+	if err == nil {
+		r.served(false)
+		return pv, nil
+	}
+
+	r.failedOver(err, "get all")
+	pv, err = r.fallback.GetAll(opts)
+	if err == nil {
+		r.served(true)
+	}
+	return pv, err
+}
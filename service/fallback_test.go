@@ -0,0 +1,130 @@
+package service
+
+import (
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/Financial-Times/go-logger/v2"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFallbackReaderGetFromPrimary(t *testing.T) {
+	log := logger.NewUPPLogger("fallback_test", "Debug")
+	primary := &mockReader{payload: "PRIMARY", log: log}
+	fallback := &mockReader{payload: "FALLBACK", log: log}
+	fr := NewFallbackReader(primary, "primaryBucket", fallback, "fallbackBucket", log)
+
+	found, i, _, err := fr.Get(expectedUUID, "")
+
+	assert.NoError(t, err)
+	assert.True(t, found)
+	b, _ := io.ReadAll(i)
+	assert.Equal(t, "PRIMARY", string(b))
+	assert.Empty(t, fallback.uuid)
+}
+
+func TestFallbackReaderGetNotFoundDoesNotFallBack(t *testing.T) {
+	log := logger.NewUPPLogger("fallback_test", "Debug")
+	primary := &mockReader{log: log}
+	fallback := &mockReader{payload: "FALLBACK", log: log}
+	fr := NewFallbackReader(primary, "primaryBucket", fallback, "fallbackBucket", log)
+
+	found, _, _, err := fr.Get(expectedUUID, "")
+
+	assert.NoError(t, err)
+	assert.False(t, found)
+	assert.Empty(t, fallback.uuid)
+}
+
+func TestFallbackReaderFallsBackOnError(t *testing.T) {
+	log := logger.NewUPPLogger("fallback_test", "Debug")
+	primary := &mockReader{returnError: errors.New("primary unavailable"), log: log}
+	fallback := &mockReader{payload: "FALLBACK", count: 42, log: log}
+	fr := NewFallbackReader(primary, "primaryBucket", fallback, "fallbackBucket", log)
+	fallbacks := fr.fallbackReads.Count()
+
+	found, i, _, err := fr.Get(expectedUUID, "")
+	assert.NoError(t, err)
+	assert.True(t, found)
+	b, _ := io.ReadAll(i)
+	assert.Equal(t, "FALLBACK", string(b))
+
+	c, err := fr.Count("")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(42), c)
+
+	pv, err := fr.Ids(IdsOptions{})
+	assert.NoError(t, err)
+	b, _ = io.ReadAll(pv)
+	assert.Equal(t, "FALLBACK", string(b))
+
+	pv, err = fr.GetAll(GetAllOptions{})
+	assert.NoError(t, err)
+	b, _ = io.ReadAll(pv)
+	assert.Equal(t, "FALLBACK", string(b))
+
+	assert.Equal(t, int64(4), fr.fallbackReads.Count()-fallbacks)
+}
+
+func TestFallbackReaderBothFail(t *testing.T) {
+	log := logger.NewUPPLogger("fallback_test", "Debug")
+	primary := &mockReader{returnError: errors.New("primary unavailable"), log: log}
+	fallback := &mockReader{returnError: errors.New("fallback unavailable"), log: log}
+	fr := NewFallbackReader(primary, "primaryBucket", fallback, "fallbackBucket", log)
+
+	_, _, _, err := fr.Get(expectedUUID, "")
+
+	assert.EqualError(t, err, "fallback unavailable")
+}
+
+func TestReadHandlerReportsServingBucket(t *testing.T) {
+	log := logger.NewUPPLogger("fallback_test", "Debug")
+	primary := &mockReader{returnError: errors.New("primary unavailable"), log: log}
+	fallback := &mockReader{payload: "Some content", returnCT: "return/type", log: log}
+	r := mux.NewRouter()
+	Handlers(r, WriterHandler{}, NewReaderHandler(NewFallbackReader(primary, "primaryBucket", fallback, "fallbackBucket", log), log), ExpectedResourcePath)
+
+	rec := assertRequestAndResponseFromRouter(t, r, withExpectedResourcePath("/22f53313-85c6-46b2-94e7-cfde9322f26c"), 200, "Some content", "return/type")
+	assert.Equal(t, "fallbackBucket", rec.Header().Get(servedByBucketHeader))
+
+	primary.returnError = nil
+	primary.payload = "Some content"
+	primary.returnCT = "return/type"
+	rec = assertRequestAndResponseFromRouter(t, r, withExpectedResourcePath("/22f53313-85c6-46b2-94e7-cfde9322f26c"), 200, "Some content", "return/type")
+	assert.Equal(t, "primaryBucket", rec.Header().Get(servedByBucketHeader))
+}
+
+func TestFallbackReaderListFollowsCursorBucket(t *testing.T) {
+	log := logger.NewUPPLogger("fallback_test", "Debug")
+	primaryCursor := newListCursor(ListFilter{}, "primary").encode()
+	fallbackCursor := newListCursor(ListFilter{}, "fallback").encode()
+	primary := &mockReader{listPage: ListPage{NextCursor: primaryCursor}, log: log}
+	fallback := &mockReader{listPage: ListPage{NextCursor: fallbackCursor}, log: log}
+	fr := NewFallbackReader(primary, "primaryBucket", fallback, "fallbackBucket", log)
+
+	page, err := fr.List(ListOptions{Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, primaryCursor, page.NextCursor)
+
+	page, err = fr.List(ListOptions{Limit: 10, Cursor: markFallbackCursor(fallbackCursor, true)})
+	assert.NoError(t, err)
+	assert.Equal(t, fallbackCursor, fallback.listOptions.Cursor, "the fallback bucket reads its own cursors")
+	assert.True(t, isFallbackCursor(page.NextCursor))
+	assert.NotContains(t, page.NextCursor, "fallbackBucket")
+}
+
+func TestFallbackReaderListOnlyFallsBackOnFirstPage(t *testing.T) {
+	log := logger.NewUPPLogger("fallback_test", "Debug")
+	primary := &mockReader{returnError: errors.New("primary unavailable"), log: log}
+	fallback := &mockReader{listPage: ListPage{NextCursor: newListCursor(ListFilter{}, "fallback").encode()}, log: log}
+	fr := NewFallbackReader(primary, "primaryBucket", fallback, "fallbackBucket", log)
+
+	page, err := fr.List(ListOptions{Limit: 10})
+	assert.NoError(t, err)
+	assert.True(t, isFallbackCursor(page.NextCursor))
+
+	_, err = fr.List(ListOptions{Limit: 10, Cursor: newListCursor(ListFilter{}, "token").encode()})
+	assert.EqualError(t, err, "primary unavailable")
+}
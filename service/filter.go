@@ -0,0 +1,145 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+const (
+	predicateExists = "exists"
+	predicateEquals = "=="
+	predicateIn     = "in"
+)
+
+// predicate tests the value at a path of keys in a JSON payload.
+type predicate struct {
+	path   []string
+	op     string
+	values []interface{}
+}
+
+// payloadFilter selects the JSON payloads matching all of its predicates.
+type payloadFilter []predicate
+
+// parsePayloadFilter parses the filter parameters of a request, which are JSONPath predicates such as
+// $.type == "Brand", $.prefLabel or $.type in ["Brand", "Organisation"].
+func parsePayloadFilter(r *http.Request) (payloadFilter, error) {
+	var f payloadFilter
+	for _, expr := range r.URL.Query()["filter"] {
+		p, err := parsePredicate(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid filter %q: %w", expr, err)
+		}
+		f = append(f, p)
+	}
+	return f, nil
+}
+
+func parsePredicate(expr string) (predicate, error) {
+	expr = strings.TrimSpace(expr)
+	rest, ok := strings.CutPrefix(expr, "$.")
+	if !ok {
+		return predicate{}, errors.New("path must start with $.")
+	}
+	end := strings.IndexAny(rest, " \t=")
+	if end < 0 {
+		end = len(rest)
+	}
+	p := predicate{path: strings.Split(rest[:end], "."), op: predicateExists}
+	for _, key := range p.path {
+		if key == "" {
+			return predicate{}, errors.New("path has an empty key")
+		}
+	}
+
+	rest = strings.TrimSpace(rest[end:])
+	switch {
+	case rest == "":
+		return p, nil
+	case strings.HasPrefix(rest, predicateEquals):
+		p.op = predicateEquals
+		var v interface{}
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(rest, predicateEquals)), &v); err != nil {
+			return predicate{}, fmt.Errorf("value must be JSON: %w", err)
+		}
+		p.values = []interface{}{v}
+	case strings.HasPrefix(rest, predicateIn):
+		p.op = predicateIn
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(rest, predicateIn)), &p.values); err != nil {
+			return predicate{}, fmt.Errorf("values must be a JSON array: %w", err)
+		}
+	default:
+		return predicate{}, fmt.Errorf("operator must be %s or %s", predicateEquals, predicateIn)
+	}
+	return p, nil
+}
+
+// matches reports whether a payload is a JSON object matching every predicate.
+func (f payloadFilter) matches(body []byte) bool {
+	var doc map[string]interface{}
+	if err := json.Unmarshal(body, &doc); err != nil || doc == nil {
+		return false
+	}
+	for _, p := range f {
+		if !p.matches(doc, p.path) {
+			return false
+		}
+	}
+	return true
+}
+
+// matches reports whether the value at the path matches. Arrays along the path match when any of their elements do.
+func (p predicate) matches(v interface{}, path []string) bool {
+	if a, ok := v.([]interface{}); ok && (len(path) > 0 || p.op != predicateExists) {
+		if len(path) == 0 && p.matchesValue(a) {
+			return true
+		}
+		for _, e := range a {
+			if p.matches(e, path) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(path) == 0 {
+		return p.matchesValue(v)
+	}
+	o, ok := v.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	child, ok := o[path[0]]
+	if !ok {
+		return false
+	}
+	return p.matches(child, path[1:])
+}
+
+func (p predicate) matchesValue(v interface{}) bool {
+	if p.op == predicateExists {
+		return true
+	}
+	for _, want := range p.values {
+		if reflect.DeepEqual(v, want) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchItem reads an item's body to evaluate the filter, leaving the body to be read again when it matches.
+func (f payloadFilter) matchItem(item *exportItem) (bool, error) {
+	body, err := io.ReadAll(item.body)
+	item.body.Close()
+	if err != nil {
+		return false, err
+	}
+	item.body = io.NopCloser(bytes.NewReader(body))
+	return f.matches(body), nil
+}
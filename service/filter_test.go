@@ -0,0 +1,76 @@
+package service
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePayloadFilter(t *testing.T) {
+	q := url.Values{"filter": {`$.type == "Brand"`, "$.prefLabel", `$.scopeNote.lang in ["en", "fr"]`}}
+	f, err := parsePayloadFilter(httptest.NewRequest("GET", "/?"+q.Encode(), nil))
+	assert.NoError(t, err)
+	assert.Equal(t, payloadFilter{
+		{path: []string{"type"}, op: predicateEquals, values: []interface{}{"Brand"}},
+		{path: []string{"prefLabel"}, op: predicateExists},
+		{path: []string{"scopeNote", "lang"}, op: predicateIn, values: []interface{}{"en", "fr"}},
+	}, f)
+
+	tests := map[string]string{
+		"type":              `invalid filter "type": path must start with $.`,
+		"$.type..id":        `invalid filter "$.type..id": path has an empty key`,
+		"$.type != 1":       `invalid filter "$.type != 1": operator must be == or in`,
+		"$.type == Brand":   `invalid filter "$.type == Brand": value must be JSON: invalid character 'B' looking for beginning of value`,
+		`$.type in "Brand"`: `invalid filter "$.type in \"Brand\"": values must be a JSON array: json: cannot unmarshal string into Go value of type []interface {}`,
+	}
+	for expr, expected := range tests {
+		_, err := parsePayloadFilter(httptest.NewRequest("GET", "/?"+url.Values{"filter": {expr}}.Encode(), nil))
+		assert.EqualError(t, err, expected)
+	}
+}
+
+func TestPayloadFilterMatches(t *testing.T) {
+	body := []byte(`{
+		"prefLabel": "Financial Times",
+		"type": "Organisation",
+		"score": 1,
+		"deprecated": false,
+		"broader": {"type": "Organisation", "id": null},
+		"alternativeLabels": [{"type": "Acronym", "value": "FT"}, {"type": "Alias", "value": "The Pink Paper"}],
+		"types": ["Thing", "Concept", "Organisation"]
+	}`)
+	tests := []struct {
+		expr    string
+		matches bool
+	}{
+		{expr: "$.prefLabel", matches: true},
+		{expr: "$.broader.id", matches: true},
+		{expr: "$.scopeNote", matches: false},
+		{expr: `$.type == "Organisation"`, matches: true},
+		{expr: `$.type=="Person"`, matches: false},
+		{expr: "$.score == 1.0", matches: true},
+		{expr: "$.deprecated == false", matches: true},
+		{expr: "$.broader.id == null", matches: true},
+		{expr: `$.broader.type in ["Person", "Organisation"]`, matches: true},
+		{expr: `$.type in []`, matches: false},
+		{expr: `$.alternativeLabels.value == "FT"`, matches: true},
+		{expr: `$.alternativeLabels.type == "Brand"`, matches: false},
+		{expr: `$.types == "Concept"`, matches: true},
+		{expr: `$.types == ["Thing", "Concept", "Organisation"]`, matches: true},
+		{expr: "$.prefLabel.value", matches: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.expr, func(t *testing.T) {
+			p, err := parsePredicate(test.expr)
+			assert.NoError(t, err)
+			assert.Equal(t, test.matches, payloadFilter{p}.matches(body))
+		})
+	}
+
+	p, _ := parsePredicate("$.type")
+	assert.False(t, payloadFilter{p}.matches([]byte("<type/>")))
+	assert.False(t, payloadFilter{p}.matches([]byte(`[{"type": "Brand"}]`)))
+}
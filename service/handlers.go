@@ -24,16 +24,22 @@ func AddAdminHandlers(servicesRouter *mux.Router, requestLoggingEnabled bool, lo
 	http.HandleFunc(httpStatus.BuildInfoPath, httpStatus.BuildInfoHandler)
 	http.HandleFunc(httpStatus.BuildInfoPathDW, httpStatus.BuildInfoHandler)
 	http.HandleFunc("/__health", hc.Health())
+	http.HandleFunc("/__metrics", metricsHandler)
 
 	gtgHandler := httpStatus.NewGoodToGoHandler(hc.GTG)
 	http.HandleFunc(httpStatus.GTGPath, gtgHandler)
 	http.Handle("/", monitoringRouter)
 }
 
+func metricsHandler(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+	metrics.WriteJSONOnce(metrics.DefaultRegistry, rw)
+}
+
 func Handlers(servicesRouter *mux.Router, wh WriterHandler, rh ReaderHandler, resourcePath string) {
 	mh := handlers.MethodHandler{
 		"PUT":    http.HandlerFunc(wh.HandleWrite),
-		"GET":    http.HandlerFunc(rh.HandleGet),
+		"GET":    withCompression(rh.HandleGet),
 		"DELETE": http.HandlerFunc(wh.HandleDelete),
 	}
 
@@ -42,19 +48,98 @@ func Handlers(servicesRouter *mux.Router, wh WriterHandler, rh ReaderHandler, re
 	}
 
 	ih := handlers.MethodHandler{
-		"GET": http.HandlerFunc(rh.HandleIds),
+		"GET": withCompression(rh.HandleIds),
 	}
 
 	ah := handlers.MethodHandler{
-		"GET": http.HandlerFunc(rh.HandleGetAll),
+		"GET": withCompression(rh.HandleGetAll),
+	}
+
+	lh := handlers.MethodHandler{
+		"GET": http.HandlerFunc(rh.HandleList),
+	}
+
+	eh := handlers.MethodHandler{
+		"GET": http.HandlerFunc(rh.HandleExport),
 	}
 
+	servicesRouter.Handle(resourceRoute(resourcePath, "/{uuid:[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}}"), mh)
+	servicesRouter.Handle(resourceRoute(resourcePath, "/__count"), ch)
+	servicesRouter.Handle(resourceRoute(resourcePath, "/__ids"), ih)
+	servicesRouter.Handle(resourceRoute(resourcePath, "/__list"), lh)
+	servicesRouter.Handle(resourceRoute(resourcePath, "/__export"), eh)
+	servicesRouter.Handle(resourceRoute(resourcePath, "/"), ah)
+}
+
+func MigrationHandlers(servicesRouter *mux.Router, mh MigrationHandler, resourcePath string) {
+	ph := handlers.MethodHandler{
+		"GET": http.HandlerFunc(mh.HandleProgress),
+	}
+
+	servicesRouter.Handle(resourceRoute(resourcePath, "/__migration"), ph)
+}
+
+func ImportHandlers(servicesRouter *mux.Router, ih ImportHandler, resourcePath string) {
+	h := handlers.MethodHandler{
+		"POST": http.HandlerFunc(ih.HandleImport),
+	}
+
+	servicesRouter.Handle(resourceRoute(resourcePath, "/__import"), h)
+}
+
+func PresignHandlers(servicesRouter *mux.Router, ph PresignHandler, resourcePath string) {
+	h := handlers.MethodHandler{
+		"POST": http.HandlerFunc(ph.HandlePresign),
+	}
+
+	servicesRouter.Handle(resourceRoute(resourcePath, "/{uuid:[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}}/__presign"), h)
+}
+
+func AliasHandlers(servicesRouter *mux.Router, ah AliasHandler, resourcePath string) {
+	h := handlers.MethodHandler{
+		"GET": http.HandlerFunc(ah.HandleGetAliases),
+		"PUT": http.HandlerFunc(ah.HandlePutAliases),
+	}
+
+	servicesRouter.Handle(resourceRoute(resourcePath, "/{uuid:[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}}/__aliases"), h)
+}
+
+func IndexHandlers(servicesRouter *mux.Router, ih IndexHandler, resourcePath string) {
+	h := handlers.MethodHandler{
+		"GET": http.HandlerFunc(ih.HandleLookup),
+	}
+
+	servicesRouter.Handle(resourceRoute(resourcePath, "/__lookup"), h)
+}
+
+func ReferenceHandlers(servicesRouter *mux.Router, rh ReferenceHandler, resourcePath string) {
+	h := handlers.MethodHandler{
+		"GET": http.HandlerFunc(rh.HandleReferencedBy),
+	}
+
+	servicesRouter.Handle(resourceRoute(resourcePath, "/{uuid:[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}}/__referencedBy"), h)
+}
+
+func StatsHandlers(servicesRouter *mux.Router, sh StatsHandler, resourcePath string) {
+	h := handlers.MethodHandler{
+		"GET": http.HandlerFunc(sh.HandleStats),
+	}
+
+	servicesRouter.Handle(resourceRoute(resourcePath, "/__stats"), h)
+}
+
+func DiffHandlers(servicesRouter *mux.Router, dh DiffHandler, resourcePath string) {
+	h := handlers.MethodHandler{
+		"GET":  http.HandlerFunc(dh.HandleDiff),
+		"POST": http.HandlerFunc(dh.HandleDiff),
+	}
+
+	servicesRouter.Handle(resourceRoute(resourcePath, "/__diff"), h)
+}
+
+func resourceRoute(resourcePath string, route string) string {
 	if resourcePath != "" {
 		resourcePath = fmt.Sprintf("/%s", resourcePath)
 	}
-
-	servicesRouter.Handle(fmt.Sprintf("%s%s", resourcePath, "/{uuid:[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}}"), mh)
-	servicesRouter.Handle(fmt.Sprintf("%s%s", resourcePath, "/__count"), ch)
-	servicesRouter.Handle(fmt.Sprintf("%s%s", resourcePath, "/__ids"), ih)
-	servicesRouter.Handle(fmt.Sprintf("%s%s", resourcePath, "/"), ah)
+	return fmt.Sprintf("%s%s", resourcePath, route)
 }
@@ -9,6 +9,7 @@ import (
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/Financial-Times/go-logger/v2"
 	httpStatus "github.com/Financial-Times/service-status-go/httphandlers"
@@ -254,6 +255,22 @@ func TestReadHandlerForUUIDAndNoContentType(t *testing.T) {
 	assertRequestAndResponseFromRouter(t, r, withExpectedResourcePath("/22f53313-85c6-46b2-94e7-cfde9322f26c"), 200, "Some content", "")
 }
 
+func TestReadHandlerForUUIDWithFields(t *testing.T) {
+	log := logger.NewUPPLogger("handlers_test", "Debug")
+	r := mux.NewRouter()
+	mr := &mockReader{payload: `{"prefLabel": "Brand", "type": "Brand", "aliases": ["FT"]}`, returnCT: "application/json", log: log}
+	Handlers(r, WriterHandler{}, NewReaderHandler(mr, log), ExpectedResourcePath)
+	assertRequestAndResponseFromRouter(t, r, withExpectedResourcePath("/22f53313-85c6-46b2-94e7-cfde9322f26c?fields=prefLabel,type"), 200, `{"prefLabel":"Brand","type":"Brand"}`, "application/json")
+}
+
+func TestReadHandlerForUUIDWithInvalidFields(t *testing.T) {
+	log := logger.NewUPPLogger("handlers_test", "Debug")
+	r := mux.NewRouter()
+	mr := &mockReader{payload: "Some content", log: log}
+	Handlers(r, WriterHandler{}, NewReaderHandler(mr, log), ExpectedResourcePath)
+	assertRequestAndResponseFromRouter(t, r, withExpectedResourcePath("/22f53313-85c6-46b2-94e7-cfde9322f26c?fields=type."), 400, "{\"message\":\"invalid field \\\"type.\\\"\"}\n", ExpectedContentType)
+}
+
 func TestReadHandlerForUUIDNotFound(t *testing.T) {
 	log := logger.NewUPPLogger("handlers_test", "Debug")
 	r := mux.NewRouter()
@@ -287,6 +304,15 @@ func TestReadHandlerCountOK(t *testing.T) {
 	assertRequestAndResponseFromRouter(t, r, withExpectedResourcePath("/__count"), 200, "1337", ExpectedContentType)
 }
 
+func TestReadHandlerCountWithPath(t *testing.T) {
+	log := logger.NewUPPLogger("handlers_test", "Debug")
+	r := mux.NewRouter()
+	mr := &mockReader{count: 42, log: log}
+	Handlers(r, WriterHandler{}, NewReaderHandler(mr, log), ExpectedResourcePath)
+	assertRequestAndResponseFromRouter(t, r, withExpectedResourcePath("/__count?path=TestDirectory"), 200, "42", ExpectedContentType)
+	assert.Equal(t, "TestDirectory", mr.countPath)
+}
+
 func TestReadHandlerCountFailsReturnsServiceUnavailable(t *testing.T) {
 	log := logger.NewUPPLogger("handlers_test", "Debug")
 	r := mux.NewRouter()
@@ -303,6 +329,37 @@ func TestReaderHandlerIdsOK(t *testing.T) {
 	assertRequestAndResponseFromRouter(t, r, withExpectedResourcePath("/__ids"), 200, "PAYLOAD", "application/octet-stream")
 }
 
+func TestReaderHandlerIdsWithFields(t *testing.T) {
+	log := logger.NewUPPLogger("handlers_test", "Debug")
+	r := mux.NewRouter()
+	mr := &mockReader{payload: "PAYLOAD", log: log}
+	Handlers(r, WriterHandler{}, NewReaderHandler(mr, log), ExpectedResourcePath)
+	assertRequestAndResponseFromRouter(t, r, withExpectedResourcePath("/__ids?fields=size,hash"), 200, "PAYLOAD", "application/octet-stream")
+	assert.Equal(t, IdsOptions{Fields: []string{"size", "hash"}, StreamOptions: StreamOptions{Report: &StreamReport{items: 1}}}, mr.idsOptions)
+}
+
+func TestReaderHandlerIdsWithUnknownField(t *testing.T) {
+	log := logger.NewUPPLogger("handlers_test", "Debug")
+	r := mux.NewRouter()
+	mr := &mockReader{payload: "PAYLOAD", log: log}
+	Handlers(r, WriterHandler{}, NewReaderHandler(mr, log), ExpectedResourcePath)
+	rec := assertRequestAndResponseFromRouter(t, r, withExpectedResourcePath("/__ids?fields=size,owner"), 400, "", ExpectedContentType)
+	assert.Contains(t, rec.Body.String(), `unknown field \"owner\"`)
+}
+
+func TestReaderHandlerIdsWithFilter(t *testing.T) {
+	log := logger.NewUPPLogger("handlers_test", "Debug")
+	r := mux.NewRouter()
+	mr := &mockReader{payload: "PAYLOAD", log: log}
+	Handlers(r, WriterHandler{}, NewReaderHandler(mr, log), ExpectedResourcePath)
+	assertRequestAndResponseFromRouter(t, r, withExpectedResourcePath("/__ids?path=TestDirectory&modifiedSince=2024-03-01T00:00:00Z&modifiedBefore=2024-04-01T00:00:00Z"), 200, "PAYLOAD", "application/octet-stream")
+	assert.Equal(t, ListFilter{
+		Path:           "TestDirectory",
+		ModifiedSince:  time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+		ModifiedBefore: time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC),
+	}, mr.idsOptions.ListFilter)
+}
+
 func TestReaderHandlerIdsFailsReturnsServiceUnavailable(t *testing.T) {
 	log := logger.NewUPPLogger("handlers_test", "Debug")
 	r := mux.NewRouter()
@@ -311,6 +368,117 @@ func TestReaderHandlerIdsFailsReturnsServiceUnavailable(t *testing.T) {
 	assertRequestAndResponseFromRouter(t, r, withExpectedResourcePath("/__ids"), 503, "{\"message\":\"Service currently unavailable\"}", ExpectedContentType)
 }
 
+func TestHandleGetAllWithFilter(t *testing.T) {
+	log := logger.NewUPPLogger("handlers_test", "Debug")
+	r := mux.NewRouter()
+	mr := &mockReader{payload: "PAYLOAD", log: log}
+	Handlers(r, WriterHandler{}, NewReaderHandler(mr, log), ExpectedResourcePath)
+	assertRequestAndResponseFromRouter(t, r, withExpectedResourcePath("/?path=TestDirectory&modifiedSince=2024-03-01T00:00:00Z"), 200, "PAYLOAD", "application/octet-stream")
+	assert.Equal(t, ListFilter{Path: "TestDirectory", ModifiedSince: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)}, mr.getAllOptions.ListFilter)
+}
+
+func TestHandleGetAllResumable(t *testing.T) {
+	log := logger.NewUPPLogger("handlers_test", "Debug")
+	r := mux.NewRouter()
+	mr := &mockReader{payload: "PAYLOAD", log: log}
+	Handlers(r, WriterHandler{}, NewReaderHandler(mr, log), ExpectedResourcePath)
+	assertRequestAndResponseFromRouter(t, r, withExpectedResourcePath("/?startAfter="+expectedUUID+"&checkpointEvery=100"), 200, "PAYLOAD", "application/octet-stream")
+	assert.Equal(t, GetAllOptions{ListFilter: ListFilter{StartAfter: expectedUUID}, CheckpointEvery: 100, StreamOptions: StreamOptions{Report: &StreamReport{items: 1}}}, mr.getAllOptions)
+
+	assertRequestAndResponseFromRouter(t, r, withExpectedResourcePath("/?checkpointEvery=-1"), 400, "", ExpectedContentType)
+	assertRequestAndResponseFromRouter(t, r, withExpectedResourcePath("/?checkpointEvery=0"), 400, "", ExpectedContentType)
+}
+
+func TestHandleGetAllFormats(t *testing.T) {
+	log := logger.NewUPPLogger("handlers_test", "Debug")
+	r := mux.NewRouter()
+	mr := &mockReader{payload: "PAYLOAD", log: log}
+	Handlers(r, WriterHandler{}, NewReaderHandler(mr, log), ExpectedResourcePath)
+	assertRequestAndResponseFromRouter(t, r, withExpectedResourcePath("/?format=envelope"), 200, "PAYLOAD", "application/x-ndjson")
+	assert.Equal(t, formatEnvelope, mr.getAllOptions.Format)
+	assertRequestAndResponseFromRouter(t, r, withExpectedResourcePath("/?format=json-array"), 200, "PAYLOAD", "application/json")
+	assert.Equal(t, formatJSONArray, mr.getAllOptions.Format)
+	assertRequestAndResponseFromRouter(t, r, withExpectedResourcePath("/?raw=true"), 200, "PAYLOAD", "application/octet-stream")
+	assert.True(t, mr.getAllOptions.Raw)
+	assertRequestAndResponseFromRouter(t, r, withExpectedResourcePath("/?format=xml"), 400, "{\"message\":\"format must be envelope or json-array\"}\n", ExpectedContentType)
+	assertRequestAndResponseFromRouter(t, r, withExpectedResourcePath("/?filter=$.type%3D%3D%22Brand%22"), 200, "PAYLOAD", "application/octet-stream")
+	assert.Len(t, mr.getAllOptions.Filter, 1)
+	assertRequestAndResponseFromRouter(t, r, withExpectedResourcePath("/?filter=type"), 400, "{\"message\":\"invalid filter \\\"type\\\": path must start with $.\"}\n", ExpectedContentType)
+}
+
+func TestHandleGetAllReportsTrailers(t *testing.T) {
+	log := logger.NewUPPLogger("handlers_test", "Debug")
+	r := mux.NewRouter()
+	mr := &mockReader{payload: "PAYLOAD", log: log}
+	Handlers(r, WriterHandler{}, NewReaderHandler(mr, log), ExpectedResourcePath)
+	rec := assertRequestAndResponseFromRouter(t, r, withExpectedResourcePath("/?failFast=true"), 200, "PAYLOAD", "application/octet-stream")
+	assert.True(t, mr.getAllOptions.FailFast)
+	trailer := rec.Result().Trailer
+	assert.Equal(t, "1", trailer.Get("X-Item-Count"))
+	assert.Equal(t, "0", trailer.Get("X-Error-Count"))
+	assert.Equal(t, "false", trailer.Get("X-Stream-Aborted"))
+}
+
+func TestHandleIdsReportsAbortedStream(t *testing.T) {
+	log := logger.NewUPPLogger("handlers_test", "Debug")
+	r := mux.NewRouter()
+	mr := &mockReader{payload: "PAYLOAD", streamError: errors.New("head failed"), log: log}
+	Handlers(r, WriterHandler{}, NewReaderHandler(mr, log), ExpectedResourcePath)
+	rec := assertRequestAndResponseFromRouter(t, r, withExpectedResourcePath("/__ids?failFast=true"), 200, "PAYLOAD", "application/octet-stream")
+	assert.True(t, mr.idsOptions.FailFast)
+	trailer := rec.Result().Trailer
+	assert.Equal(t, "1", trailer.Get("X-Item-Count"))
+	assert.Equal(t, "1", trailer.Get("X-Error-Count"))
+	assert.Equal(t, "true", trailer.Get("X-Stream-Aborted"))
+}
+
+// failingResponseWriter fails to write the body, as when the client has gone.
+type failingResponseWriter struct {
+	*httptest.ResponseRecorder
+}
+
+func (w failingResponseWriter) Write([]byte) (int, error) {
+	return 0, errors.New("client gone")
+}
+
+func TestHandleGetAllClosesStreamWhenClientGoes(t *testing.T) {
+	log := logger.NewUPPLogger("handlers_test", "Debug")
+	for _, target := range []string{"/", "/__export"} {
+		t.Run(target, func(t *testing.T) {
+			r := mux.NewRouter()
+			mr := &mockReader{payload: "PAYLOAD", written: make(chan error, 1), log: log}
+			Handlers(r, WriterHandler{}, NewReaderHandler(mr, log), ExpectedResourcePath)
+			r.ServeHTTP(failingResponseWriter{httptest.NewRecorder()}, newRequest("GET", withExpectedResourcePath(target), ""))
+			assert.ErrorIs(t, <-mr.written, io.ErrClosedPipe)
+		})
+	}
+}
+
+func TestHandleExport(t *testing.T) {
+	log := logger.NewUPPLogger("handlers_test", "Debug")
+	r := mux.NewRouter()
+	mr := &mockReader{payload: "PAYLOAD", log: log}
+	Handlers(r, WriterHandler{}, NewReaderHandler(mr, log), ExpectedResourcePath)
+
+	rec := assertRequestAndResponseFromRouter(t, r, withExpectedResourcePath("/__export?path=TestDirectory"), 200, "PAYLOAD", "application/gzip")
+	assert.Equal(t, `attachment; filename="export.tar.gz"`, rec.Header().Get("Content-Disposition"))
+	assert.Equal(t, formatTarGz, mr.getAllOptions.Format)
+	assert.Equal(t, "TestDirectory", mr.getAllOptions.Path)
+
+	rec = assertRequestAndResponseFromRouter(t, r, withExpectedResourcePath("/__export?format=zip"), 200, "PAYLOAD", "application/zip")
+	assert.Equal(t, `attachment; filename="export.zip"`, rec.Header().Get("Content-Disposition"))
+
+	assertRequestAndResponseFromRouter(t, r, withExpectedResourcePath("/__export?format=rar"), 400, "{\"message\":\"format must be tar.gz or zip\"}\n", ExpectedContentType)
+}
+
+func TestHandleGetAllWithInvalidModifiedBefore(t *testing.T) {
+	log := logger.NewUPPLogger("handlers_test", "Debug")
+	r := mux.NewRouter()
+	mr := &mockReader{payload: "PAYLOAD", log: log}
+	Handlers(r, WriterHandler{}, NewReaderHandler(mr, log), ExpectedResourcePath)
+	assertRequestAndResponseFromRouter(t, r, withExpectedResourcePath("/?modifiedBefore=yesterday"), 400, "{\"message\":\"modifiedBefore must be an RFC3339 time\"}\n", ExpectedContentType)
+}
+
 func TestHandleGetAllOK(t *testing.T) {
 	log := logger.NewUPPLogger("handlers_test", "Debug")
 	r := mux.NewRouter()
@@ -410,13 +578,20 @@ func newRequest(method, url string, body string) *http.Request {
 
 type mockReader struct {
 	sync.Mutex
-	uuid        string
-	payload     string
-	rc          io.ReadCloser
-	returnError error
-	returnCT    string
-	count       int64
-	log         *logger.UPPLogger
+	uuid          string
+	payload       string
+	rc            io.ReadCloser
+	returnError   error
+	streamError   error
+	returnCT      string
+	count         int64
+	idsOptions    IdsOptions
+	getAllOptions GetAllOptions
+	countPath     string
+	listOptions   ListOptions
+	listPage      ListPage
+	written       chan error
+	log           *logger.UPPLogger
 }
 
 func (r *mockReader) Get(uuid string, path string) (bool, io.ReadCloser, *string, error) {
@@ -437,29 +612,53 @@ func (r *mockReader) Get(uuid string, path string) (bool, io.ReadCloser, *string
 	return r.payload != "" || r.rc != nil, body, &r.returnCT, r.returnError
 }
 
-func (r *mockReader) Count() (int64, error) {
+func (r *mockReader) Count(path string) (int64, error) {
 	r.Lock()
 	defer r.Unlock()
+	r.countPath = path
 	return r.count, r.returnError
 }
 
-func (r *mockReader) processPipe() (*io.PipeReader, error) {
+func (r *mockReader) processPipe(report *StreamReport) (*io.PipeReader, error) {
 	pv, pw := io.Pipe()
 	go func(p *io.PipeWriter) {
 		if r.payload != "" {
 			p.Write([]byte(r.payload))
+			report.addItem()
+		}
+		if r.written != nil {
+			_, err := p.Write([]byte(r.payload))
+			r.written <- err
+		}
+		if r.streamError != nil {
+			report.addError()
+			p.CloseWithError(r.streamError)
+			return
 		}
 		p.Close()
 	}(pw)
 	return pv, r.returnError
 }
 
-func (r *mockReader) GetAll(path string) (*io.PipeReader, error) {
-	return r.processPipe()
+func (r *mockReader) List(opts ListOptions) (ListPage, error) {
+	r.Lock()
+	defer r.Unlock()
+	r.listOptions = opts
+	return r.listPage, r.returnError
+}
+
+func (r *mockReader) GetAll(opts GetAllOptions) (*io.PipeReader, error) {
+	r.Lock()
+	r.getAllOptions = opts
+	r.Unlock()
+	return r.processPipe(opts.Report)
 }
 
-func (r *mockReader) Ids() (*io.PipeReader, error) {
-	return r.processPipe()
+func (r *mockReader) Ids(opts IdsOptions) (*io.PipeReader, error) {
+	r.Lock()
+	r.idsOptions = opts
+	r.Unlock()
+	return r.processPipe(opts.Report)
 }
 
 type mockWriter struct {
@@ -1,6 +1,7 @@
 package service
 
 import (
+	"fmt"
 	"net/http"
 	"reflect"
 	"time"
@@ -19,14 +20,42 @@ type HealthCheck struct {
 	appName       string
 	appSystemCode string
 	bucketName    string
+	resources     []resourceHealth
+	mirrors       []mirrorHealth
+	producers     []producerHealth
 	log           *logger.UPPLogger
 }
 
+type mirrorLagReporter interface {
+	Lag() (int, time.Duration)
+}
+
+type mirrorHealth struct {
+	name      string
+	mirror    mirrorLagReporter
+	tolerance time.Duration
+}
+
+type producerHealth struct {
+	name     string
+	producer messageProducerHealthcheck
+}
+
+type resourceHealth struct {
+	name       string
+	bucketName string
+	consumer   messageConsumerHealthcheck
+}
+
 type messageConsumerHealthcheck interface {
 	ConnectivityCheck() error
 	MonitorCheck() error
 }
 
+type messageProducerHealthcheck interface {
+	ConnectivityCheck() error
+}
+
 func NewHealthCheck(c messageConsumerHealthcheck, s3API s3iface.S3API, appName string, appSystemCode string, bucketName string, log *logger.UPPLogger) *HealthCheck {
 	return &HealthCheck{
 		s3API:         s3API,
@@ -38,11 +67,48 @@ func NewHealthCheck(c messageConsumerHealthcheck, s3API s3iface.S3API, appName s
 	}
 }
 
+// NewResourcesHealthCheck creates a HealthCheck without a default bucket, checks are added per resource with AddResource.
+func NewResourcesHealthCheck(s3API s3iface.S3API, appName string, appSystemCode string, log *logger.UPPLogger) *HealthCheck {
+	return &HealthCheck{
+		s3API:         s3API,
+		appName:       appName,
+		appSystemCode: appSystemCode,
+		log:           log,
+	}
+}
+
+// AddResource registers the bucket and optional consumer of a resource so they are reported by the healthchecks.
+func (h *HealthCheck) AddResource(name string, bucketName string, c messageConsumerHealthcheck) {
+	h.resources = append(h.resources, resourceHealth{name: name, bucketName: bucketName, consumer: c})
+}
+
+// AddMirror reports the replication lag of a mirrored resource, failing when pending operations are older than the tolerance.
+func (h *HealthCheck) AddMirror(name string, m mirrorLagReporter, tolerance time.Duration) {
+	h.mirrors = append(h.mirrors, mirrorHealth{name: name, mirror: m, tolerance: tolerance})
+}
+
+// AddProducer reports the connectivity of the Kafka producer sending the change events of a resource.
+func (h *HealthCheck) AddProducer(name string, p messageProducerHealthcheck) {
+	h.producers = append(h.producers, producerHealth{name: name, producer: p})
+}
+
 func (h *HealthCheck) Health() func(w http.ResponseWriter, r *http.Request) {
-	checks := []fthealth.Check{h.accessS3bucketCheck()}
-	if !reflect.ValueOf(h.consumer).IsNil() {
+	var checks []fthealth.Check
+	if h.bucketName != "" {
+		checks = append(checks, h.accessS3bucketCheck())
+	}
+	if !isNilConsumer(h.consumer) {
 		checks = append(checks, h.consumerHealthCheck(), h.consumerLagCheck())
 	}
+	for _, rh := range h.resources {
+		checks = append(checks, h.resourceChecks(rh)...)
+	}
+	for _, mh := range h.mirrors {
+		checks = append(checks, h.mirrorLagCheck(mh))
+	}
+	for _, ph := range h.producers {
+		checks = append(checks, h.producerHealthCheck(ph))
+	}
 	hc := fthealth.TimedHealthCheck{
 		HealthCheck: fthealth.HealthCheck{
 			SystemCode:  h.appSystemCode,
@@ -67,8 +133,12 @@ func (h *HealthCheck) accessS3bucketCheck() fthealth.Check {
 }
 
 func (h *HealthCheck) s3HealthCheck() (string, error) {
+	return h.bucketHealthCheck(h.bucketName)
+}
+
+func (h *HealthCheck) bucketHealthCheck(bucketName string) (string, error) {
 	params := &s3.HeadBucketInput{
-		Bucket: aws.String(h.bucketName), // Required
+		Bucket: aws.String(bucketName), // Required
 	}
 	_, err := h.s3API.HeadBucket(params)
 	if err != nil {
@@ -91,10 +161,7 @@ func (h *HealthCheck) consumerHealthCheck() fthealth.Check {
 }
 
 func (h *HealthCheck) consumerConnectivityChecker() (string, error) {
-	if err := h.consumer.ConnectivityCheck(); err != nil {
-		return "", err
-	}
-	return "OK", nil
+	return connectivityChecker(h.consumer)()
 }
 
 func (h *HealthCheck) consumerLagCheck() fthealth.Check {
@@ -110,35 +177,150 @@ func (h *HealthCheck) consumerLagCheck() fthealth.Check {
 }
 
 func (h *HealthCheck) consumerMonitorChecker() (string, error) {
-	if err := h.consumer.MonitorCheck(); err != nil {
-		return "", err
+	return monitorChecker(h.consumer)()
+}
+
+func (h *HealthCheck) resourceChecks(rh resourceHealth) []fthealth.Check {
+	checks := []fthealth.Check{
+		{
+			ID:               "s3-bucket-check-" + rh.name,
+			BusinessImpact:   fmt.Sprintf("Unable to access S3 bucket for /%s", rh.name),
+			Name:             fmt.Sprintf("S3 Bucket check for /%s", rh.name),
+			PanicGuide:       "https://runbooks.ftops.tech/" + h.appSystemCode,
+			Severity:         3,
+			TechnicalSummary: fmt.Sprintf("Can not access S3 bucket %s.", rh.bucketName),
+			Checker: func() (string, error) {
+				return h.bucketHealthCheck(rh.bucketName)
+			},
+		},
 	}
-	return "OK", nil
+	if isNilConsumer(rh.consumer) {
+		return checks
+	}
+
+	return append(checks,
+		fthealth.Check{
+			ID:               "kafka-connectivity-" + rh.name,
+			Name:             fmt.Sprintf("Kafka Connectivity to MSK for /%s", rh.name),
+			Severity:         2,
+			BusinessImpact:   fmt.Sprintf("Cannot read content and store to S3 for /%s", rh.name),
+			TechnicalSummary: "Kafka consumer is not reachable/healthy",
+			PanicGuide:       "https://runbooks.ftops.tech/" + h.appSystemCode,
+			Checker:          connectivityChecker(rh.consumer),
+		},
+		fthealth.Check{
+			ID:               "kafka-consumer-lagcheck-" + rh.name,
+			Name:             fmt.Sprintf("Kafka consumer lagging for /%s", rh.name),
+			Severity:         3,
+			BusinessImpact:   fmt.Sprintf("Reading messages is delayed for /%s", rh.name),
+			TechnicalSummary: "Messages awaiting handling exceed the configured lag tolerance. Check if Kafka consumer is stuck.",
+			PanicGuide:       "https://runbooks.ftops.tech/" + h.appSystemCode,
+			Checker:          monitorChecker(rh.consumer),
+		},
+	)
 }
 
-func (h *HealthCheck) GTG() gtg.Status {
-	s3Check := func() gtg.Status {
-		if _, err := h.s3HealthCheck(); err != nil {
-			h.log.Info("Healthcheck failed, gtg is bad.")
-			return gtg.Status{GoodToGo: false, Message: "Head request to S3 failed"}
+func (h *HealthCheck) mirrorLagCheck(mh mirrorHealth) fthealth.Check {
+	suffix := ""
+	if mh.name != "" {
+		suffix = " for /" + mh.name
+	}
+	return fthealth.Check{
+		ID:               "s3-mirror-lag-" + mh.name,
+		Name:             "S3 mirror replication lag" + suffix,
+		Severity:         3,
+		BusinessImpact:   "Content in the mirror bucket is out of date" + suffix,
+		TechnicalSummary: fmt.Sprintf("Writes to the mirror bucket have been pending for longer than %v. Check the mirror bucket is accessible.", mh.tolerance),
+		PanicGuide:       "https://runbooks.ftops.tech/" + h.appSystemCode,
+		Checker: func() (string, error) {
+			pending, oldest := mh.mirror.Lag()
+			if oldest > mh.tolerance {
+				return "", fmt.Errorf("%d mirror operations pending, oldest for %v", pending, oldest.Truncate(time.Second))
+			}
+			return fmt.Sprintf("%d mirror operations pending", pending), nil
+		},
+	}
+}
+
+func (h *HealthCheck) producerHealthCheck(ph producerHealth) fthealth.Check {
+	suffix := ""
+	if ph.name != "" {
+		suffix = " for /" + ph.name
+	}
+	return fthealth.Check{
+		ID:               "kafka-producer-connectivity-" + ph.name,
+		Name:             "Kafka producer connectivity to MSK" + suffix,
+		Severity:         3,
+		BusinessImpact:   "Changes are not announced to downstream services" + suffix,
+		TechnicalSummary: "Kafka producer is not reachable/healthy, change events are not being sent",
+		PanicGuide:       "https://runbooks.ftops.tech/" + h.appSystemCode,
+		Checker: func() (string, error) {
+			if err := ph.producer.ConnectivityCheck(); err != nil {
+				return "", err
+			}
+			return "OK", nil
+		},
+	}
+}
+
+func connectivityChecker(c messageConsumerHealthcheck) func() (string, error) {
+	return func() (string, error) {
+		if err := c.ConnectivityCheck(); err != nil {
+			return "", err
 		}
-		return gtg.Status{GoodToGo: true, Message: "OK"}
+		return "OK", nil
+	}
+}
+
+func monitorChecker(c messageConsumerHealthcheck) func() (string, error) {
+	return func() (string, error) {
+		if err := c.MonitorCheck(); err != nil {
+			return "", err
+		}
+		return "OK", nil
 	}
+}
 
-	sc := []gtg.StatusChecker{
-		s3Check,
+func (h *HealthCheck) GTG() gtg.Status {
+	var sc []gtg.StatusChecker
+	if h.bucketName != "" {
+		sc = append(sc, h.s3GTGCheck(h.bucketName))
 	}
 
-	if !reflect.ValueOf(h.consumer).IsNil() {
+	if !isNilConsumer(h.consumer) {
 		consumerCheck := func() gtg.Status {
 			return gtgCheck(h.consumerConnectivityChecker)
 		}
 		sc = append(sc, consumerCheck)
 	}
 
+	for _, rh := range h.resources {
+		sc = append(sc, h.s3GTGCheck(rh.bucketName))
+		if !isNilConsumer(rh.consumer) {
+			checker := connectivityChecker(rh.consumer)
+			sc = append(sc, func() gtg.Status {
+				return gtgCheck(checker)
+			})
+		}
+	}
+
 	return gtg.FailFastParallelCheck(sc)()
 }
 
+func (h *HealthCheck) s3GTGCheck(bucketName string) gtg.StatusChecker {
+	return func() gtg.Status {
+		if _, err := h.bucketHealthCheck(bucketName); err != nil {
+			h.log.Info("Healthcheck failed, gtg is bad.")
+			return gtg.Status{GoodToGo: false, Message: "Head request to S3 failed"}
+		}
+		return gtg.Status{GoodToGo: true, Message: "OK"}
+	}
+}
+
+func isNilConsumer(c messageConsumerHealthcheck) bool {
+	return c == nil || reflect.ValueOf(c).IsNil()
+}
+
 func gtgCheck(handler func() (string, error)) gtg.Status {
 	if _, err := handler(); err != nil {
 		return gtg.Status{GoodToGo: false, Message: err.Error()}
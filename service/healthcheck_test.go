@@ -3,6 +3,7 @@ package service
 import (
 	"errors"
 	"testing"
+	"time"
 
 	"net/http/httptest"
 
@@ -151,3 +152,75 @@ func TestNilConsumer(t *testing.T) {
 	assert.Equal(t, 200, w.Code, "It should return HTTP 200 OK")
 	assert.Contains(t, w.Body.String(), `"name":"S3 Bucket check","ok":true`, "S3 bucket check should be happy")
 }
+
+func TestResourcesHealthCheck(t *testing.T) {
+	log := logger.NewUPPLogger("test-healthcheck", "INFO")
+	s := &mockS3Client{log: log}
+	hc := NewResourcesHealthCheck(s, "generic-rw-s3", "generic-rw-s3", log)
+	hc.AddResource("concepts", "conceptsBucket", &mockConsumerInstance{isConnectionHealthy: true, isNotLagging: false})
+	hc.AddResource("generic", "genericBucket", nil)
+
+	req := httptest.NewRequest("GET", "http://example.com/__health", nil)
+	w := httptest.NewRecorder()
+
+	hc.Health()(w, req)
+
+	assert.Equal(t, 200, w.Code, "It should return HTTP 200 OK")
+	assert.Contains(t, w.Body.String(), `"name":"S3 Bucket check for /concepts","ok":true`)
+	assert.Contains(t, w.Body.String(), `"name":"Kafka Connectivity to MSK for /concepts","ok":true`)
+	assert.Contains(t, w.Body.String(), `"name":"Kafka consumer lagging for /concepts","ok":false`)
+	assert.Contains(t, w.Body.String(), `"name":"S3 Bucket check for /generic","ok":true`)
+	assert.NotContains(t, w.Body.String(), `for /generic","ok":false`)
+	assert.NotContains(t, w.Body.String(), `"name":"S3 Bucket check","ok"`)
+}
+
+func TestResourcesGTG(t *testing.T) {
+	log := logger.NewUPPLogger("test-healthcheck", "INFO")
+	s := &mockS3Client{log: log}
+	hc := NewResourcesHealthCheck(s, "generic-rw-s3", "generic-rw-s3", log)
+	hc.AddResource("concepts", "conceptsBucket", &mockConsumerInstance{isConnectionHealthy: true, isNotLagging: true})
+	hc.AddResource("generic", "genericBucket", &mockConsumerInstance{isConnectionHealthy: false})
+
+	status := hc.GTG()
+	assert.False(t, status.GoodToGo)
+	assert.Equal(t, "error connecting to the queue", status.Message)
+}
+
+type mockMirror struct {
+	pending int
+	oldest  time.Duration
+}
+
+func (m *mockMirror) Lag() (int, time.Duration) {
+	return m.pending, m.oldest
+}
+
+func TestMirrorLagHealthCheck(t *testing.T) {
+	hc := initHealthCheck(true, true, true)
+	hc.AddMirror("", &mockMirror{pending: 2, oldest: time.Second}, time.Minute)
+	hc.AddMirror("concepts", &mockMirror{pending: 20, oldest: time.Hour}, time.Minute)
+
+	req := httptest.NewRequest("GET", "http://example.com/__health", nil)
+	w := httptest.NewRecorder()
+
+	hc.Health()(w, req)
+
+	assert.Equal(t, 200, w.Code, "It should return HTTP 200 OK")
+	assert.Contains(t, w.Body.String(), `"name":"S3 mirror replication lag","ok":true`)
+	assert.Contains(t, w.Body.String(), `"name":"S3 mirror replication lag for /concepts","ok":false`)
+}
+
+func TestProducerHealthCheck(t *testing.T) {
+	hc := initHealthCheck(true, true, true)
+	hc.AddProducer("", &mockConsumerInstance{isConnectionHealthy: true})
+	hc.AddProducer("concepts", &mockConsumerInstance{})
+
+	req := httptest.NewRequest("GET", "http://example.com/__health", nil)
+	w := httptest.NewRecorder()
+
+	hc.Health()(w, req)
+
+	assert.Equal(t, 200, w.Code, "It should return HTTP 200 OK")
+	assert.Contains(t, w.Body.String(), `"name":"Kafka producer connectivity to MSK","ok":true`)
+	assert.Contains(t, w.Body.String(), `"name":"Kafka producer connectivity to MSK for /concepts","ok":false`)
+}
@@ -0,0 +1,380 @@
+package service
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	pathpkg "path"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/Financial-Times/go-logger/v2"
+	transactionid "github.com/Financial-Times/transactionid-utils-go"
+)
+
+const (
+	formatNDJSON = "ndjson"
+	formatTar    = "tar"
+)
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+// envelopeFields are the fields of an envelope record, telling them apart from payloads streamed as they are.
+var envelopeFields = map[string]bool{
+	"uuid": true, "path": true, "contentType": true, "lastModified": true, "body": true, "bodyEncoding": true, "error": true,
+}
+
+// ImportSummary reports the outcome of an import. Skipped items could not be imported, such as lines without a UUID.
+type ImportSummary struct {
+	Read      int64 `json:"read"`
+	Created   int64 `json:"created"`
+	Updated   int64 `json:"updated"`
+	Unchanged int64 `json:"unchanged"`
+	Skipped   int64 `json:"skipped"`
+	Failed    int64 `json:"failed"`
+}
+
+// ImportResult is the response to an import, with the error which stopped it early, if any.
+type ImportResult struct {
+	Summary ImportSummary `json:"summary"`
+	Error   string        `json:"error,omitempty"`
+}
+
+type importItem struct {
+	uuid        string
+	path        string
+	contentType string
+	body        []byte
+}
+
+// Importer writes the items of a stream or archive produced by an export, in parallel.
+type Importer struct {
+	writer  Writer
+	workers int
+	log     *logger.UPPLogger
+}
+
+func NewImporter(writer Writer, workers int, log *logger.UPPLogger) *Importer {
+	if workers <= 0 {
+		workers = defaultWorkers
+	}
+	return &Importer{writer: writer, workers: workers, log: log}
+}
+
+// ImportFormat returns the format of an import file from its name, defaulting to NDJSON.
+func ImportFormat(name string) string {
+	switch {
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return formatTarGz
+	case strings.HasSuffix(name, ".tar"):
+		return formatTar
+	case strings.HasSuffix(name, ".zip"):
+		return formatZip
+	default:
+		return formatNDJSON
+	}
+}
+
+func validImportFormat(format string) bool {
+	return format == formatNDJSON || format == formatTar || isArchive(format)
+}
+
+// Import reads the items of src in the given format and writes them under path. Items in an NDJSON stream of envelopes
+// are written under the path they were exported from when no path is given.
+func (im *Importer) Import(src io.Reader, format string, path string, tid string) (ImportSummary, error) {
+	var summary ImportSummary
+	if !validImportFormat(format) {
+		return summary, fmt.Errorf("format must be %s, %s, %s or %s", formatNDJSON, formatTar, formatTarGz, formatZip)
+	}
+
+	items := make(chan importItem, im.workers)
+	var wg sync.WaitGroup
+	for w := 0; w < im.workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range items {
+				im.write(item, tid, &summary)
+			}
+		}()
+	}
+
+	err := im.read(src, format, path, items, &summary)
+	close(items)
+	wg.Wait()
+	return summary, err
+}
+
+func (im *Importer) write(item importItem, tid string, summary *ImportSummary) {
+	status, err := im.writer.Write(item.uuid, item.path, &item.body, item.contentType, tid, false)
+	switch {
+	case err != nil || status == INTERNAL_ERROR || status == SERVICE_UNAVAILABLE:
+		atomic.AddInt64(&summary.Failed, 1)
+		im.log.WithError(err).WithTransactionID(tid).WithUUID(item.uuid).Error("Failed to import item")
+	case status == CREATED:
+		atomic.AddInt64(&summary.Created, 1)
+	case status == UPDATED:
+		atomic.AddInt64(&summary.Updated, 1)
+	default:
+		atomic.AddInt64(&summary.Unchanged, 1)
+	}
+}
+
+func (im *Importer) read(src io.Reader, format string, path string, items chan<- importItem, summary *ImportSummary) error {
+	send := func(item importItem) {
+		atomic.AddInt64(&summary.Read, 1)
+		if !uuidPattern.MatchString(item.uuid) {
+			atomic.AddInt64(&summary.Skipped, 1)
+			im.log.Warnf("Skipping imported item with invalid UUID %q", item.uuid)
+			return
+		}
+		items <- item
+	}
+
+	switch format {
+	case formatTar:
+		return readTar(src, path, send)
+	case formatTarGz:
+		gz, err := gzip.NewReader(src)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		return readTar(gz, path, send)
+	case formatZip:
+		return readZip(src, path, send)
+	default:
+		return im.readNDJSON(src, path, send, summary)
+	}
+}
+
+// readNDJSON reads the lines streamed by GET /, which are payloads holding their own uuid or envelope records.
+// Checkpoint lines are ignored and error records are skipped.
+func (im *Importer) readNDJSON(src io.Reader, path string, send func(importItem), summary *ImportSummary) error {
+	br := bufio.NewReader(src)
+	for {
+		line, err := br.ReadBytes('\n')
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			item, ok, lineErr := ndjsonItem(line, path)
+			switch {
+			case lineErr != nil:
+				atomic.AddInt64(&summary.Read, 1)
+				atomic.AddInt64(&summary.Skipped, 1)
+				im.log.WithError(lineErr).WithUUID(item.uuid).Warn("Skipping imported line")
+			case ok:
+				send(item)
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// ndjsonItem reads the item on a line, returning false for checkpoint lines.
+func ndjsonItem(line []byte, path string) (importItem, bool, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(line, &fields); err != nil {
+		return importItem{}, false, errors.New("line is not a JSON object")
+	}
+	if _, ok := fields["checkpoint"]; ok && len(fields) == 1 {
+		return importItem{}, false, nil
+	}
+
+	var e envelope
+	json.Unmarshal(line, &e)
+	if !isEnvelope(fields) {
+		return importItem{uuid: e.UUID, path: path, contentType: "application/json", body: line}, true, nil
+	}
+	item := importItem{uuid: e.UUID, path: path, contentType: e.ContentType, body: e.Body}
+	if e.Error != "" || len(e.Body) == 0 {
+		return item, false, errors.New("item could not be exported")
+	}
+	if item.path == "" {
+		item.path = e.Path
+	}
+	if e.BodyEncoding == "base64" {
+		var encoded string
+		json.Unmarshal(e.Body, &encoded)
+		b, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return item, false, fmt.Errorf("invalid base64 body: %w", err)
+		}
+		item.body = b
+	}
+	return item, true, nil
+}
+
+func isEnvelope(fields map[string]json.RawMessage) bool {
+	_, hasBody := fields["body"]
+	_, hasError := fields["error"]
+	if !hasBody && !hasError {
+		return false
+	}
+	for f := range fields {
+		if !envelopeFields[f] {
+			return false
+		}
+	}
+	return true
+}
+
+func readTar(src io.Reader, path string, send func(importItem)) error {
+	tr := tar.NewReader(src)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if h.Typeflag != tar.TypeReg {
+			continue
+		}
+		body, err := io.ReadAll(tr)
+		if err != nil {
+			return err
+		}
+		send(archiveItem(h.Name, path, body))
+	}
+}
+
+// readZip reads a zip archive, which is spooled to a temporary file unless it is already a regular file.
+func readZip(src io.Reader, path string, send func(importItem)) error {
+	f, info, err := zipFile(src)
+	if err != nil {
+		return err
+	}
+	if f != src {
+		defer os.Remove(f.Name())
+		defer f.Close()
+	}
+
+	zr, err := zip.NewReader(f, info.Size())
+	if err != nil {
+		return err
+	}
+	for _, zf := range zr.File {
+		if zf.FileInfo().IsDir() {
+			continue
+		}
+		rc, err := zf.Open()
+		if err != nil {
+			return err
+		}
+		body, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+		send(archiveItem(zf.Name, path, body))
+	}
+	return nil
+}
+
+func zipFile(src io.Reader) (*os.File, os.FileInfo, error) {
+	if f, ok := src.(*os.File); ok {
+		if info, err := f.Stat(); err == nil && info.Mode().IsRegular() {
+			return f, info, nil
+		}
+	}
+
+	tmp, err := os.CreateTemp("", "import-*.zip")
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := func() (os.FileInfo, error) {
+		if _, err := io.Copy(tmp, src); err != nil {
+			return nil, err
+		}
+		return tmp.Stat()
+	}()
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, nil, err
+	}
+	return tmp, info, nil
+}
+
+// archiveItem reads the UUID and content type of an item from the name of its archive entry.
+func archiveItem(name string, path string, body []byte) importItem {
+	base := pathpkg.Base(name)
+	ext := pathpkg.Ext(base)
+	return importItem{uuid: strings.TrimSuffix(base, ext), path: path, contentType: archiveContentType(ext), body: body}
+}
+
+// maxImportZipBytes limits the zip archives imported over HTTP, which are spooled to a temporary file to be read.
+const maxImportZipBytes = 1 << 30
+
+type ImportHandler struct {
+	importer    *Importer
+	maxZipBytes int64
+	log         *logger.UPPLogger
+}
+
+func NewImportHandler(importer *Importer, log *logger.UPPLogger) ImportHandler {
+	return ImportHandler{importer: importer, maxZipBytes: maxImportZipBytes, log: log}
+}
+
+var importContentTypes = map[string]string{
+	"application/x-ndjson": formatNDJSON,
+	"application/x-tar":    formatTar,
+	"application/gzip":     formatTarGz,
+	"application/x-gzip":   formatTarGz,
+	"application/zip":      formatZip,
+}
+
+// HandleImport imports the request body, in the format given by the format parameter or its content type.
+func (ih *ImportHandler) HandleImport(rw http.ResponseWriter, r *http.Request) {
+	tid := transactionid.GetTransactionIDFromRequest(r)
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = importContentTypes[r.Header.Get("Content-Type")]
+	}
+	if format == "" {
+		format = formatNDJSON
+	}
+	if !validImportFormat(format) {
+		respondBadRequest(fmt.Errorf("format must be %s, %s, %s or %s", formatNDJSON, formatTar, formatTarGz, formatZip), rw)
+		return
+	}
+
+	body := r.Body
+	if format == formatZip {
+		body = http.MaxBytesReader(rw, r.Body, ih.maxZipBytes)
+	}
+	summary, err := ih.importer.Import(body, format, r.URL.Query().Get("path"), tid)
+	result := ImportResult{Summary: summary}
+	status := http.StatusOK
+	if err != nil {
+		ih.log.WithError(err).WithTransactionID(tid).Error("Import stopped early")
+		result.Error = err.Error()
+		status = http.StatusBadRequest
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			status = http.StatusRequestEntityTooLarge
+		}
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	if err := json.NewEncoder(rw).Encode(result); err != nil {
+		ih.log.WithError(err).WithTransactionID(tid).Error("Error writing import summary")
+	}
+}
@@ -0,0 +1,177 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Financial-Times/go-logger/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	importUUID1 = "123e4567-e89b-12d3-a456-426655440001"
+	importUUID2 = "123e4567-e89b-12d3-a456-426655440002"
+	importUUID3 = "123e4567-e89b-12d3-a456-426655440003"
+)
+
+type importedItem struct {
+	path        string
+	contentType string
+	body        string
+}
+
+type importWriter struct {
+	sync.Mutex
+	items    map[string]importedItem
+	statuses map[string]Status
+	err      error
+}
+
+func (w *importWriter) Write(uuid string, path string, b *[]byte, ct string, tid string, ignoreHash bool) (Status, error) {
+	w.Lock()
+	defer w.Unlock()
+	if w.items == nil {
+		w.items = map[string]importedItem{}
+	}
+	w.items[uuid] = importedItem{path: path, contentType: ct, body: string(*b)}
+	if w.err != nil {
+		return INTERNAL_ERROR, w.err
+	}
+	if s, ok := w.statuses[uuid]; ok {
+		return s, nil
+	}
+	return CREATED, nil
+}
+
+func (w *importWriter) Delete(uuid string, path string, tid string) error {
+	return nil
+}
+
+func TestImportNDJSON(t *testing.T) {
+	w := &importWriter{}
+	im := NewImporter(w, 3, logger.NewUPPLogger("import_test", "Debug"))
+	src := strings.Join([]string{
+		`{"uuid":"` + importUUID1 + `","title":"a"}`,
+		`{"uuid":"` + importUUID2 + `","path":"dir","contentType":"application/vnd+json","body":{"x":1}}`,
+		`{"uuid":"` + importUUID3 + `","contentType":"text/plain","body":"bm90IGpzb24=","bodyEncoding":"base64"}`,
+		`{"checkpoint":"` + importUUID3 + `"}`,
+		`{"uuid":"123e4567-e89b-12d3-a456-426655440004","error":"item could not be read"}`,
+		`not json`,
+		``,
+		`{"title":"no uuid"}`,
+	}, "\n")
+
+	summary, err := im.Import(strings.NewReader(src), formatNDJSON, "", "tid_import")
+	assert.NoError(t, err)
+	assert.Equal(t, ImportSummary{Read: 6, Created: 3, Skipped: 3}, summary)
+	assert.Equal(t, map[string]importedItem{
+		importUUID1: {contentType: "application/json", body: `{"uuid":"` + importUUID1 + `","title":"a"}`},
+		importUUID2: {path: "dir", contentType: "application/vnd+json", body: `{"x":1}`},
+		importUUID3: {contentType: "text/plain", body: "not json"},
+	}, w.items)
+}
+
+func TestImportNDJSONWithPath(t *testing.T) {
+	w := &importWriter{}
+	im := NewImporter(w, 1, logger.NewUPPLogger("import_test", "Debug"))
+	src := `{"uuid":"` + importUUID1 + `","path":"dir","body":{"x":1}}`
+
+	_, err := im.Import(strings.NewReader(src), formatNDJSON, "other", "tid_import")
+	assert.NoError(t, err)
+	assert.Equal(t, "other", w.items[importUUID1].path)
+}
+
+func TestImportArchives(t *testing.T) {
+	for _, format := range []string{formatTarGz, formatZip} {
+		t.Run(format, func(t *testing.T) {
+			var b bytes.Buffer
+			a := newArchive(format, &b)
+			assert.NoError(t, a.add(importUUID1+".json", time.Now(), []byte(`{"x":1}`)))
+			assert.NoError(t, a.add("export/"+importUUID2+".txt", time.Now(), []byte("text")))
+			assert.NoError(t, a.add("README", time.Now(), []byte("not an item")))
+			assert.NoError(t, a.Close())
+
+			w := &importWriter{}
+			im := NewImporter(w, 2, logger.NewUPPLogger("import_test", "Debug"))
+			summary, err := im.Import(&b, format, "dir", "tid_import")
+			assert.NoError(t, err)
+			assert.Equal(t, ImportSummary{Read: 3, Created: 2, Skipped: 1}, summary)
+			assert.Equal(t, map[string]importedItem{
+				importUUID1: {path: "dir", contentType: "application/json", body: `{"x":1}`},
+				importUUID2: {path: "dir", contentType: "text/plain", body: "text"},
+			}, w.items)
+		})
+	}
+}
+
+func TestImportCountsWriteStatuses(t *testing.T) {
+	w := &importWriter{statuses: map[string]Status{importUUID1: UPDATED, importUUID2: UNCHANGED, importUUID3: SERVICE_UNAVAILABLE}}
+	im := NewImporter(w, 2, logger.NewUPPLogger("import_test", "Debug"))
+	src := `{"uuid":"` + importUUID1 + `"}` + "\n" + `{"uuid":"` + importUUID2 + `"}` + "\n" + `{"uuid":"` + importUUID3 + `"}`
+
+	summary, err := im.Import(strings.NewReader(src), formatNDJSON, "", "tid_import")
+	assert.NoError(t, err)
+	assert.Equal(t, ImportSummary{Read: 3, Updated: 1, Unchanged: 1, Failed: 1}, summary)
+}
+
+func TestImportWriteErrors(t *testing.T) {
+	w := &importWriter{err: errors.New("write failed")}
+	im := NewImporter(w, 2, logger.NewUPPLogger("import_test", "Debug"))
+
+	summary, err := im.Import(strings.NewReader(`{"uuid":"`+importUUID1+`"}`), formatNDJSON, "", "tid_import")
+	assert.NoError(t, err)
+	assert.Equal(t, ImportSummary{Read: 1, Failed: 1}, summary)
+}
+
+func TestImportFormat(t *testing.T) {
+	assert.Equal(t, formatTarGz, ImportFormat("export.tar.gz"))
+	assert.Equal(t, formatTarGz, ImportFormat("export.tgz"))
+	assert.Equal(t, formatTar, ImportFormat("export.tar"))
+	assert.Equal(t, formatZip, ImportFormat("export.zip"))
+	assert.Equal(t, formatNDJSON, ImportFormat("export.ndjson"))
+	assert.Equal(t, formatNDJSON, ImportFormat("-"))
+}
+
+func TestHandleImport(t *testing.T) {
+	log := logger.NewUPPLogger("import_test", "Debug")
+	tests := []struct {
+		name         string
+		query        string
+		contentType  string
+		body         string
+		expectedCode int
+		expected     ImportResult
+	}{
+		{name: "ndjson", body: `{"uuid":"` + importUUID1 + `"}`, expectedCode: http.StatusOK, expected: ImportResult{Summary: ImportSummary{Read: 1, Created: 1}}},
+		{name: "invalid archive", contentType: "application/gzip", body: "not a gzip archive", expectedCode: http.StatusBadRequest, expected: ImportResult{Error: "gzip: invalid header"}},
+		{name: "unknown format", query: "?format=rar", expectedCode: http.StatusBadRequest},
+		{name: "zip too large", contentType: "application/zip", body: strings.Repeat("z", 65), expectedCode: http.StatusRequestEntityTooLarge, expected: ImportResult{Error: "http: request body too large"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ih := NewImportHandler(NewImporter(&importWriter{}, 1, log), log)
+			ih.maxZipBytes = 64
+			req := httptest.NewRequest(http.MethodPost, "/__import"+test.query, strings.NewReader(test.body))
+			req.Header.Set("Content-Type", test.contentType)
+			rec := httptest.NewRecorder()
+			ih.HandleImport(rec, req)
+
+			assert.Equal(t, test.expectedCode, rec.Code)
+			assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+			if test.name == "unknown format" {
+				return
+			}
+			var result ImportResult
+			assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &result))
+			assert.Equal(t, test.expected, result)
+		})
+	}
+}
@@ -0,0 +1,488 @@
+package service
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/Financial-Times/go-logger/v2"
+	transactionid "github.com/Financial-Times/transactionid-utils-go"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+const (
+	indexPrefix = "__index/"
+	// maxIndexValueKeyLength keeps index keys within the 1024 bytes S3 allows, longer values are keyed by their hash.
+	maxIndexValueKeyLength = 512
+)
+
+// valueIndex maps values to the UUIDs of the items which have them. It is stored in the items' bucket under a reserved
+// root prefix, which listings skip. For each name there is an empty object per value and UUID,
+// <root><scope>/<name>/v/<value>/<uuid>, and a record of the values of each UUID, <root><scope>/<name>/k/<uuid>,
+// used to remove the values an item no longer has. The scope is the items' key prefix, the name may be empty.
+type valueIndex struct {
+	svc          s3iface.S3API
+	bucketName   string
+	bucketPrefix string
+	root         string
+	// records serialises the updates of each record, so concurrent writes of an item cannot leave values of its
+	// previous payloads behind. Updates made by other instances are not serialised, rebuilding the index corrects them.
+	records *keyLocks
+}
+
+func newValueIndex(svc s3iface.S3API, bucketName string, bucketPrefix string, root string) valueIndex {
+	return valueIndex{svc: svc, bucketName: bucketName, bucketPrefix: bucketPrefix, root: root, records: &keyLocks{}}
+}
+
+// reservedPrefix returns the prefix of the reserved objects kept under root for the items stored under a path.
+func reservedPrefix(root string, bucketPrefix string, path string) string {
+	scope := strings.Trim(getKey(bucketPrefix, path, ""), "/")
+	if scope == "" {
+		return root
+	}
+	return root + scope + "/"
+}
+
+// scopePrefix returns the prefix of the index of the items stored under the given path.
+func (vi *valueIndex) scopePrefix(path string) string {
+	return reservedPrefix(vi.root, vi.bucketPrefix, path)
+}
+
+func (vi *valueIndex) namePrefix(path string, name string) string {
+	if name == "" {
+		return vi.scopePrefix(path)
+	}
+	return vi.scopePrefix(path) + name + "/"
+}
+
+func (vi *valueIndex) valuesPrefix(path string, name string, value string) string {
+	return vi.namePrefix(path, name) + "v/" + indexValueKey(value) + "/"
+}
+
+func (vi *valueIndex) recordKey(path string, name string, uuid string) string {
+	return vi.namePrefix(path, name) + "k/" + uuid
+}
+
+func indexValueKey(value string) string {
+	if key := url.PathEscape(value); len(key) <= maxIndexValueKeyLength {
+		return key
+	}
+	h := sha256.Sum256([]byte(value))
+	return "sha256-" + hex.EncodeToString(h[:])
+}
+
+// set replaces the values indexed for an item, one update of its record at a time.
+func (vi *valueIndex) set(uuid string, path string, name string, values []string) error {
+	defer vi.records.lock(vi.recordKey(path, name, uuid))()
+
+	old, err := vi.record(path, name, uuid)
+	if err != nil {
+		return err
+	}
+
+	current := map[string]bool{}
+	for _, v := range values {
+		current[v] = true
+	}
+	previous := map[string]bool{}
+	for _, v := range old {
+		previous[v] = true
+		if !current[v] {
+			if err := vi.delete(vi.valuesPrefix(path, name, v) + uuid); err != nil {
+				return err
+			}
+		}
+	}
+	for _, v := range values {
+		if !previous[v] {
+			if err := vi.put(vi.valuesPrefix(path, name, v)+uuid, nil); err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(values) == 0 {
+		if len(old) == 0 {
+			return nil
+		}
+		return vi.delete(vi.recordKey(path, name, uuid))
+	}
+	if slices.Equal(old, values) {
+		return nil
+	}
+	b, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+	return vi.put(vi.recordKey(path, name, uuid), b)
+}
+
+// record returns the values indexed for an item, none when it has no record.
+func (vi *valueIndex) record(path string, name string, uuid string) ([]string, error) {
+	resp, err := vi.svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(vi.bucketName),
+		Key:    aws.String(vi.recordKey(path, name, uuid)),
+	})
+	if err != nil {
+		if e, ok := err.(awserr.Error); ok && e.Code() == s3.ErrCodeNoSuchKey {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var values []string
+	if err := json.NewDecoder(resp.Body).Decode(&values); err != nil {
+		return nil, fmt.Errorf("could not decode index record of %s: %w", uuid, err)
+	}
+	return values, nil
+}
+
+func (vi *valueIndex) put(key string, body []byte) error {
+	_, err := vi.svc.PutObject(&s3.PutObjectInput{
+		Bucket:      aws.String(vi.bucketName),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String("application/json"),
+	})
+	return err
+}
+
+func (vi *valueIndex) delete(key string) error {
+	_, err := vi.svc.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(vi.bucketName),
+		Key:    aws.String(key),
+	})
+	return err
+}
+
+// lookup returns the UUIDs of the items stored under a path which have the given value.
+func (vi *valueIndex) lookup(path string, name string, value string) ([]string, error) {
+	prefix := vi.valuesPrefix(path, name, value)
+	uuids := []string{}
+	err := vi.svc.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(vi.bucketName),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, o := range page.Contents {
+			uuids = append(uuids, strings.TrimPrefix(*o.Key, prefix))
+		}
+		return true
+	})
+	return uuids, err
+}
+
+// IndexSummary reports the outcome of rebuilding an index.
+type IndexSummary struct {
+	Cleared int64 `json:"cleared"`
+	Indexed int64 `json:"indexed"`
+	Skipped int64 `json:"skipped"`
+	Failed  int64 `json:"failed"`
+}
+
+// rebuild clears the values and records of the names of the items stored under a path, and passes every item stored
+// under it to update again, reading them in parallel. The index of the items stored under other paths is left as it is.
+// Reserved keys and those of the items under other paths are ignored, other keys which are not an item's UUID are skipped.
+func (vi *valueIndex) rebuild(path string, names []string, workers int, update func(uuid string, path string, body []byte) error, log *logger.UPPLogger) (IndexSummary, error) {
+	var summary IndexSummary
+	var failed error
+	var mu sync.Mutex
+	fail := func(key string, err error) {
+		log.WithError(err).Errorf("Failed to rebuild the index of %s", key)
+		atomic.AddInt64(&summary.Failed, 1)
+		mu.Lock()
+		failed = err
+		mu.Unlock()
+	}
+
+	// The scope of the items at the root of the bucket is a prefix of the scopes of every path, so only the values
+	// and records of each name are cleared
+	for _, name := range names {
+		for _, part := range []string{"v/", "k/"} {
+			err := vi.svc.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+				Bucket: aws.String(vi.bucketName),
+				Prefix: aws.String(vi.namePrefix(path, name) + part),
+			}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+				var keys []string
+				for _, o := range page.Contents {
+					keys = append(keys, *o.Key)
+				}
+				forEachKey(keys, workers, func(key string) {
+					if err := vi.delete(key); err != nil {
+						fail(key, err)
+						return
+					}
+					atomic.AddInt64(&summary.Cleared, 1)
+				})
+				return true
+			})
+			if err != nil {
+				return summary, err
+			}
+		}
+	}
+	if failed != nil {
+		return summary, fmt.Errorf("could not clear the index: %w", failed)
+	}
+
+	// The SDK stores the items at the root of a bucket without a prefix under their UUID's parts alone, so the root is
+	// listed without a prefix, and the items stored under paths are told apart by their number of parts
+	prefix := getKey(vi.bucketPrefix, path, "")
+	if prefix == "/" {
+		prefix = ""
+	}
+	input := &s3.ListObjectsV2Input{Bucket: aws.String(vi.bucketName)}
+	if prefix != "" {
+		input.Prefix = aws.String(prefix)
+	}
+	err := vi.svc.ListObjectsV2Pages(input, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		var keys []string
+		for _, key := range itemKeys(page) {
+			if strings.Count(strings.TrimPrefix(key, prefix), "/") == 4 {
+				keys = append(keys, key)
+			}
+		}
+		forEachKey(keys, workers, func(key string) {
+			uuid := strings.Replace(strings.TrimPrefix(key, prefix), "/", "-", -1)
+			if !uuidPattern.MatchString(uuid) {
+				atomic.AddInt64(&summary.Skipped, 1)
+				return
+			}
+			resp, err := vi.svc.GetObject(&s3.GetObjectInput{Bucket: aws.String(vi.bucketName), Key: aws.String(key)})
+			if err != nil {
+				fail(key, err)
+				return
+			}
+			body, err := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err == nil {
+				err = update(uuid, path, body)
+			}
+			if err != nil {
+				fail(key, err)
+				return
+			}
+			atomic.AddInt64(&summary.Indexed, 1)
+		})
+		return true
+	})
+	return summary, err
+}
+
+// decodeObject decodes a JSON payload, returning nil for payloads which are not JSON objects.
+func decodeObject(body []byte) map[string]interface{} {
+	var doc map[string]interface{}
+	d := json.NewDecoder(bytes.NewReader(body))
+	d.UseNumber()
+	if err := d.Decode(&doc); err != nil {
+		return nil
+	}
+	return doc
+}
+
+// Index maps the values of JSON fields of the items to their UUIDs, so items can be looked up by fields other than their UUID.
+// It is stored under the reserved __index/ prefix, with a name per field: __index/<scope>/<field>/v/<value>/<uuid>.
+type Index struct {
+	valueIndex
+	fields map[string][]string
+}
+
+func NewIndex(svc s3iface.S3API, bucketName string, bucketPrefix string, fields []string) (*Index, error) {
+	if len(fields) == 0 {
+		return nil, errors.New("no index fields configured")
+	}
+	idx := &Index{
+		valueIndex: newValueIndex(svc, bucketName, bucketPrefix, indexPrefix),
+		fields:     map[string][]string{},
+	}
+	for _, f := range fields {
+		path := strings.Split(f, ".")
+		for _, key := range path {
+			if key == "" {
+				return nil, fmt.Errorf("invalid index field %q", f)
+			}
+		}
+		idx.fields[f] = path
+	}
+	return idx, nil
+}
+
+// Fields returns the indexed fields.
+func (idx *Index) Fields() []string {
+	fields := make([]string, 0, len(idx.fields))
+	for f := range idx.fields {
+		fields = append(fields, f)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// fieldValues returns the distinct scalar values at a path of a JSON document, from every element of the arrays along it.
+func fieldValues(v interface{}, path []string) []string {
+	var values []string
+	seen := map[string]bool{}
+	var collect func(v interface{}, path []string)
+	collect = func(v interface{}, path []string) {
+		if a, ok := v.([]interface{}); ok {
+			for _, e := range a {
+				collect(e, path)
+			}
+			return
+		}
+		if len(path) > 0 {
+			if o, ok := v.(map[string]interface{}); ok {
+				collect(o[path[0]], path[1:])
+			}
+			return
+		}
+		var s string
+		switch v := v.(type) {
+		case string:
+			s = v
+		case json.Number:
+			s = v.String()
+		case bool:
+			s = fmt.Sprint(v)
+		default:
+			return
+		}
+		if !seen[s] {
+			seen[s] = true
+			values = append(values, s)
+		}
+	}
+	collect(v, path)
+	sort.Strings(values)
+	return values
+}
+
+// Update indexes the values of an item's payload, removing the values it had before. Payloads which are not JSON objects have no values.
+func (idx *Index) Update(uuid string, path string, body []byte) error {
+	doc := decodeObject(body)
+	for field, fieldPath := range idx.fields {
+		if err := idx.set(uuid, path, field, fieldValues(doc, fieldPath)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Remove removes a deleted item from the index.
+func (idx *Index) Remove(uuid string, path string) error {
+	for field := range idx.fields {
+		if err := idx.set(uuid, path, field, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (idx *Index) checkField(field string) error {
+	if _, ok := idx.fields[field]; !ok {
+		return fmt.Errorf("field %q is not indexed, expected one of %s", field, strings.Join(idx.Fields(), ", "))
+	}
+	return nil
+}
+
+// Lookup returns the UUIDs of the items stored under a path whose field has the given value.
+func (idx *Index) Lookup(field string, value string, path string) ([]string, error) {
+	if err := idx.checkField(field); err != nil {
+		return nil, err
+	}
+	return idx.lookup(path, field, value)
+}
+
+// Rebuild clears the index of the items stored under a path and indexes every item again, reading them in parallel.
+// Lookups are incomplete until it has finished. Keys which are not those of an item's UUID are skipped.
+func (idx *Index) Rebuild(path string, workers int, log *logger.UPPLogger) (IndexSummary, error) {
+	return idx.rebuild(path, idx.Fields(), workers, idx.Update, log)
+}
+
+// itemIndex is an index kept up to date by an IndexWriter.
+type itemIndex interface {
+	Update(uuid string, path string, body []byte) error
+	Remove(uuid string, path string) error
+}
+
+// IndexWriter keeps an Index, or References, up to date with the items created, updated and deleted by a Writer.
+// Failing to update the index does not fail the write, the index can be corrected by rebuilding it.
+type IndexWriter struct {
+	Writer
+	index itemIndex
+	log   *logger.UPPLogger
+}
+
+func NewIndexWriter(writer Writer, index itemIndex, log *logger.UPPLogger) *IndexWriter {
+	return &IndexWriter{Writer: writer, index: index, log: log}
+}
+
+func (w *IndexWriter) Write(uuid string, path string, b *[]byte, ct string, tid string, ignoreHash bool) (Status, error) {
+	status, err := w.Writer.Write(uuid, path, b, ct, tid, ignoreHash)
+	if err != nil || (status != CREATED && status != UPDATED) {
+		return status, err
+	}
+	if err := w.index.Update(uuid, path, *b); err != nil {
+		w.log.WithError(err).WithTransactionID(tid).WithUUID(uuid).Error("Failed to update the index")
+	}
+	return status, nil
+}
+
+func (w *IndexWriter) Delete(uuid string, path string, tid string) error {
+	if err := w.Writer.Delete(uuid, path, tid); err != nil {
+		return err
+	}
+	if err := w.index.Remove(uuid, path); err != nil {
+		w.log.WithError(err).WithTransactionID(tid).WithUUID(uuid).Error("Failed to remove from the index")
+	}
+	return nil
+}
+
+type IndexHandler struct {
+	index *Index
+	log   *logger.UPPLogger
+}
+
+func NewIndexHandler(index *Index, log *logger.UPPLogger) IndexHandler {
+	return IndexHandler{index: index, log: log}
+}
+
+// HandleLookup returns the UUIDs of the items whose indexed field has a value.
+func (ih *IndexHandler) HandleLookup(rw http.ResponseWriter, r *http.Request) {
+	tid := transactionid.GetTransactionIDFromRequest(r)
+	q := r.URL.Query()
+	field, value := q.Get("field"), q.Get("value")
+	if field == "" || !q.Has("value") {
+		respondBadRequest(errors.New("field and value are required"), rw)
+		return
+	}
+	if err := ih.index.checkField(field); err != nil {
+		respondBadRequest(err, rw)
+		return
+	}
+
+	uuids, err := ih.index.Lookup(field, value, q.Get("path"))
+	if err != nil {
+		readerServiceUnavailable(r.URL.RequestURI(), err, rw, tid, ih.log)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(rw).Encode(uuids); err != nil {
+		ih.log.WithError(err).WithTransactionID(tid).Error("Error writing lookup")
+	}
+}
@@ -0,0 +1,344 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Financial-Times/go-logger/v2"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+// bucketMock is an in memory bucket, keeping the objects put to it.
+type bucketMock struct {
+	s3iface.S3API
+	sync.Mutex
+	objects      map[string]string
+	contentTypes map[string]string
+	err          error
+	latency      time.Duration // how long each request takes, so concurrent requests interleave
+}
+
+func (m *bucketMock) request() {
+	if m.latency > 0 {
+		time.Sleep(m.latency)
+	}
+}
+
+func newBucketMock(objects map[string]string) *bucketMock {
+	if objects == nil {
+		objects = map[string]string{}
+	}
+	return &bucketMock{objects: objects, contentTypes: map[string]string{}}
+}
+
+func (m *bucketMock) PutObject(poi *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+	m.request()
+	m.Lock()
+	defer m.Unlock()
+	if m.err != nil {
+		return nil, m.err
+	}
+	b, _ := io.ReadAll(poi.Body)
+	m.objects[*poi.Key] = string(b)
+	m.contentTypes[*poi.Key] = aws.StringValue(poi.ContentType)
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (m *bucketMock) GetObject(goi *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	m.request()
+	m.Lock()
+	defer m.Unlock()
+	if m.err != nil {
+		return nil, m.err
+	}
+	o, ok := m.objects[*goi.Key]
+	if !ok {
+		return nil, awserr.New(s3.ErrCodeNoSuchKey, "not found", nil)
+	}
+	return &s3.GetObjectOutput{Body: io.NopCloser(strings.NewReader(o)), ContentType: aws.String(m.contentTypes[*goi.Key])}, nil
+}
+
+func (m *bucketMock) HeadObject(hoi *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+	m.request()
+	m.Lock()
+	defer m.Unlock()
+	if m.err != nil {
+		return nil, m.err
+	}
+	if _, ok := m.objects[*hoi.Key]; !ok {
+		return nil, awserr.New("NotFound", "not found", nil)
+	}
+	return &s3.HeadObjectOutput{ContentType: aws.String(m.contentTypes[*hoi.Key])}, nil
+}
+
+func (m *bucketMock) DeleteObject(doi *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error) {
+	m.request()
+	m.Lock()
+	defer m.Unlock()
+	if m.err != nil {
+		return nil, m.err
+	}
+	delete(m.objects, *doi.Key)
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+func (m *bucketMock) ListObjectsV2Pages(loi *s3.ListObjectsV2Input, fn func(p *s3.ListObjectsV2Output, lastPage bool) bool) error {
+	if m.err != nil {
+		return m.err
+	}
+	fn(&s3.ListObjectsV2Output{Contents: m.list(aws.StringValue(loi.Prefix))}, true)
+	return nil
+}
+
+func (m *bucketMock) list(prefix string) []*s3.Object {
+	m.Lock()
+	defer m.Unlock()
+	var keys []string
+	for k := range m.objects {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	var objects []*s3.Object
+	for _, k := range keys {
+		objects = append(objects, &s3.Object{Key: aws.String(k)})
+	}
+	return objects
+}
+
+func (m *bucketMock) keys(prefix string) []string {
+	var keys []string
+	for _, o := range m.list(prefix) {
+		keys = append(keys, *o.Key)
+	}
+	return keys
+}
+
+const (
+	indexedUUID      = "2136f8ad-e94e-45cb-b616-336f38533214"
+	otherIndexedUUID = "5b0be968-b8a3-4d66-8f1b-7b2b7ae1ff7c"
+)
+
+func newTestIndex(t *testing.T, svc s3iface.S3API, bucketPrefix string) *Index {
+	idx, err := NewIndex(svc, "bucketName", bucketPrefix, []string{"prefLabel", "alternativeIdentifiers.TME"})
+	assert.NoError(t, err)
+	return idx
+}
+
+func TestNewIndexInvalidFields(t *testing.T) {
+	_, err := NewIndex(newBucketMock(nil), "bucketName", "", nil)
+	assert.EqualError(t, err, "no index fields configured")
+	_, err = NewIndex(newBucketMock(nil), "bucketName", "", []string{"type."})
+	assert.EqualError(t, err, `invalid index field "type."`)
+}
+
+func TestFieldValues(t *testing.T) {
+	doc := map[string]interface{}{
+		"prefLabel": "Brand",
+		"score":     json.Number("1.5"),
+		"flags":     []interface{}{true, "a", "a", nil, map[string]interface{}{}},
+		"alternativeIdentifiers": map[string]interface{}{
+			"TME": []interface{}{"TME-2", "TME-1"},
+		},
+		"labels": []interface{}{map[string]interface{}{"value": "b"}, map[string]interface{}{"value": "a"}},
+	}
+	assert.Equal(t, []string{"Brand"}, fieldValues(doc, []string{"prefLabel"}))
+	assert.Equal(t, []string{"1.5"}, fieldValues(doc, []string{"score"}))
+	assert.Equal(t, []string{"a", "true"}, fieldValues(doc, []string{"flags"}))
+	assert.Equal(t, []string{"TME-1", "TME-2"}, fieldValues(doc, []string{"alternativeIdentifiers", "TME"}))
+	assert.Equal(t, []string{"a", "b"}, fieldValues(doc, []string{"labels", "value"}))
+	assert.Empty(t, fieldValues(doc, []string{"prefLabel", "value"}))
+	assert.Empty(t, fieldValues(nil, []string{"prefLabel"}))
+}
+
+func TestIndexUpdateAndLookup(t *testing.T) {
+	s := newBucketMock(nil)
+	idx := newTestIndex(t, s, "concepts")
+
+	assert.NoError(t, idx.Update(indexedUUID, "", []byte(`{"prefLabel": "Financial Times", "alternativeIdentifiers": {"TME": ["TME-1", "TME-2"]}}`)))
+	assert.NoError(t, idx.Update(otherIndexedUUID, "", []byte(`{"prefLabel": "Financial Times"}`)))
+	assert.Equal(t, []string{
+		"__index/concepts/alternativeIdentifiers.TME/k/" + indexedUUID,
+		"__index/concepts/alternativeIdentifiers.TME/v/TME-1/" + indexedUUID,
+		"__index/concepts/alternativeIdentifiers.TME/v/TME-2/" + indexedUUID,
+		"__index/concepts/prefLabel/k/" + indexedUUID,
+		"__index/concepts/prefLabel/k/" + otherIndexedUUID,
+		"__index/concepts/prefLabel/v/Financial%20Times/" + indexedUUID,
+		"__index/concepts/prefLabel/v/Financial%20Times/" + otherIndexedUUID,
+	}, s.keys(""))
+	assert.Equal(t, `["TME-1","TME-2"]`, s.objects["__index/concepts/alternativeIdentifiers.TME/k/"+indexedUUID])
+
+	uuids, err := idx.Lookup("prefLabel", "Financial Times", "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{indexedUUID, otherIndexedUUID}, uuids)
+
+	assert.NoError(t, idx.Update(indexedUUID, "", []byte(`{"prefLabel": "FT", "alternativeIdentifiers": {"TME": "TME-2"}}`)))
+	uuids, err = idx.Lookup("prefLabel", "Financial Times", "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{otherIndexedUUID}, uuids)
+	uuids, err = idx.Lookup("prefLabel", "FT", "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{indexedUUID}, uuids)
+	uuids, err = idx.Lookup("alternativeIdentifiers.TME", "TME-1", "")
+	assert.NoError(t, err)
+	assert.Empty(t, uuids)
+
+	assert.NoError(t, idx.Remove(indexedUUID, ""))
+	assert.NoError(t, idx.Update(otherIndexedUUID, "", []byte("not json")))
+	assert.Empty(t, s.keys(""))
+
+	_, err = idx.Lookup("type", "Brand", "")
+	assert.EqualError(t, err, `field "type" is not indexed, expected one of alternativeIdentifiers.TME, prefLabel`)
+}
+
+func TestIndexScopes(t *testing.T) {
+	s := newBucketMock(nil)
+	idx := newTestIndex(t, s, "")
+	long := strings.Repeat("a/", 300)
+
+	assert.NoError(t, idx.Update(indexedUUID, "", []byte(`{"prefLabel": "a/b"}`)))
+	assert.NoError(t, idx.Update(indexedUUID, "TestDirectory", []byte(`{"prefLabel": "`+long+`"}`)))
+	assert.Equal(t, []string{"__index/TestDirectory/prefLabel/k/" + indexedUUID}, s.keys("__index/TestDirectory/prefLabel/k/"))
+	assert.Equal(t, []string{"__index/TestDirectory/prefLabel/v/" + indexValueKey(long) + "/" + indexedUUID}, s.keys("__index/TestDirectory/prefLabel/v/"))
+	assert.True(t, strings.HasPrefix(indexValueKey(long), "sha256-"))
+	assert.Equal(t, []string{"__index/prefLabel/v/a%2Fb/" + indexedUUID}, s.keys("__index/prefLabel/v/"))
+
+	uuids, err := idx.Lookup("prefLabel", long, "TestDirectory")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{indexedUUID}, uuids)
+	uuids, err = idx.Lookup("prefLabel", long, "")
+	assert.NoError(t, err)
+	assert.Empty(t, uuids)
+}
+
+func TestIndexRebuild(t *testing.T) {
+	s := newBucketMock(map[string]string{
+		"concepts/21/36f8ad/e94e/45cb/b616-336f38533214":      `{"prefLabel": "Not an item key"}`,
+		"concepts/2136f8ad/e94e/45cb/b616/336f38533214":       `{"prefLabel": "Financial Times"}`,
+		"concepts/5b0be968/b8a3/4d66/8f1b/7b2b7ae1ff7c":       `<concept/>`,
+		"__index/concepts/prefLabel/v/Stale/" + indexedUUID:   "",
+		"__index/concepts/prefLabel/k/" + indexedUUID:         `["Stale"]`,
+		"__index/other/prefLabel/v/Other/" + otherIndexedUUID: "",
+	})
+	idx := newTestIndex(t, s, "concepts")
+
+	summary, err := idx.Rebuild("", 2, logger.NewUPPLogger("index_test", "Debug"))
+	assert.NoError(t, err)
+	assert.Equal(t, IndexSummary{Cleared: 2, Indexed: 2, Skipped: 1}, summary)
+	assert.Equal(t, []string{
+		"__index/concepts/prefLabel/k/" + indexedUUID,
+		"__index/concepts/prefLabel/v/Financial%20Times/" + indexedUUID,
+		"__index/other/prefLabel/v/Other/" + otherIndexedUUID,
+	}, s.keys("__index/"))
+}
+
+func TestIndexRebuildRoot(t *testing.T) {
+	s := newBucketMock(map[string]string{
+		"2136f8ad/e94e/45cb/b616/336f38533214":                                       `{"prefLabel": "Financial Times"}`,
+		"TestDirectory/5b0be968/b8a3/4d66/8f1b/7b2b7ae1ff7c":                         `{"prefLabel": "Other"}`,
+		"__index/prefLabel/v/Stale/" + indexedUUID:                                   "",
+		"__index/TestDirectory/prefLabel/v/Other/" + otherIndexedUUID:                "",
+		"__index/TestDirectory/prefLabel/k/" + otherIndexedUUID:                      `["Other"]`,
+		"__index/TestDirectory/alternativeIdentifiers.TME/k/" + otherIndexedUUID:     `["TME"]`,
+		"__index/TestDirectory/alternativeIdentifiers.TME/v/TME/" + otherIndexedUUID: "",
+	})
+	idx := newTestIndex(t, s, "")
+
+	summary, err := idx.Rebuild("", 2, logger.NewUPPLogger("index_test", "Debug"))
+	assert.NoError(t, err)
+	assert.Equal(t, IndexSummary{Cleared: 1, Indexed: 1}, summary, "items stored under paths are neither cleared nor indexed")
+	assert.Equal(t, []string{
+		"__index/TestDirectory/alternativeIdentifiers.TME/k/" + otherIndexedUUID,
+		"__index/TestDirectory/alternativeIdentifiers.TME/v/TME/" + otherIndexedUUID,
+		"__index/TestDirectory/prefLabel/k/" + otherIndexedUUID,
+		"__index/TestDirectory/prefLabel/v/Other/" + otherIndexedUUID,
+		"__index/prefLabel/k/" + indexedUUID,
+		"__index/prefLabel/v/Financial%20Times/" + indexedUUID,
+	}, s.keys("__index/"))
+}
+
+func TestIndexUpdatesOfAnItemAreSerialised(t *testing.T) {
+	s := newBucketMock(nil)
+	idx := newTestIndex(t, s, "")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			assert.NoError(t, idx.Update(indexedUUID, "", []byte(fmt.Sprintf(`{"prefLabel": "Label %d"}`, i))))
+		}(i)
+	}
+	wg.Wait()
+	assert.Len(t, s.keys("__index/prefLabel/v/"), 1, "only the values of the last update are left")
+	assert.NoError(t, idx.Remove(indexedUUID, ""))
+	assert.Empty(t, s.keys(""))
+}
+
+func TestIndexWriter(t *testing.T) {
+	log := logger.NewUPPLogger("index_test", "Debug")
+	s := newBucketMock(nil)
+	mw := &mockWriter{writeStatus: CREATED}
+	w := NewIndexWriter(mw, newTestIndex(t, s, ""), log)
+
+	b := []byte(`{"prefLabel": "Brand"}`)
+	status, err := w.Write(indexedUUID, "", &b, "application/json", "tid_test", false)
+	assert.NoError(t, err)
+	assert.Equal(t, CREATED, status)
+	assert.Equal(t, []string{"__index/prefLabel/k/" + indexedUUID, "__index/prefLabel/v/Brand/" + indexedUUID}, s.keys(""))
+
+	mw.writeStatus = UNCHANGED
+	b = []byte(`{"prefLabel": "Unchanged"}`)
+	status, err = w.Write(indexedUUID, "", &b, "application/json", "tid_test", false)
+	assert.NoError(t, err)
+	assert.Equal(t, UNCHANGED, status)
+	assert.Equal(t, []string{"__index/prefLabel/k/" + indexedUUID, "__index/prefLabel/v/Brand/" + indexedUUID}, s.keys(""))
+
+	mw.deleteError = errors.New("delete failed")
+	assert.EqualError(t, w.Delete(indexedUUID, "", "tid_test"), "delete failed")
+	assert.Len(t, s.keys(""), 2)
+
+	mw.deleteError = nil
+	assert.NoError(t, w.Delete(indexedUUID, "", "tid_test"))
+	assert.Empty(t, s.keys(""))
+
+	s.err = errors.New("index unavailable")
+	mw.writeStatus = UPDATED
+	status, err = w.Write(indexedUUID, "", &b, "application/json", "tid_test", false)
+	assert.NoError(t, err, "failing to index does not fail the write")
+	assert.Equal(t, UPDATED, status)
+}
+
+func TestHandleLookup(t *testing.T) {
+	log := logger.NewUPPLogger("index_test", "Debug")
+	s := newBucketMock(nil)
+	idx := newTestIndex(t, s, "")
+	assert.NoError(t, idx.Update(indexedUUID, "", []byte(`{"prefLabel": "Financial Times"}`)))
+	r := mux.NewRouter()
+	IndexHandlers(r, NewIndexHandler(idx, log), ExpectedResourcePath)
+
+	assertRequestAndResponseFromRouter(t, r, withExpectedResourcePath("/__lookup?field=prefLabel&value=Financial%20Times"), http.StatusOK, `["`+indexedUUID+`"]`+"\n", "application/json")
+	assertRequestAndResponseFromRouter(t, r, withExpectedResourcePath("/__lookup?field=prefLabel&value=FT"), http.StatusOK, "[]\n", "application/json")
+	assertRequestAndResponseFromRouter(t, r, withExpectedResourcePath("/__lookup?field=prefLabel"), http.StatusBadRequest, "{\"message\":\"field and value are required\"}\n", "application/json")
+	assertRequestAndResponseFromRouter(t, r, withExpectedResourcePath("/__lookup?field=type&value=Brand"), http.StatusBadRequest, "{\"message\":\"field \\\"type\\\" is not indexed, expected one of alternativeIdentifiers.TME, prefLabel\"}\n", "application/json")
+
+	s.err = errors.New("S3 unavailable")
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, newRequest("GET", withExpectedResourcePath("/__lookup?field=prefLabel&value=FT"), ""))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+}
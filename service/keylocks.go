@@ -0,0 +1,61 @@
+package service
+
+import (
+	"slices"
+	"sort"
+	"sync"
+)
+
+// keyLocks serialises work on each key, holding a mutex only for the keys in use.
+type keyLocks struct {
+	mu    sync.Mutex
+	locks map[string]*keyLock
+}
+
+type keyLock struct {
+	sync.Mutex
+	holders int
+}
+
+// lock locks a key, returning the function unlocking it.
+func (kl *keyLocks) lock(key string) func() {
+	kl.mu.Lock()
+	if kl.locks == nil {
+		kl.locks = map[string]*keyLock{}
+	}
+	l, ok := kl.locks[key]
+	if !ok {
+		l = &keyLock{}
+		kl.locks[key] = l
+	}
+	l.holders++
+	kl.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		kl.mu.Lock()
+		l.holders--
+		if l.holders == 0 {
+			delete(kl.locks, key)
+		}
+		kl.mu.Unlock()
+	}
+}
+
+// lockAll locks several keys in order, so callers locking overlapping keys cannot deadlock, returning the function
+// unlocking them all.
+func (kl *keyLocks) lockAll(keys []string) func() {
+	keys = append([]string(nil), keys...)
+	sort.Strings(keys)
+	keys = slices.Compact(keys)
+	unlocks := make([]func(), 0, len(keys))
+	for _, key := range keys {
+		unlocks = append(unlocks, kl.lock(key))
+	}
+	return func() {
+		for i := len(unlocks) - 1; i >= 0; i-- {
+			unlocks[i]()
+		}
+	}
+}
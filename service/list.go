@@ -0,0 +1,197 @@
+package service
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+const (
+	defaultListLimit = 1000
+	maxListLimit     = 1000 // The most keys S3 returns in a single listing
+)
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// ListOptions selects a page of items, starting from the cursor returned with the previous page.
+type ListOptions struct {
+	IdsOptions
+	Limit  int
+	Cursor string
+}
+
+// ListPage is a page of items and the cursor to the next page, which is empty on the last page.
+// Filtered pages may hold fewer than the requested number of items.
+type ListPage struct {
+	Items      []obj  `json:"items"`
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+// listCursor continues a listing from an S3 continuation token. It is bound to the path and filters of the listing it
+// was issued for, and records whether the fallback bucket issued it, as continuation tokens are specific to a bucket.
+type listCursor struct {
+	Fallback bool   `json:"fallback,omitempty"`
+	Path     string `json:"path,omitempty"`
+	Filter   string `json:"filter,omitempty"`
+	Token    string `json:"token"`
+}
+
+func newListCursor(filter ListFilter, token string) listCursor {
+	return listCursor{Path: filter.Path, Filter: cursorFilter(filter), Token: token}
+}
+
+// cursorFilter encodes the filters of a listing, other than its path.
+func cursorFilter(filter ListFilter) string {
+	q := url.Values{}
+	if filter.StartAfter != "" {
+		q.Set("startAfter", filter.StartAfter)
+	}
+	for param, t := range map[string]time.Time{"modifiedSince": filter.ModifiedSince, "modifiedBefore": filter.ModifiedBefore} {
+		if !t.IsZero() {
+			q.Set(param, t.UTC().Format(time.RFC3339))
+		}
+	}
+	return q.Encode()
+}
+
+func (c listCursor) encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(cursor string) (listCursor, error) {
+	var c listCursor
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return c, errInvalidCursor
+	}
+	if err := json.Unmarshal(b, &c); err != nil || c.Token == "" {
+		return c, errInvalidCursor
+	}
+	return c, nil
+}
+
+// continues checks that a cursor was issued for a listing with the same path and filters.
+func (c listCursor) continues(filter ListFilter) error {
+	if c.Path != filter.Path || c.Filter != cursorFilter(filter) {
+		return fmt.Errorf("%w: issued for another path or filters", errInvalidCursor)
+	}
+	return nil
+}
+
+// isFallbackCursor reports whether a valid cursor was issued by the fallback bucket.
+func isFallbackCursor(cursor string) bool {
+	c, err := decodeCursor(cursor)
+	return err == nil && c.Fallback
+}
+
+// markFallbackCursor sets whether a cursor was issued by the fallback bucket, leaving empty and invalid cursors as they are.
+func markFallbackCursor(cursor string, fallback bool) string {
+	c, err := decodeCursor(cursor)
+	if err != nil {
+		return cursor
+	}
+	c.Fallback = fallback
+	return c.encode()
+}
+
+func parseListOptions(r *http.Request) (ListOptions, error) {
+	opts := ListOptions{Limit: defaultListLimit, Cursor: r.URL.Query().Get("cursor")}
+	var err error
+	if opts.IdsOptions, err = parseIdsOptions(r); err != nil {
+		return opts, err
+	}
+	if l := r.URL.Query().Get("limit"); l != "" {
+		opts.Limit, err = strconv.Atoi(l)
+		if err != nil || opts.Limit < 1 || opts.Limit > maxListLimit {
+			return opts, fmt.Errorf("limit must be between 1 and %d", maxListLimit)
+		}
+	}
+	return opts, nil
+}
+
+func (r *S3Reader) List(opts ListOptions) (ListPage, error) {
+	page := ListPage{Items: []obj{}}
+	if opts.Limit <= 0 {
+		opts.Limit = defaultListLimit
+	}
+	input := r.filteredListInput(opts.ListFilter)
+	input.MaxKeys = aws.Int64(int64(opts.Limit))
+	if opts.Cursor != "" {
+		c, err := decodeCursor(opts.Cursor)
+		if err != nil {
+			return page, err
+		}
+		if c.Fallback {
+			return page, fmt.Errorf("%w: issued by the fallback bucket", errInvalidCursor)
+		}
+		if err := c.continues(opts.ListFilter); err != nil {
+			return page, err
+		}
+		input.ContinuationToken = aws.String(c.Token)
+	}
+
+	out, err := r.svc.ListObjectsV2(input)
+	if err != nil {
+		return page, err
+	}
+
+	var objects []*s3.Object
+	for _, o := range out.Contents {
+		if isItemKey(*o.Key) && opts.matches(o) {
+			objects = append(objects, o)
+		}
+	}
+	if page.Items, err = r.listEntries(objects, opts.IdsOptions); err != nil {
+		return ListPage{Items: []obj{}}, err
+	}
+
+	if aws.BoolValue(out.IsTruncated) && aws.StringValue(out.NextContinuationToken) != "" {
+		page.NextCursor = newListCursor(opts.ListFilter, *out.NextContinuationToken).encode()
+	}
+	return page, nil
+}
+
+// listEntries builds the entry of every object in order, reading the objects' metadata in parallel when it is needed.
+// It fails with the first error reading an object's metadata, rather than returning a page with the metadata missing.
+func (r *S3Reader) listEntries(objects []*s3.Object, opts IdsOptions) ([]obj, error) {
+	entries := make([]obj, len(objects))
+	if !opts.needsHead() {
+		for i, o := range objects {
+			entries[i], _ = r.idsEntry(o, opts)
+		}
+		return entries, nil
+	}
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+	for w := 0; w < int(r.workers); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				var err error
+				if entries[i], err = r.idsEntry(objects[i], opts); err != nil {
+					once.Do(func() { firstErr = err })
+				}
+			}
+		}()
+	}
+	for i := range objects {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+	return entries, firstErr
+}
@@ -0,0 +1,165 @@
+package service
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Financial-Times/go-logger/v2"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestS3ReaderListFirstPage(t *testing.T) {
+	log := logger.NewUPPLogger("list_test", "Debug")
+	r, s := getReader(log)
+	s.listObjectsV2Outputs = []*s3.ListObjectsV2Output{
+		{
+			IsTruncated:           aws.Bool(true),
+			NextContinuationToken: aws.String("token1"),
+			Contents: []*s3.Object{
+				{Key: aws.String("test/prefix/123e4567/e89b/12d3/a456/426655440001"), Size: aws.Int64(10), LastModified: aws.Time(time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC))},
+				{Key: aws.String("test/prefix/folder/")}, // ignored as ends with '/'
+				{Key: aws.String("test/prefix/123e4567/e89b/12d3/a456/426655440002"), Size: aws.Int64(20), LastModified: aws.Time(time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC))},
+			},
+		},
+	}
+
+	filter := ListFilter{ModifiedSince: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)}
+	page, err := r.List(ListOptions{Limit: 3, IdsOptions: IdsOptions{ListFilter: filter, Fields: []string{"size"}}})
+	assert.NoError(t, err)
+	assert.Equal(t, []obj{
+		{UUID: "123e4567-e89b-12d3-a456-426655440001", Size: aws.Int64(10)},
+		{UUID: "123e4567-e89b-12d3-a456-426655440002", Size: aws.Int64(20)},
+	}, page.Items)
+	c, err := decodeCursor(page.NextCursor)
+	assert.NoError(t, err)
+	assert.Equal(t, listCursor{Filter: "modifiedSince=2024-03-01T00%3A00%3A00Z", Token: "token1"}, c)
+	assert.NotContains(t, page.NextCursor, "testBucket")
+
+	assert.Equal(t, int64(3), *s.listObjectsV2Input[0].MaxKeys)
+	assert.Equal(t, "test/prefix/", *s.listObjectsV2Input[0].Prefix)
+	assert.Nil(t, s.listObjectsV2Input[0].ContinuationToken)
+}
+
+func TestS3ReaderListLastPage(t *testing.T) {
+	log := logger.NewUPPLogger("list_test", "Debug")
+	r, s := getReaderWithMultipleWorkers(log)
+	s.headObjectOutput = &s3.HeadObjectOutput{ContentType: aws.String("application/json")}
+	s.listObjectsV2Outputs = []*s3.ListObjectsV2Output{
+		{
+			IsTruncated: aws.Bool(false),
+			Contents: []*s3.Object{
+				{Key: aws.String("test/prefix/123e4567/e89b/12d3/a456/426655440003")},
+				{Key: aws.String("test/prefix/123e4567/e89b/12d3/a456/426655440004")},
+			},
+		},
+	}
+
+	filter := ListFilter{Path: "TestDirectory"}
+	cursor := newListCursor(filter, "token1").encode()
+	page, err := r.List(ListOptions{Limit: 2, Cursor: cursor, IdsOptions: IdsOptions{ListFilter: filter, Fields: []string{"contentType"}}})
+	assert.NoError(t, err)
+	assert.Equal(t, []obj{
+		{UUID: "123e4567-e89b-12d3-a456-426655440003", ContentType: "application/json"},
+		{UUID: "123e4567-e89b-12d3-a456-426655440004", ContentType: "application/json"},
+	}, page.Items)
+	assert.Empty(t, page.NextCursor)
+	assert.Equal(t, "token1", *s.listObjectsV2Input[0].ContinuationToken)
+}
+
+func TestS3ReaderListInvalidCursor(t *testing.T) {
+	log := logger.NewUPPLogger("list_test", "Debug")
+	r, s := getReader(log)
+
+	filter := ListFilter{Path: "TestDirectory", StartAfter: expectedUUID}
+	cursors := map[string]string{
+		"malformed":            "not-a-cursor",
+		"from the fallback":    markFallbackCursor(newListCursor(filter, "token1").encode(), true),
+		"for another path":     newListCursor(ListFilter{StartAfter: expectedUUID}, "token1").encode(),
+		"for another filter":   newListCursor(ListFilter{Path: "TestDirectory"}, "token1").encode(),
+		"for a modified range": newListCursor(ListFilter{Path: "TestDirectory", StartAfter: expectedUUID, ModifiedBefore: time.Now()}, "token1").encode(),
+	}
+	for name, cursor := range cursors {
+		_, err := r.List(ListOptions{Limit: 10, Cursor: cursor, IdsOptions: IdsOptions{ListFilter: filter}})
+		assert.ErrorIs(t, err, errInvalidCursor, name)
+	}
+	assert.Empty(t, s.listObjectsV2Input)
+}
+
+func TestS3ReaderListFails(t *testing.T) {
+	log := logger.NewUPPLogger("list_test", "Debug")
+	r, s := getReader(log)
+	s.s3error = errors.New("list failed")
+
+	_, err := r.List(ListOptions{Limit: 10})
+	assert.EqualError(t, err, "list failed")
+}
+
+func TestS3ReaderListFailsWhenMetadataCannotBeRead(t *testing.T) {
+	log := logger.NewUPPLogger("list_test", "Debug")
+	readers := map[string]func(*logger.UPPLogger) (Reader, *mockS3Client){
+		"one worker":      getReader,
+		"several workers": getReaderWithMultipleWorkers,
+	}
+	for name, newReader := range readers {
+		t.Run(name, func(t *testing.T) {
+			r, s := newReader(log)
+			s.headObjectOutput = &s3.HeadObjectOutput{}
+			s.notFoundError = errors.New("head failed")
+			s.listObjectsV2Outputs = []*s3.ListObjectsV2Output{
+				{
+					Contents: []*s3.Object{
+						{Key: aws.String("test/prefix/123e4567/e89b/12d3/a456/426655440003")},
+						{Key: aws.String("test/prefix/123e4567/e89b/12d3/a456/426655440004")},
+					},
+				},
+			}
+
+			page, err := r.List(ListOptions{Limit: 2, IdsOptions: IdsOptions{Fields: []string{"hash"}}})
+			assert.EqualError(t, err, "head failed")
+			assert.Empty(t, page.Items)
+		})
+	}
+}
+
+func TestParseListOptions(t *testing.T) {
+	opts, err := parseListOptions(httptest.NewRequest("GET", "/__list", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, ListOptions{Limit: defaultListLimit}, opts)
+
+	opts, err = parseListOptions(httptest.NewRequest("GET", "/__list?limit=5&cursor=abc&fields=size&path=TestDirectory", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, ListOptions{Limit: 5, Cursor: "abc", IdsOptions: IdsOptions{ListFilter: ListFilter{Path: "TestDirectory"}, Fields: []string{"size"}}}, opts)
+
+	for _, limit := range []string{"0", "1001", "ten"} {
+		_, err = parseListOptions(httptest.NewRequest("GET", "/__list?limit="+limit, nil))
+		assert.Error(t, err, limit)
+	}
+}
+
+func TestReaderHandlerList(t *testing.T) {
+	log := logger.NewUPPLogger("list_test", "Debug")
+	r := mux.NewRouter()
+	mr := &mockReader{listPage: ListPage{Items: []obj{{UUID: expectedUUID}}, NextCursor: "next"}, log: log}
+	Handlers(r, WriterHandler{}, NewReaderHandler(mr, log), ExpectedResourcePath)
+
+	assertRequestAndResponseFromRouter(t, r, withExpectedResourcePath("/__list?limit=1&cursor=abc"), 200, `{"items":[{"ID":"`+expectedUUID+`"}],"nextCursor":"next"}`+"\n", ExpectedContentType)
+	assert.Equal(t, 1, mr.listOptions.Limit)
+	assert.Equal(t, "abc", mr.listOptions.Cursor)
+	assertRequestAndResponseFromRouter(t, r, withExpectedResourcePath("/__list?limit=0"), 400, "", ExpectedContentType)
+}
+
+func TestReaderHandlerListErrors(t *testing.T) {
+	log := logger.NewUPPLogger("list_test", "Debug")
+	r := mux.NewRouter()
+	mr := &mockReader{returnError: errInvalidCursor, log: log}
+	Handlers(r, WriterHandler{}, NewReaderHandler(mr, log), ExpectedResourcePath)
+	assertRequestAndResponseFromRouter(t, r, withExpectedResourcePath("/__list?cursor=abc"), 400, "{\"message\":\"invalid cursor\"}\n", ExpectedContentType)
+
+	mr.returnError = errors.New("list failed")
+	assertRequestAndResponseFromRouter(t, r, withExpectedResourcePath("/__list"), 503, "{\"message\":\"Service currently unavailable\"}", ExpectedContentType)
+}
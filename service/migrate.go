@@ -0,0 +1,312 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/Financial-Times/go-logger/v2"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+const (
+	// LayoutPartitioned stores items as <prefix>/123e4567/e89b/12d3/a456/426655440000, the layout used by the service.
+	LayoutPartitioned = "partitioned"
+	// LayoutFlat stores items as <prefix>/123e4567-e89b-12d3-a456-426655440000.
+	LayoutFlat = "flat"
+)
+
+// BucketLocation identifies a bucket, the prefix items are stored under and the layout of their keys.
+type BucketLocation struct {
+	Svc    s3iface.S3API
+	Bucket string
+	Prefix string
+	Layout string
+}
+
+func (l BucketLocation) key(uuid string) string {
+	if l.Layout != LayoutFlat {
+		return getKey(l.Prefix, "", uuid)
+	}
+	if l.Prefix == "" {
+		return uuid
+	}
+	return l.Prefix + "/" + uuid
+}
+
+func (l BucketLocation) uuid(key string) string {
+	id := strings.TrimPrefix(key, l.Prefix+"/")
+	if l.Layout == LayoutFlat {
+		return id
+	}
+	return strings.Replace(id, "/", "-", -1)
+}
+
+func (l BucketLocation) listInput() *s3.ListObjectsV2Input {
+	input := &s3.ListObjectsV2Input{Bucket: aws.String(l.Bucket)}
+	if l.Prefix != "" {
+		input.Prefix = aws.String(l.Prefix + "/")
+	}
+	return input
+}
+
+// MigrateSummary reports the outcome of copying a bucket.
+type MigrateSummary struct {
+	DryRun     bool   `json:"dryRun"`
+	Listed     int64  `json:"listed"`
+	Copied     int64  `json:"copied"`
+	Skipped    int64  `json:"skipped"`
+	Failed     int64  `json:"failed"`
+	Checkpoint string `json:"checkpoint,omitempty"`
+}
+
+// VerifySummary reports the outcome of comparing every source object to its copy.
+type VerifySummary struct {
+	Checked    int64 `json:"checked"`
+	Missing    int64 `json:"missing"`
+	Mismatched int64 `json:"mismatched"`
+	Failed     int64 `json:"failed"`
+}
+
+type migrateCheckpoint struct {
+	StartAfter string `json:"startAfter"`
+}
+
+// BucketMigrator copies every object from a source bucket location to a destination one.
+// Progress is saved to a checkpoint file after each listed page so an interrupted run can be resumed.
+type BucketMigrator struct {
+	src            BucketLocation
+	dst            BucketLocation
+	workers        int
+	checkpointFile string
+	dryRun         bool
+	overwrite      bool
+	log            *logger.UPPLogger
+}
+
+func NewBucketMigrator(src BucketLocation, dst BucketLocation, workers int, checkpointFile string, dryRun bool, overwrite bool, log *logger.UPPLogger) (*BucketMigrator, error) {
+	if err := validateLocations(src, dst); err != nil {
+		return nil, err
+	}
+	if workers <= 0 {
+		workers = defaultWorkers
+	}
+	return &BucketMigrator{
+		src:            src,
+		dst:            dst,
+		workers:        workers,
+		checkpointFile: checkpointFile,
+		dryRun:         dryRun,
+		overwrite:      overwrite,
+		log:            log,
+	}, nil
+}
+
+// Migrate copies the objects listed after the saved checkpoint, or every object when there is none.
+func (m *BucketMigrator) Migrate() (MigrateSummary, error) {
+	summary := MigrateSummary{DryRun: m.dryRun}
+	cp, err := m.readCheckpoint()
+	if err != nil {
+		return summary, err
+	}
+
+	input := m.src.listInput()
+	if cp.StartAfter != "" {
+		m.log.Infof("Resuming migration after key %s", cp.StartAfter)
+		input.StartAfter = aws.String(cp.StartAfter)
+		summary.Checkpoint = cp.StartAfter
+	}
+
+	failed := false
+	var checkpointErr error
+	err = m.src.Svc.ListObjectsV2Pages(input, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		keys := itemKeys(page)
+		summary.Listed += int64(len(keys))
+		pageFailures := m.copyKeys(keys, &summary)
+
+		// Once a copy has failed the checkpoint stays before it, so resuming retries the failed objects.
+		if pageFailures > 0 {
+			failed = true
+		}
+		if failed || m.dryRun || len(page.Contents) == 0 {
+			return true
+		}
+		summary.Checkpoint = *page.Contents[len(page.Contents)-1].Key
+		if checkpointErr = m.writeCheckpoint(migrateCheckpoint{StartAfter: summary.Checkpoint}); checkpointErr != nil {
+			return false
+		}
+		return true
+	})
+	if err == nil {
+		err = checkpointErr
+	}
+	return summary, err
+}
+
+func (m *BucketMigrator) copyKeys(keys []string, summary *MigrateSummary) int64 {
+	var copied, skipped, failed int64
+	forEachKey(keys, m.workers, func(srcKey string) {
+		dstKey := m.dst.key(m.src.uuid(srcKey))
+		var ok bool
+		var err error
+		if m.dryRun {
+			ok, err = m.wouldCopy(dstKey)
+			if ok {
+				m.log.Infof("Would copy %s/%s to %s/%s", m.src.Bucket, srcKey, m.dst.Bucket, dstKey)
+			}
+		} else {
+			ok, err = copyObject(m.dst.Svc, m.src.Bucket, srcKey, m.dst.Bucket, dstKey, m.overwrite)
+		}
+		switch {
+		case err != nil:
+			atomic.AddInt64(&failed, 1)
+			m.log.WithError(err).Errorf("Failed to copy %s/%s to %s/%s", m.src.Bucket, srcKey, m.dst.Bucket, dstKey)
+		case ok:
+			atomic.AddInt64(&copied, 1)
+		default:
+			atomic.AddInt64(&skipped, 1)
+		}
+	})
+	summary.Copied += copied
+	summary.Skipped += skipped
+	summary.Failed += failed
+	return failed
+}
+
+// wouldCopy reports whether a run would copy an object to the destination key, which it skips when it is already there
+// unless objects are overwritten.
+func (m *BucketMigrator) wouldCopy(dstKey string) (bool, error) {
+	if m.overwrite {
+		return true, nil
+	}
+	exists, err := objectExists(m.dst.Svc, m.dst.Bucket, dstKey)
+	return !exists, err
+}
+
+// Verify checks every source object has a copy in the destination with the same content type, size and metadata.
+func (m *BucketMigrator) Verify() (VerifySummary, error) {
+	var summary VerifySummary
+	err := m.src.Svc.ListObjectsV2Pages(m.src.listInput(), func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		keys := itemKeys(page)
+		forEachKey(keys, m.workers, func(srcKey string) {
+			atomic.AddInt64(&summary.Checked, 1)
+			dstKey := m.dst.key(m.src.uuid(srcKey))
+
+			src, err := headObject(m.src.Svc, m.src.Bucket, srcKey)
+			if err != nil {
+				atomic.AddInt64(&summary.Failed, 1)
+				m.log.WithError(err).Errorf("Failed to verify %s/%s", m.src.Bucket, srcKey)
+				return
+			}
+			dst, err := headObject(m.dst.Svc, m.dst.Bucket, dstKey)
+			if e, ok := err.(awserr.Error); ok && e.Code() == "NotFound" {
+				atomic.AddInt64(&summary.Missing, 1)
+				m.log.Errorf("Object %s/%s is missing from %s/%s", m.src.Bucket, srcKey, m.dst.Bucket, dstKey)
+				return
+			}
+			if err != nil {
+				atomic.AddInt64(&summary.Failed, 1)
+				m.log.WithError(err).Errorf("Failed to verify %s/%s", m.dst.Bucket, dstKey)
+				return
+			}
+
+			if aws.StringValue(src.ContentType) != aws.StringValue(dst.ContentType) ||
+				aws.Int64Value(src.ContentLength) != aws.Int64Value(dst.ContentLength) ||
+				!reflect.DeepEqual(aws.StringValueMap(src.Metadata), aws.StringValueMap(dst.Metadata)) {
+				atomic.AddInt64(&summary.Mismatched, 1)
+				m.log.Errorf("Object %s/%s differs from %s/%s", m.src.Bucket, srcKey, m.dst.Bucket, dstKey)
+			}
+		})
+		return true
+	})
+	return summary, err
+}
+
+func validateLocations(src BucketLocation, dst BucketLocation) error {
+	for _, l := range []BucketLocation{src, dst} {
+		if l.Layout != LayoutPartitioned && l.Layout != LayoutFlat {
+			return fmt.Errorf("unknown key layout %q", l.Layout)
+		}
+		if l.Bucket == "" {
+			return errors.New("source and destination buckets are required")
+		}
+	}
+	if src.Bucket == dst.Bucket && src.key("uuid") == dst.key("uuid") {
+		return errors.New("source and destination are the same")
+	}
+	return nil
+}
+
+func (m *BucketMigrator) readCheckpoint() (migrateCheckpoint, error) {
+	var cp migrateCheckpoint
+	if m.checkpointFile == "" {
+		return cp, nil
+	}
+	b, err := os.ReadFile(m.checkpointFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return cp, nil
+	}
+	if err != nil {
+		return cp, err
+	}
+	if err := json.Unmarshal(b, &cp); err != nil {
+		return cp, fmt.Errorf("could not decode checkpoint %s: %w", m.checkpointFile, err)
+	}
+	return cp, nil
+}
+
+func (m *BucketMigrator) writeCheckpoint(cp migrateCheckpoint) error {
+	if m.checkpointFile == "" {
+		return nil
+	}
+	b, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(m.checkpointFile, b, 0600)
+}
+
+func itemKeys(page *s3.ListObjectsV2Output) []string {
+	var keys []string
+	for _, o := range page.Contents {
+		if isItemKey(*o.Key) {
+			keys = append(keys, *o.Key)
+		}
+	}
+	return keys
+}
+
+func headObject(svc s3iface.S3API, bucket string, key string) (*s3.HeadObjectOutput, error) {
+	return svc.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+}
+
+// forEachKey calls fn for every key using the given number of workers, returning once all calls are done.
+func forEachKey(keys []string, workers int, fn func(key string)) {
+	kc := make(chan string)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for k := range kc {
+				fn(k)
+			}
+		}()
+	}
+	for _, k := range keys {
+		kc <- k
+	}
+	close(kc)
+	wg.Wait()
+}
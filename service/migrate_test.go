@@ -0,0 +1,213 @@
+package service
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/Financial-Times/go-logger/v2"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/assert"
+)
+
+func migrationPages() []*s3.ListObjectsV2Output {
+	return []*s3.ListObjectsV2Output{
+		{
+			Contents: []*s3.Object{
+				{Key: aws.String("old/123e4567/e89b/12d3/a456/426655440001")},
+				{Key: aws.String("old/123e4567/e89b/12d3/a456/426655440002")},
+				{Key: aws.String("old/folder/")}, // ignored as ends with '/'
+			},
+		},
+		{
+			Contents: []*s3.Object{
+				{Key: aws.String("old/123e4567/e89b/12d3/a456/426655440003")},
+			},
+		},
+	}
+}
+
+func newTestBucketMigrator(t *testing.T, checkpointFile string, dryRun bool) (*BucketMigrator, *mockS3Client, *mockS3Client) {
+	log := logger.NewUPPLogger("migrate_test", "Debug")
+	src := &mockS3Client{log: log, headObjectOutput: &s3.HeadObjectOutput{}, listObjectsV2Outputs: migrationPages()}
+	dst := &mockS3Client{log: log, headObjectOutput: &s3.HeadObjectOutput{}}
+	dst.notFoundError = awserr.New("NotFound", "Object not found", errors.New("some error"))
+	m, err := NewBucketMigrator(
+		BucketLocation{Svc: src, Bucket: "oldBucket", Prefix: "old", Layout: LayoutPartitioned},
+		BucketLocation{Svc: dst, Bucket: "newBucket", Prefix: "new", Layout: LayoutFlat},
+		2, checkpointFile, dryRun, false, log)
+	assert.NoError(t, err)
+	return m, src, dst
+}
+
+func copiedKeys(s *mockS3Client) []string {
+	var keys []string
+	for _, coi := range s.copyObjectInputs {
+		keys = append(keys, *coi.CopySource+" -> "+*coi.Key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestBucketLocationKeys(t *testing.T) {
+	tests := []struct {
+		name     string
+		location BucketLocation
+		key      string
+	}{
+		{name: "partitioned", location: BucketLocation{Prefix: "prefix", Layout: LayoutPartitioned}, key: "prefix/123e4567/e89b/12d3/a456/426655440000"},
+		{name: "partitioned without prefix", location: BucketLocation{Layout: LayoutPartitioned}, key: "/123e4567/e89b/12d3/a456/426655440000"},
+		{name: "flat", location: BucketLocation{Prefix: "prefix", Layout: LayoutFlat}, key: "prefix/123e4567-e89b-12d3-a456-426655440000"},
+		{name: "flat without prefix", location: BucketLocation{Layout: LayoutFlat}, key: "123e4567-e89b-12d3-a456-426655440000"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.key, test.location.key(expectedUUID))
+			assert.Equal(t, expectedUUID, test.location.uuid(test.key))
+		})
+	}
+}
+
+func TestNewBucketMigratorErrors(t *testing.T) {
+	log := logger.NewUPPLogger("migrate_test", "Debug")
+	_, err := NewBucketMigrator(BucketLocation{Bucket: "a", Layout: "nested"}, BucketLocation{Bucket: "b", Layout: LayoutFlat}, 1, "", false, false, log)
+	assert.Error(t, err)
+	_, err = NewBucketMigrator(BucketLocation{Layout: LayoutFlat}, BucketLocation{Bucket: "b", Layout: LayoutFlat}, 1, "", false, false, log)
+	assert.Error(t, err)
+	_, err = NewBucketMigrator(BucketLocation{Bucket: "a", Prefix: "p", Layout: LayoutFlat}, BucketLocation{Bucket: "a", Prefix: "p", Layout: LayoutFlat}, 1, "", false, false, log)
+	assert.Error(t, err)
+}
+
+func TestBucketMigratorMigrate(t *testing.T) {
+	checkpointFile := filepath.Join(t.TempDir(), "checkpoint.json")
+	m, src, dst := newTestBucketMigrator(t, checkpointFile, false)
+
+	summary, err := m.Migrate()
+
+	assert.NoError(t, err)
+	assert.Equal(t, MigrateSummary{Listed: 3, Copied: 3, Checkpoint: "old/123e4567/e89b/12d3/a456/426655440003"}, summary)
+	assert.Equal(t, []string{
+		"oldBucket/old/123e4567/e89b/12d3/a456/426655440001 -> new/123e4567-e89b-12d3-a456-426655440001",
+		"oldBucket/old/123e4567/e89b/12d3/a456/426655440002 -> new/123e4567-e89b-12d3-a456-426655440002",
+		"oldBucket/old/123e4567/e89b/12d3/a456/426655440003 -> new/123e4567-e89b-12d3-a456-426655440003",
+	}, copiedKeys(dst))
+	assert.Equal(t, "newBucket", *dst.copyObjectInput.Bucket)
+	assert.Equal(t, s3.MetadataDirectiveCopy, *dst.copyObjectInput.MetadataDirective)
+	assert.Equal(t, "old/", *src.listObjectsV2Input[0].Prefix)
+
+	b, err := os.ReadFile(checkpointFile)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"startAfter":"old/123e4567/e89b/12d3/a456/426655440003"}`, string(b))
+}
+
+func TestBucketMigratorResumesFromCheckpoint(t *testing.T) {
+	checkpointFile := filepath.Join(t.TempDir(), "checkpoint.json")
+	assert.NoError(t, os.WriteFile(checkpointFile, []byte(`{"startAfter":"old/123e4567/e89b/12d3/a456/426655440002"}`), 0600))
+	m, src, _ := newTestBucketMigrator(t, checkpointFile, false)
+
+	_, err := m.Migrate()
+
+	assert.NoError(t, err)
+	assert.Equal(t, "old/123e4567/e89b/12d3/a456/426655440002", *src.listObjectsV2Input[0].StartAfter)
+}
+
+func TestBucketMigratorSkipsExistingObjects(t *testing.T) {
+	m, _, dst := newTestBucketMigrator(t, "", false)
+	dst.notFoundError = nil
+
+	summary, err := m.Migrate()
+
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), summary.Skipped)
+	assert.Empty(t, dst.copyObjectInputs)
+}
+
+func TestBucketMigratorFailureHoldsCheckpoint(t *testing.T) {
+	checkpointFile := filepath.Join(t.TempDir(), "checkpoint.json")
+	m, _, dst := newTestBucketMigrator(t, checkpointFile, false)
+	dst.notFoundError = nil
+	dst.s3error = errors.New("Some S3 error")
+
+	summary, err := m.Migrate()
+
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), summary.Failed)
+	assert.Empty(t, summary.Checkpoint)
+	_, err = os.Stat(checkpointFile)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestBucketMigratorDryRun(t *testing.T) {
+	checkpointFile := filepath.Join(t.TempDir(), "checkpoint.json")
+	m, _, dst := newTestBucketMigrator(t, checkpointFile, true)
+
+	summary, err := m.Migrate()
+
+	assert.NoError(t, err)
+	assert.Equal(t, MigrateSummary{DryRun: true, Listed: 3, Copied: 3}, summary)
+	assert.Empty(t, dst.copyObjectInputs)
+	_, err = os.Stat(checkpointFile)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestBucketMigratorDryRunSkipsExistingObjects(t *testing.T) {
+	m, _, dst := newTestBucketMigrator(t, "", true)
+	dst.notFoundError = nil
+
+	summary, err := m.Migrate()
+
+	assert.NoError(t, err)
+	assert.Equal(t, MigrateSummary{DryRun: true, Listed: 3, Skipped: 3}, summary)
+	assert.Empty(t, dst.copyObjectInputs)
+}
+
+func TestBucketMigratorVerify(t *testing.T) {
+	hash := "12345"
+	object := func() *s3.HeadObjectOutput {
+		return &s3.HeadObjectOutput{
+			ContentType:   aws.String("application/json"),
+			ContentLength: aws.Int64(42),
+			Metadata:      map[string]*string{"Current-Object-Hash": &hash},
+		}
+	}
+
+	t.Run("Copies match", func(t *testing.T) {
+		m, src, dst := newTestBucketMigrator(t, "", false)
+		src.headObjectOutput = object()
+		dst.notFoundError = nil
+		dst.headObjectOutput = object()
+
+		summary, err := m.Verify()
+
+		assert.NoError(t, err)
+		assert.Equal(t, VerifySummary{Checked: 3}, summary)
+	})
+
+	t.Run("Copies are missing", func(t *testing.T) {
+		m, src, _ := newTestBucketMigrator(t, "", false)
+		src.headObjectOutput = object()
+
+		summary, err := m.Verify()
+
+		assert.NoError(t, err)
+		assert.Equal(t, VerifySummary{Checked: 3, Missing: 3}, summary)
+	})
+
+	t.Run("Copies differ", func(t *testing.T) {
+		m, src, dst := newTestBucketMigrator(t, "", false)
+		src.headObjectOutput = object()
+		dst.notFoundError = nil
+		dst.headObjectOutput = object()
+		dst.headObjectOutput.ContentType = aws.String("text/plain")
+
+		summary, err := m.Verify()
+
+		assert.NoError(t, err)
+		assert.Equal(t, VerifySummary{Checked: 3, Mismatched: 3}, summary)
+	})
+}
@@ -0,0 +1,350 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+
+	"github.com/Financial-Times/go-logger/v2"
+	transactionid "github.com/Financial-Times/transactionid-utils-go"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+const (
+	migrationQueueSize = 3000
+	tombstonesPrefix   = "__tombstones/"
+)
+
+// ObjectCopier copies a single item from one bucket to another.
+type ObjectCopier interface {
+	Copy(uuid string, path string) error
+}
+
+// NewS3ObjectCopier creates an ObjectCopier which copies objects server side, preserving content type and metadata.
+// Objects already present in the destination are left untouched. S3 cannot make a copy conditional on the destination,
+// so an object written between checking the destination and copying is replaced, callers serialise their writes with the copies.
+func NewS3ObjectCopier(svc s3iface.S3API, srcBucket string, srcPrefix string, dstBucket string, dstPrefix string) ObjectCopier {
+	return &S3ObjectCopier{
+		svc:       svc,
+		srcBucket: srcBucket,
+		srcPrefix: srcPrefix,
+		dstBucket: dstBucket,
+		dstPrefix: dstPrefix,
+	}
+}
+
+type S3ObjectCopier struct {
+	svc       s3iface.S3API
+	srcBucket string
+	srcPrefix string
+	dstBucket string
+	dstPrefix string
+}
+
+func (c *S3ObjectCopier) Copy(uuid string, path string) error {
+	_, err := copyObject(c.svc, c.srcBucket, getKey(c.srcPrefix, path, uuid), c.dstBucket, getKey(c.dstPrefix, path, uuid), false)
+	return err
+}
+
+// copyObject copies an object server side, preserving content type and metadata.
+// Unless overwrite is set, objects already present in the destination are left untouched and false is returned.
+func copyObject(svc s3iface.S3API, srcBucket string, srcKey string, dstBucket string, dstKey string, overwrite bool) (bool, error) {
+	if !overwrite {
+		if exists, err := objectExists(svc, dstBucket, dstKey); err != nil || exists {
+			return false, err
+		}
+	}
+
+	_, err := svc.CopyObject(&s3.CopyObjectInput{
+		Bucket:            aws.String(dstBucket),
+		Key:               aws.String(dstKey),
+		CopySource:        aws.String(copySource(srcBucket, srcKey)),
+		MetadataDirective: aws.String(s3.MetadataDirectiveCopy),
+	})
+	return err == nil, err
+}
+
+// objectExists reports whether a bucket holds an object with the given key.
+func objectExists(svc s3iface.S3API, bucket string, key string) (bool, error) {
+	_, err := headObject(svc, bucket, key)
+	if err == nil {
+		return true, nil
+	}
+	if e, ok := err.(awserr.Error); ok && e.Code() == "NotFound" {
+		return false, nil
+	}
+	return false, err
+}
+
+func copySource(bucket string, key string) string {
+	return (&url.URL{Path: bucket + "/" + key}).EscapedPath()
+}
+
+// Tombstones records the items deleted during a lazy migration in the new bucket, under the reserved __tombstones/
+// prefix, so they are not served and copied again from the legacy bucket, which is never written to.
+type Tombstones struct {
+	svc          s3iface.S3API
+	bucketName   string
+	bucketPrefix string
+}
+
+func NewTombstones(svc s3iface.S3API, bucketName string, bucketPrefix string) *Tombstones {
+	return &Tombstones{svc: svc, bucketName: bucketName, bucketPrefix: bucketPrefix}
+}
+
+func (t *Tombstones) key(uuid string, path string) string {
+	return reservedPrefix(tombstonesPrefix, t.bucketPrefix, path) + uuid
+}
+
+// Set records that an item has been deleted.
+func (t *Tombstones) Set(uuid string, path string) error {
+	_, err := t.svc.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(t.bucketName),
+		Key:    aws.String(t.key(uuid, path)),
+		Body:   bytes.NewReader(nil),
+	})
+	return err
+}
+
+// Deleted reports whether an item has been deleted.
+func (t *Tombstones) Deleted(uuid string, path string) (bool, error) {
+	return objectExists(t.svc, t.bucketName, t.key(uuid, path))
+}
+
+type copyRequest struct {
+	uuid string
+	path string
+}
+
+// MigrationProgress reports how far a lazy migration from a legacy bucket has got.
+type MigrationProgress struct {
+	LegacyCount   int64   `json:"legacyCount"`
+	MigratedCount int64   `json:"migratedCount"`
+	Progress      float64 `json:"progress"`
+	LegacyReads   int64   `json:"legacyReads"`
+	CopiedOnRead  int64   `json:"copiedOnRead"`
+	CopyFailures  int64   `json:"copyFailures"`
+	PendingCopies int     `json:"pendingCopies"`
+}
+
+// MigrationReader reads from a new bucket and serves items missing from it out of a legacy bucket,
+// copying them into the new bucket in the background. Items with a tombstone have been deleted, and are not served from
+// the legacy bucket. A copy is dropped when its item is written or deleted through the MigrationWriter before it starts,
+// and writes and deletes wait for a copy which has started, so a copy never replaces them. Writes made by other
+// instances are not serialised with the copies.
+type MigrationReader struct {
+	Reader
+	legacy       Reader
+	legacyBucket string
+	copier       ObjectCopier
+	tombstones   *Tombstones
+	copies       chan *copyRequest
+	inFlight     *sync.Map
+	items        *keyLocks
+	stats        *migrationStats
+	log          *logger.UPPLogger
+	onServe      func(bucket string)
+}
+
+type migrationStats struct {
+	legacyReads  int64
+	copied       int64
+	copyFailures int64
+}
+
+func NewMigrationReader(reader Reader, legacy Reader, legacyBucket string, copier ObjectCopier, tombstones *Tombstones, log *logger.UPPLogger) *MigrationReader {
+	return &MigrationReader{
+		Reader:       reader,
+		legacy:       legacy,
+		legacyBucket: legacyBucket,
+		copier:       copier,
+		tombstones:   tombstones,
+		copies:       make(chan *copyRequest, migrationQueueSize),
+		inFlight:     &sync.Map{},
+		items:        &keyLocks{},
+		stats:        &migrationStats{},
+		log:          log,
+	}
+}
+
+func (r *MigrationReader) withServeHook(onServe func(bucket string)) Reader {
+	c := *r
+	c.onServe = onServe
+	c.Reader = hookServe(r.Reader, onServe)
+	return &c
+}
+
+func (r *MigrationReader) Get(uuid string, path string) (bool, io.ReadCloser, *string, error) {
+	found, i, ct, err := r.Reader.Get(uuid, path)
+	if err != nil || found {
+		return found, i, ct, err
+	}
+	deleted, err := r.tombstones.Deleted(uuid, path)
+	if err != nil || deleted {
+		return false, nil, nil, err
+	}
+
+	found, i, ct, err = r.legacy.Get(uuid, path)
+	if err != nil || !found {
+		return found, i, ct, err
+	}
+
+	atomic.AddInt64(&r.stats.legacyReads, 1)
+	if r.onServe != nil {
+		r.onServe(r.legacyBucket)
+	}
+	r.queueCopy(uuid, path)
+	return found, i, ct, nil
+}
+
+func (req *copyRequest) key() string {
+	return req.path + "/" + req.uuid
+}
+
+// queueCopy queues a copy of an item unless one is already queued. The queued request is kept in inFlight until it
+// has been copied, and dropped from it when the item is written or deleted.
+func (r *MigrationReader) queueCopy(uuid string, path string) {
+	req := &copyRequest{uuid: uuid, path: path}
+	if _, loaded := r.inFlight.LoadOrStore(req.key(), req); loaded {
+		return
+	}
+
+	select {
+	case r.copies <- req:
+	default:
+		r.inFlight.CompareAndDelete(req.key(), req)
+		r.log.WithUUID(uuid).Warn("Migration copy queue is full, item will be copied on a later read")
+	}
+}
+
+// change runs a write or delete of an item, dropping its queued copy and waiting for a copy which has started.
+func (r *MigrationReader) change(uuid string, path string, f func() error) error {
+	key := path + "/" + uuid
+	defer r.items.lock(key)()
+	r.inFlight.Delete(key)
+	return f()
+}
+
+// Start runs the given number of workers copying items from the legacy bucket until the stop channel is closed.
+func (r *MigrationReader) Start(workers int, stop <-chan struct{}) {
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case req := <-r.copies:
+					r.copy(req)
+				case <-stop:
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func (r *MigrationReader) copy(req *copyRequest) {
+	defer r.items.lock(req.key())()
+	if current, ok := r.inFlight.Load(req.key()); !ok || current != req {
+		r.log.WithUUID(req.uuid).Debug("Item was written or deleted since it was read, copy was dropped")
+		return
+	}
+	defer r.inFlight.CompareAndDelete(req.key(), req)
+	if err := r.copier.Copy(req.uuid, req.path); err != nil {
+		atomic.AddInt64(&r.stats.copyFailures, 1)
+		r.log.WithError(err).WithUUID(req.uuid).Error("Failed to copy item from legacy bucket")
+		return
+	}
+	atomic.AddInt64(&r.stats.copied, 1)
+}
+
+// Progress counts the items in both buckets and reports them along with the copy-on-read statistics.
+func (r *MigrationReader) Progress() (MigrationProgress, error) {
+	legacyCount, err := r.legacy.Count("")
+	if err != nil {
+		return MigrationProgress{}, err
+	}
+	migratedCount, err := r.Reader.Count("")
+	if err != nil {
+		return MigrationProgress{}, err
+	}
+
+	progress := 1.0
+	if legacyCount > 0 && migratedCount < legacyCount {
+		progress = float64(migratedCount) / float64(legacyCount)
+	}
+	return MigrationProgress{
+		LegacyCount:   legacyCount,
+		MigratedCount: migratedCount,
+		Progress:      progress,
+		LegacyReads:   atomic.LoadInt64(&r.stats.legacyReads),
+		CopiedOnRead:  atomic.LoadInt64(&r.stats.copied),
+		CopyFailures:  atomic.LoadInt64(&r.stats.copyFailures),
+		PendingCopies: len(r.copies),
+	}, nil
+}
+
+// MigrationWriter writes and deletes in the new bucket only. Deleted items are given a tombstone, so they are not served
+// from the legacy bucket. Writes and deletes are serialised with the reader's copies of the item.
+type MigrationWriter struct {
+	Writer
+	reader *MigrationReader
+}
+
+func NewMigrationWriter(writer Writer, reader *MigrationReader) *MigrationWriter {
+	return &MigrationWriter{Writer: writer, reader: reader}
+}
+
+func (w *MigrationWriter) Write(uuid string, path string, b *[]byte, ct string, tid string, ignoreHash bool) (Status, error) {
+	var status Status
+	err := w.reader.change(uuid, path, func() error {
+		var err error
+		status, err = w.Writer.Write(uuid, path, b, ct, tid, ignoreHash)
+		return err
+	})
+	return status, err
+}
+
+// Delete records the item's tombstone first, so it cannot be read from the legacy bucket and copied back once it has
+// been deleted from the new bucket.
+func (w *MigrationWriter) Delete(uuid string, path string, tid string) error {
+	return w.reader.change(uuid, path, func() error {
+		if err := w.reader.tombstones.Set(uuid, path); err != nil {
+			return err
+		}
+		return w.Writer.Delete(uuid, path, tid)
+	})
+}
+
+type MigrationHandler struct {
+	reader *MigrationReader
+	log    *logger.UPPLogger
+}
+
+func NewMigrationHandler(reader *MigrationReader, log *logger.UPPLogger) MigrationHandler {
+	return MigrationHandler{reader: reader, log: log}
+}
+
+func (mh *MigrationHandler) HandleProgress(rw http.ResponseWriter, r *http.Request) {
+	tid := transactionid.GetTransactionIDFromRequest(r)
+	p, err := mh.reader.Progress()
+	if err != nil {
+		readerServiceUnavailable(r.URL.RequestURI(), err, rw, tid, mh.log)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(rw).Encode(p); err != nil {
+		mh.log.WithError(err).WithTransactionID(tid).Error("Error writing migration progress")
+	}
+}
@@ -0,0 +1,320 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Financial-Times/go-logger/v2"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockCopier struct {
+	sync.Mutex
+	copied      []string
+	returnError error
+}
+
+func (c *mockCopier) Copy(uuid string, path string) error {
+	c.Lock()
+	defer c.Unlock()
+	c.copied = append(c.copied, uuid)
+	return c.returnError
+}
+
+func (c *mockCopier) copiedUUIDs() []string {
+	c.Lock()
+	defer c.Unlock()
+	return append([]string(nil), c.copied...)
+}
+
+func TestMigrationReaderGetFromNewBucket(t *testing.T) {
+	log := logger.NewUPPLogger("migration_test", "Debug")
+	legacy := &mockReader{payload: "LEGACY", log: log}
+	copier := &mockCopier{}
+	mr := NewMigrationReader(&mockReader{payload: "NEW", log: log}, legacy, "legacyBucket", copier, NewTombstones(newBucketMock(nil), "bucketName", ""), log)
+
+	found, i, _, err := mr.Get(expectedUUID, "")
+
+	assert.NoError(t, err)
+	assert.True(t, found)
+	b, _ := io.ReadAll(i)
+	assert.Equal(t, "NEW", string(b))
+	assert.Empty(t, legacy.uuid)
+	assert.Equal(t, 0, len(mr.copies))
+}
+
+func TestMigrationReaderCopiesOnRead(t *testing.T) {
+	log := logger.NewUPPLogger("migration_test", "Debug")
+	copier := &mockCopier{}
+	mr := NewMigrationReader(&mockReader{log: log}, &mockReader{payload: "LEGACY", log: log}, "legacyBucket", copier, NewTombstones(newBucketMock(nil), "bucketName", ""), log)
+
+	found, i, _, err := mr.Get(expectedUUID, "")
+	assert.NoError(t, err)
+	assert.True(t, found)
+	b, _ := io.ReadAll(i)
+	assert.Equal(t, "LEGACY", string(b))
+
+	_, _, _, err = mr.Get(expectedUUID, "")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(mr.copies), "an item should only be queued once while a copy is pending")
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go mr.Start(2, stop)
+
+	assert.Eventually(t, func() bool {
+		return len(copier.copiedUUIDs()) == 1
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, []string{expectedUUID}, copier.copiedUUIDs())
+}
+
+func TestMigrationReaderMissingFromBothBuckets(t *testing.T) {
+	log := logger.NewUPPLogger("migration_test", "Debug")
+	mr := NewMigrationReader(&mockReader{log: log}, &mockReader{log: log}, "legacyBucket", &mockCopier{}, NewTombstones(newBucketMock(nil), "bucketName", ""), log)
+
+	found, _, _, err := mr.Get(expectedUUID, "")
+
+	assert.NoError(t, err)
+	assert.False(t, found)
+	assert.Equal(t, 0, len(mr.copies))
+}
+
+func TestMigrationReaderLegacyError(t *testing.T) {
+	log := logger.NewUPPLogger("migration_test", "Debug")
+	mr := NewMigrationReader(&mockReader{log: log}, &mockReader{returnError: errors.New("legacy unavailable"), log: log}, "legacyBucket", &mockCopier{}, NewTombstones(newBucketMock(nil), "bucketName", ""), log)
+
+	_, _, _, err := mr.Get(expectedUUID, "")
+
+	assert.EqualError(t, err, "legacy unavailable")
+}
+
+func TestMigrationProgress(t *testing.T) {
+	log := logger.NewUPPLogger("migration_test", "Debug")
+	copier := &mockCopier{returnError: errors.New("copy failed")}
+	mr := NewMigrationReader(&mockReader{count: 25, log: log}, &mockReader{payload: "LEGACY", count: 100, log: log}, "legacyBucket", copier, NewTombstones(newBucketMock(nil), "bucketName", ""), log)
+	mr.Get(expectedUUID, "")
+	mr.copy(<-mr.copies)
+
+	r := mux.NewRouter()
+	MigrationHandlers(r, NewMigrationHandler(mr, log), ExpectedResourcePath)
+	rec := assertRequestAndResponseFromRouter(t, r, withExpectedResourcePath("/__migration"), 200, "", "application/json")
+
+	var p MigrationProgress
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &p))
+	assert.Equal(t, MigrationProgress{
+		LegacyCount:   100,
+		MigratedCount: 25,
+		Progress:      0.25,
+		LegacyReads:   1,
+		CopyFailures:  1,
+	}, p)
+}
+
+func TestMigrationProgressFails(t *testing.T) {
+	log := logger.NewUPPLogger("migration_test", "Debug")
+	mr := NewMigrationReader(&mockReader{log: log}, &mockReader{returnError: errors.New("legacy unavailable"), log: log}, "legacyBucket", &mockCopier{}, NewTombstones(newBucketMock(nil), "bucketName", ""), log)
+
+	r := mux.NewRouter()
+	MigrationHandlers(r, NewMigrationHandler(mr, log), ExpectedResourcePath)
+	assertRequestAndResponseFromRouter(t, r, withExpectedResourcePath("/__migration"), 503, "{\"message\":\"Service currently unavailable\"}", ExpectedContentType)
+}
+
+func TestMigrationReaderReportsLegacyBucket(t *testing.T) {
+	log := logger.NewUPPLogger("migration_test", "Debug")
+	mr := NewMigrationReader(&mockReader{log: log}, &mockReader{payload: "LEGACY", log: log}, "legacyBucket", &mockCopier{}, NewTombstones(newBucketMock(nil), "bucketName", ""), log)
+	rh := NewReaderHandler(mr, log)
+
+	rec := httptest.NewRecorder()
+	rh.HandleGet(rec, newRequest("GET", "/"+expectedUUID, ""))
+
+	assert.Equal(t, 200, rec.Code)
+	assert.Equal(t, "legacyBucket", rec.Header().Get(servedByBucketHeader))
+}
+
+func TestS3ObjectCopier(t *testing.T) {
+	log := logger.NewUPPLogger("migration_test", "Debug")
+
+	t.Run("Copies missing object", func(t *testing.T) {
+		s := &mockS3Client{log: log, headObjectOutput: &s3.HeadObjectOutput{}}
+		s.notFoundError = awserr.New("NotFound", "Object not found", errors.New("some error"))
+		c := NewS3ObjectCopier(s, "legacyBucket", "legacy prefix", "newBucket", "test/prefix")
+
+		err := c.Copy(expectedUUID, "")
+
+		assert.NoError(t, err)
+		assert.Equal(t, "newBucket", *s.copyObjectInput.Bucket)
+		assert.Equal(t, "test/prefix/123e4567/e89b/12d3/a456/426655440000", *s.copyObjectInput.Key)
+		assert.Equal(t, "legacyBucket/legacy%20prefix/123e4567/e89b/12d3/a456/426655440000", *s.copyObjectInput.CopySource)
+		assert.Equal(t, s3.MetadataDirectiveCopy, *s.copyObjectInput.MetadataDirective)
+	})
+
+	t.Run("Leaves existing object", func(t *testing.T) {
+		s := &mockS3Client{log: log, headObjectOutput: &s3.HeadObjectOutput{}}
+		c := NewS3ObjectCopier(s, "legacyBucket", "", "newBucket", "test/prefix")
+
+		err := c.Copy(expectedUUID, "")
+
+		assert.NoError(t, err)
+		assert.Nil(t, s.copyObjectInput)
+	})
+
+	t.Run("Fails", func(t *testing.T) {
+		s := &mockS3Client{log: log, headObjectOutput: &s3.HeadObjectOutput{}}
+		s.s3error = errors.New("Some S3 error")
+		c := NewS3ObjectCopier(s, "legacyBucket", "", "newBucket", "test/prefix")
+
+		err := c.Copy(expectedUUID, "")
+
+		assert.Error(t, err)
+		assert.Nil(t, s.copyObjectInput)
+	})
+}
+
+func TestMigrationWriterDeletesFromNewBucketOnly(t *testing.T) {
+	log := logger.NewUPPLogger("migration_test", "Debug")
+	mw := &mockWriter{writeStatus: CREATED}
+	legacy := &mockReader{payload: "LEGACY", log: log}
+	bucket := newBucketMock(nil)
+	mr := NewMigrationReader(&mockReader{log: log}, legacy, "legacyBucket", &mockCopier{}, NewTombstones(bucket, "bucketName", ""), log)
+	w := NewMigrationWriter(mw, mr)
+
+	p := []byte("PAYLOAD")
+	status, err := w.Write(expectedUUID, "", &p, expectedContentType, expectedTransactionId, false)
+	assert.NoError(t, err)
+	assert.Equal(t, CREATED, status)
+
+	assert.NoError(t, w.Delete(expectedUUID, "TestDirectory", expectedTransactionId))
+	assert.Equal(t, expectedUUID, mw.uuid)
+	assert.Equal(t, []string{"__tombstones/TestDirectory/" + expectedUUID}, bucket.keys(""))
+
+	found, _, _, err := mr.Get(expectedUUID, "TestDirectory")
+	assert.NoError(t, err)
+	assert.False(t, found, "deleted items are not served from the legacy bucket")
+	assert.Empty(t, legacy.uuid)
+	assert.Empty(t, mr.copies)
+
+	found, _, _, err = mr.Get(expectedUUID, "")
+	assert.NoError(t, err)
+	assert.True(t, found, "tombstones are scoped to the path of the deleted item")
+}
+
+func TestMigrationWriterDeleteWithReadOnlyLegacyBucket(t *testing.T) {
+	log := logger.NewUPPLogger("migration_test", "Debug")
+	mw := &mockWriter{}
+	legacy := &mockReader{payload: "LEGACY", log: log}
+	mr := NewMigrationReader(&mockReader{log: log}, legacy, "legacyBucket", &mockCopier{}, NewTombstones(newBucketMock(nil), "bucketName", ""), log)
+	w := NewMigrationWriter(mw, mr)
+
+	// The legacy bucket is only read from, so a delete does not depend on being allowed to write to it
+	assert.NoError(t, w.Delete(expectedUUID, "", expectedTransactionId))
+	assert.Equal(t, expectedUUID, mw.uuid)
+}
+
+func TestMigrationWriterKeepsItemWhenTombstoneFails(t *testing.T) {
+	log := logger.NewUPPLogger("migration_test", "Debug")
+	mw := &mockWriter{}
+	bucket := newBucketMock(nil)
+	bucket.err = errors.New("S3 unavailable")
+	mr := NewMigrationReader(&mockReader{log: log}, &mockReader{log: log}, "legacyBucket", &mockCopier{}, NewTombstones(bucket, "bucketName", ""), log)
+	w := NewMigrationWriter(mw, mr)
+
+	assert.EqualError(t, w.Delete(expectedUUID, "", expectedTransactionId), "S3 unavailable")
+	assert.Empty(t, mw.uuid, "the item is kept in the new bucket until its tombstone has been recorded")
+
+	_, _, _, err := mr.Get(expectedUUID, "")
+	assert.EqualError(t, err, "S3 unavailable")
+}
+
+func TestMigrationWriterDropsQueuedCopies(t *testing.T) {
+	log := logger.NewUPPLogger("migration_test", "Debug")
+	copier := &mockCopier{}
+	mr := NewMigrationReader(&mockReader{log: log}, &mockReader{payload: "LEGACY", log: log}, "legacyBucket", copier, NewTombstones(newBucketMock(nil), "bucketName", ""), log)
+	w := NewMigrationWriter(&mockWriter{writeStatus: CREATED}, mr)
+
+	mr.Get(expectedUUID, "")
+	p := []byte("PAYLOAD")
+	_, err := w.Write(expectedUUID, "", &p, expectedContentType, expectedTransactionId, false)
+	assert.NoError(t, err)
+	mr.copy(<-mr.copies)
+
+	mr.Get(expectedUUID, "")
+	assert.NoError(t, w.Delete(expectedUUID, "", expectedTransactionId))
+	mr.copy(<-mr.copies)
+	assert.Empty(t, copier.copiedUUIDs(), "copies queued before a write or delete are dropped")
+
+	mr.Get(expectedUUID, "TestDirectory")
+	mr.copy(<-mr.copies)
+	assert.Equal(t, []string{expectedUUID}, copier.copiedUUIDs())
+}
+
+// blockingCopier copies once it is released, recording the copies and writes in the order they complete.
+type blockingCopier struct {
+	started  chan struct{}
+	release  chan struct{}
+	recorder *changeRecorder
+}
+
+func (c *blockingCopier) Copy(uuid string, path string) error {
+	close(c.started)
+	<-c.release
+	c.recorder.record("copy")
+	return nil
+}
+
+type changeRecorder struct {
+	sync.Mutex
+	changes []string
+}
+
+func (r *changeRecorder) record(change string) {
+	r.Lock()
+	defer r.Unlock()
+	r.changes = append(r.changes, change)
+}
+
+type recordingWriter struct {
+	Writer
+	recorder *changeRecorder
+}
+
+func (w *recordingWriter) Write(uuid string, path string, b *[]byte, ct string, tid string, ignoreHash bool) (Status, error) {
+	w.recorder.record("write")
+	return CREATED, nil
+}
+
+func TestMigrationWriterWaitsForStartedCopies(t *testing.T) {
+	log := logger.NewUPPLogger("migration_test", "Debug")
+	recorder := &changeRecorder{}
+	copier := &blockingCopier{started: make(chan struct{}), release: make(chan struct{}), recorder: recorder}
+	mr := NewMigrationReader(&mockReader{log: log}, &mockReader{payload: "LEGACY", log: log}, "legacyBucket", copier, NewTombstones(newBucketMock(nil), "bucketName", ""), log)
+	w := NewMigrationWriter(&recordingWriter{recorder: recorder}, mr)
+
+	mr.Get(expectedUUID, "")
+	copied := make(chan struct{})
+	go func() {
+		mr.copy(<-mr.copies)
+		close(copied)
+	}()
+	<-copier.started
+
+	written := make(chan struct{})
+	go func() {
+		p := []byte("PAYLOAD")
+		w.Write(expectedUUID, "", &p, expectedContentType, expectedTransactionId, false)
+		close(written)
+	}()
+	// Gives the write the time to wait for the copy
+	time.Sleep(10 * time.Millisecond)
+	close(copier.release)
+	<-copied
+	<-written
+	assert.Equal(t, []string{"copy", "write"}, recorder.changes, "a started copy never replaces a write")
+}
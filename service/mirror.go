@@ -0,0 +1,265 @@
+package service
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/Financial-Times/go-logger/v2"
+)
+
+const (
+	MirrorSync  = "sync"
+	MirrorAsync = "async"
+
+	mirrorQueueSize        = 10000
+	mirrorRetryInterval    = 5 * time.Second
+	mirrorMaxRetryInterval = 5 * time.Minute
+)
+
+type mirrorOp struct {
+	delete      bool
+	uuid        string
+	path        string
+	body        *[]byte
+	contentType string
+	tid         string
+	enqueuedAt  time.Time
+	attempts    int
+	retryAt     time.Time
+}
+
+func (op *mirrorOp) key() string {
+	return op.path + "/" + op.uuid
+}
+
+// MirrorWriter writes to a primary Writer and replicates every successful write and delete to a mirror Writer.
+// In sync mode the mirror is written before returning, in async mode the operation is queued.
+// Failed mirror operations are queued and retried in the background until they succeed, each with its own backoff,
+// so an item which keeps failing does not hold up the rest of the queue.
+// Operations on an item are applied one at a time and in order: an operation queued behind a newer one for the same
+// item is replaced by it, as the newer write or delete supersedes it, so a retry never overwrites a newer change.
+type MirrorWriter struct {
+	primary       Writer
+	mirror        Writer
+	async         bool
+	queueSize     int
+	retryInterval time.Duration
+	log           *logger.UPPLogger
+
+	mu       sync.Mutex
+	queue    []string
+	pending  map[string]*mirrorOp
+	inFlight map[string]bool
+	notify   chan struct{}
+}
+
+func NewMirrorWriter(primary Writer, mirror Writer, mode string, log *logger.UPPLogger) (*MirrorWriter, error) {
+	if mode != MirrorSync && mode != MirrorAsync {
+		return nil, fmt.Errorf("unknown mirror mode %q", mode)
+	}
+	return &MirrorWriter{
+		primary:       primary,
+		mirror:        mirror,
+		async:         mode == MirrorAsync,
+		queueSize:     mirrorQueueSize,
+		retryInterval: mirrorRetryInterval,
+		log:           log,
+		pending:       map[string]*mirrorOp{},
+		inFlight:      map[string]bool{},
+		notify:        make(chan struct{}, 1),
+	}, nil
+}
+
+func (m *MirrorWriter) Write(uuid string, path string, b *[]byte, ct string, tid string, ignoreHash bool) (Status, error) {
+	status, err := m.primary.Write(uuid, path, b, ct, tid, ignoreHash)
+	if err != nil || (status != CREATED && status != UPDATED) {
+		return status, err
+	}
+
+	m.replicate(&mirrorOp{uuid: uuid, path: path, body: b, contentType: ct, tid: tid, enqueuedAt: time.Now()})
+	return status, nil
+}
+
+func (m *MirrorWriter) Delete(uuid string, path string, tid string) error {
+	if err := m.primary.Delete(uuid, path, tid); err != nil {
+		return err
+	}
+
+	m.replicate(&mirrorOp{delete: true, uuid: uuid, path: path, tid: tid, enqueuedAt: time.Now()})
+	return nil
+}
+
+// replicate applies an operation to the mirror in sync mode, unless an earlier operation on the item is still pending.
+func (m *MirrorWriter) replicate(op *mirrorOp) {
+	if m.async || !m.acquire(op) {
+		m.enqueue(op)
+		return
+	}
+	err := m.apply(op)
+	if err != nil {
+		m.log.WithError(err).WithTransactionID(op.tid).WithUUID(op.uuid).Warn("Failed to write to mirror bucket, queued for retry")
+	}
+	m.release(op, err)
+}
+
+// acquire marks an item as being written to the mirror, reporting false when it already is or has a queued operation.
+func (m *MirrorWriter) acquire(op *mirrorOp) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := op.key()
+	if m.inFlight[key] || m.pending[key] != nil {
+		return false
+	}
+	m.inFlight[key] = true
+	return true
+}
+
+// release marks an item as no longer being written, queueing the failed operation unless a newer one has been queued.
+func (m *MirrorWriter) release(op *mirrorOp, err error) {
+	m.mu.Lock()
+	delete(m.inFlight, op.key())
+	if err != nil {
+		op.retryAt = time.Now().Add(m.backoff(op.attempts))
+		m.push(op)
+	}
+	m.mu.Unlock()
+	m.signal()
+}
+
+// backoff returns how long to wait before retrying an operation, doubling the retry interval with every failed attempt.
+func (m *MirrorWriter) backoff(attempts int) time.Duration {
+	d := m.retryInterval
+	for i := 1; i < attempts && d < mirrorMaxRetryInterval; i++ {
+		d *= 2
+	}
+	return min(d, mirrorMaxRetryInterval)
+}
+
+func (m *MirrorWriter) apply(op *mirrorOp) error {
+	op.attempts++
+	if op.delete {
+		return m.mirror.Delete(op.uuid, op.path, op.tid)
+	}
+	_, err := m.mirror.Write(op.uuid, op.path, op.body, op.contentType, op.tid, true)
+	return err
+}
+
+func (m *MirrorWriter) enqueue(op *mirrorOp) {
+	m.mu.Lock()
+	m.replace(op)
+	m.mu.Unlock()
+	m.signal()
+}
+
+// replace queues an operation in place of any queued for the same item, which it supersedes.
+func (m *MirrorWriter) replace(op *mirrorOp) {
+	key := op.key()
+	if queued := m.pending[key]; queued != nil {
+		op.enqueuedAt = queued.enqueuedAt
+		m.pending[key] = op
+		return
+	}
+	m.add(op)
+}
+
+// push queues a failed operation for retry, dropping it when a newer operation on the item is already queued.
+func (m *MirrorWriter) push(op *mirrorOp) {
+	if m.pending[op.key()] != nil {
+		return
+	}
+	m.add(op)
+}
+
+func (m *MirrorWriter) add(op *mirrorOp) {
+	if len(m.pending) >= m.queueSize {
+		m.log.WithTransactionID(op.tid).WithUUID(op.uuid).Error("Mirror queue is full, dropping mirror operation")
+		return
+	}
+	m.queue = append(m.queue, op.key())
+	m.pending[op.key()] = op
+}
+
+func (m *MirrorWriter) signal() {
+	select {
+	case m.notify <- struct{}{}:
+	default:
+	}
+}
+
+// dequeue returns the oldest queued operation which is due on an item which is not being written, marking it as being
+// written. When there is none, it returns how long until the next queued retry is due, or zero if none is queued.
+func (m *MirrorWriter) dequeue() (*mirrorOp, time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	var wait time.Duration
+	for i, key := range m.queue {
+		if m.inFlight[key] {
+			continue
+		}
+		op := m.pending[key]
+		if d := op.retryAt.Sub(now); d > 0 {
+			if wait == 0 || d < wait {
+				wait = d
+			}
+			continue
+		}
+		m.queue = slices.Delete(m.queue, i, i+1)
+		delete(m.pending, key)
+		m.inFlight[key] = true
+		return op, 0
+	}
+	return nil, wait
+}
+
+// Start processes queued mirror operations until the stop channel is closed.
+func (m *MirrorWriter) Start(stop <-chan struct{}) {
+	for {
+		op, wait := m.dequeue()
+		if op == nil {
+			if !m.wait(wait, stop) {
+				return
+			}
+			continue
+		}
+
+		err := m.apply(op)
+		if err != nil {
+			m.log.WithError(err).WithTransactionID(op.tid).WithUUID(op.uuid).Warnf("Failed to write to mirror bucket after %d attempts", op.attempts)
+		}
+		m.release(op, err)
+	}
+}
+
+// wait blocks until an operation is queued or released, the next retry is due or the stop channel is closed, reporting
+// false when it was closed.
+func (m *MirrorWriter) wait(retry time.Duration, stop <-chan struct{}) bool {
+	var due <-chan time.Time
+	if retry > 0 {
+		timer := time.NewTimer(retry)
+		defer timer.Stop()
+		due = timer.C
+	}
+	select {
+	case <-m.notify:
+	case <-due:
+	case <-stop:
+		return false
+	}
+	return true
+}
+
+// Lag returns the number of pending mirror operations and the age of the oldest one.
+func (m *MirrorWriter) Lag() (int, time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var oldest time.Duration
+	for _, op := range m.pending {
+		if age := time.Since(op.enqueuedAt); age > oldest {
+			oldest = age
+		}
+	}
+	return len(m.pending), oldest
+}
@@ -0,0 +1,250 @@
+package service
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Financial-Times/go-logger/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestMirrorWriter(t *testing.T, mode string) (*MirrorWriter, *mockWriter, *mockWriter) {
+	log := logger.NewUPPLogger("mirror_test", "Debug")
+	primary := &mockWriter{writeStatus: CREATED}
+	mirror := &mockWriter{writeStatus: CREATED}
+	mw, err := NewMirrorWriter(primary, mirror, mode, log)
+	assert.NoError(t, err)
+	mw.retryInterval = time.Millisecond
+	return mw, primary, mirror
+}
+
+func TestNewMirrorWriterUnknownMode(t *testing.T) {
+	log := logger.NewUPPLogger("mirror_test", "Debug")
+	_, err := NewMirrorWriter(&mockWriter{}, &mockWriter{}, "sometimes", log)
+	assert.Error(t, err)
+}
+
+func TestMirrorWriterSyncWrite(t *testing.T) {
+	mw, primary, mirror := newTestMirrorWriter(t, MirrorSync)
+	p := []byte("PAYLOAD")
+
+	status, err := mw.Write(expectedUUID, "", &p, expectedContentType, expectedTransactionId, false)
+
+	assert.NoError(t, err)
+	assert.Equal(t, CREATED, status)
+	assert.Equal(t, "PAYLOAD", primary.payload)
+	assert.Equal(t, "PAYLOAD", mirror.payload)
+	assert.Equal(t, expectedUUID, mirror.uuid)
+	assert.Equal(t, expectedContentType, mirror.ct)
+	assert.Equal(t, expectedTransactionId, mirror.tid)
+	pending, _ := mw.Lag()
+	assert.Equal(t, 0, pending)
+}
+
+func TestMirrorWriterSkipsMirrorWhenPrimaryFails(t *testing.T) {
+	mw, primary, mirror := newTestMirrorWriter(t, MirrorSync)
+	primary.writeStatus = SERVICE_UNAVAILABLE
+	primary.returnError = errors.New("some S3 error")
+	p := []byte("PAYLOAD")
+
+	status, err := mw.Write(expectedUUID, "", &p, expectedContentType, expectedTransactionId, false)
+
+	assert.Error(t, err)
+	assert.Equal(t, SERVICE_UNAVAILABLE, status)
+	assert.Empty(t, mirror.uuid)
+}
+
+func TestMirrorWriterSkipsMirrorWhenUnchanged(t *testing.T) {
+	mw, primary, mirror := newTestMirrorWriter(t, MirrorSync)
+	primary.writeStatus = UNCHANGED
+	p := []byte("PAYLOAD")
+
+	status, err := mw.Write(expectedUUID, "", &p, expectedContentType, expectedTransactionId, false)
+
+	assert.NoError(t, err)
+	assert.Equal(t, UNCHANGED, status)
+	assert.Empty(t, mirror.uuid)
+}
+
+func TestMirrorWriterSyncFailureIsRetried(t *testing.T) {
+	mw, _, mirror := newTestMirrorWriter(t, MirrorSync)
+	mirror.returnError = errors.New("mirror unavailable")
+	p := []byte("PAYLOAD")
+
+	status, err := mw.Write(expectedUUID, "", &p, expectedContentType, expectedTransactionId, false)
+
+	assert.NoError(t, err)
+	assert.Equal(t, CREATED, status)
+	pending, _ := mw.Lag()
+	assert.Equal(t, 1, pending)
+
+	mirror.Lock()
+	mirror.returnError = nil
+	mirror.payload = ""
+	mirror.Unlock()
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go mw.Start(stop)
+
+	assert.Eventually(t, func() bool {
+		pending, _ := mw.Lag()
+		return pending == 0
+	}, time.Second, time.Millisecond)
+	mirror.Lock()
+	defer mirror.Unlock()
+	assert.Equal(t, "PAYLOAD", mirror.payload)
+}
+
+func TestMirrorWriterAsyncDelete(t *testing.T) {
+	mw, primary, mirror := newTestMirrorWriter(t, MirrorAsync)
+
+	err := mw.Delete(expectedUUID, "", expectedTransactionId)
+
+	assert.NoError(t, err)
+	assert.Equal(t, expectedUUID, primary.uuid)
+	pending, _ := mw.Lag()
+	assert.Equal(t, 1, pending)
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go mw.Start(stop)
+
+	assert.Eventually(t, func() bool {
+		mirror.Lock()
+		defer mirror.Unlock()
+		return mirror.uuid == expectedUUID
+	}, time.Second, time.Millisecond)
+}
+
+func TestMirrorWriterDeleteFailsOnPrimary(t *testing.T) {
+	mw, primary, mirror := newTestMirrorWriter(t, MirrorSync)
+	primary.deleteError = errors.New("some S3 error")
+
+	err := mw.Delete(expectedUUID, "", expectedTransactionId)
+
+	assert.Error(t, err)
+	assert.Empty(t, mirror.uuid)
+}
+
+// orderedMirror records the operations applied to it, failing the first failures of them.
+type orderedMirror struct {
+	sync.Mutex
+	failures int
+	applied  []string
+}
+
+func (m *orderedMirror) record(op string) error {
+	m.Lock()
+	defer m.Unlock()
+	if m.failures > 0 {
+		m.failures--
+		return errors.New("mirror unavailable")
+	}
+	m.applied = append(m.applied, op)
+	return nil
+}
+
+func (m *orderedMirror) Write(uuid string, path string, b *[]byte, ct string, tid string, ignoreHash bool) (Status, error) {
+	if err := m.record("write " + string(*b)); err != nil {
+		return SERVICE_UNAVAILABLE, err
+	}
+	return CREATED, nil
+}
+
+func (m *orderedMirror) Delete(uuid string, path string, tid string) error {
+	return m.record("delete")
+}
+
+func (m *orderedMirror) operations() []string {
+	m.Lock()
+	defer m.Unlock()
+	return append([]string(nil), m.applied...)
+}
+
+func TestMirrorWriterKeepsItemOperationsInOrder(t *testing.T) {
+	for _, mode := range []string{MirrorSync, MirrorAsync} {
+		t.Run(mode, func(t *testing.T) {
+			mirror := &orderedMirror{failures: 1}
+			mw, err := NewMirrorWriter(&mockWriter{writeStatus: UPDATED}, mirror, mode, logger.NewUPPLogger("mirror_test", "Debug"))
+			assert.NoError(t, err)
+			mw.retryInterval = time.Millisecond
+
+			first, second := []byte("first"), []byte("second")
+			_, err = mw.Write(expectedUUID, "", &first, expectedContentType, expectedTransactionId, false)
+			assert.NoError(t, err)
+			_, err = mw.Write(expectedUUID, "", &second, expectedContentType, expectedTransactionId, false)
+			assert.NoError(t, err)
+			assert.NoError(t, mw.Delete(expectedUUID, "", expectedTransactionId))
+			other := []byte("other")
+			_, err = mw.Write(otherIndexedUUID, "", &other, expectedContentType, expectedTransactionId, false)
+			assert.NoError(t, err)
+
+			stop := make(chan struct{})
+			defer close(stop)
+			go mw.Start(stop)
+
+			assert.Eventually(t, func() bool {
+				pending, _ := mw.Lag()
+				return pending == 0 && len(mirror.operations()) == 2
+			}, time.Second, time.Millisecond)
+			assert.ElementsMatch(t, []string{"delete", "write other"}, mirror.operations(), "the failed and superseded writes are never applied after the delete")
+		})
+	}
+}
+
+func TestMirrorWriterRetryDoesNotOverwriteNewerWrite(t *testing.T) {
+	mirror := &orderedMirror{failures: 1}
+	mw, err := NewMirrorWriter(&mockWriter{writeStatus: UPDATED}, mirror, MirrorSync, logger.NewUPPLogger("mirror_test", "Debug"))
+	assert.NoError(t, err)
+	mw.retryInterval = time.Millisecond
+	stop := make(chan struct{})
+	defer close(stop)
+	go mw.Start(stop)
+
+	first, second := []byte("first"), []byte("second")
+	_, err = mw.Write(expectedUUID, "", &first, expectedContentType, expectedTransactionId, false)
+	assert.NoError(t, err)
+	_, err = mw.Write(expectedUUID, "", &second, expectedContentType, expectedTransactionId, false)
+	assert.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		pending, _ := mw.Lag()
+		ops := mirror.operations()
+		return pending == 0 && len(ops) > 0 && ops[len(ops)-1] == "write second"
+	}, time.Second, time.Millisecond)
+}
+
+func TestMirrorWriterFailingItemDoesNotHoldUpQueue(t *testing.T) {
+	mirror := &orderedMirror{failures: 1}
+	mw, err := NewMirrorWriter(&mockWriter{writeStatus: UPDATED}, mirror, MirrorAsync, logger.NewUPPLogger("mirror_test", "Debug"))
+	assert.NoError(t, err)
+	mw.retryInterval = time.Hour
+
+	failing, other := []byte("failing"), []byte("other")
+	_, err = mw.Write(expectedUUID, "", &failing, expectedContentType, expectedTransactionId, false)
+	assert.NoError(t, err)
+	_, err = mw.Write(otherIndexedUUID, "", &other, expectedContentType, expectedTransactionId, false)
+	assert.NoError(t, err)
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go mw.Start(stop)
+
+	assert.Eventually(t, func() bool {
+		pending, _ := mw.Lag()
+		return pending == 1 && len(mirror.operations()) == 1
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, []string{"write other"}, mirror.operations(), "the failed write waits for its own retry")
+}
+
+func TestMirrorWriterBackoff(t *testing.T) {
+	mw, _, _ := newTestMirrorWriter(t, MirrorAsync)
+	mw.retryInterval = time.Second
+
+	assert.Equal(t, time.Second, mw.backoff(1))
+	assert.Equal(t, 4*time.Second, mw.backoff(3))
+	assert.Equal(t, mirrorMaxRetryInterval, mw.backoff(100))
+}
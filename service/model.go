@@ -1,5 +1,12 @@
 package service
 
+import "time"
+
 type obj struct {
-	UUID string `json:"ID"`
+	UUID         string     `json:"ID"`
+	LastModified *time.Time `json:"lastModified,omitempty"`
+	Size         *int64     `json:"size,omitempty"`
+	ETag         string     `json:"etag,omitempty"`
+	ContentType  string     `json:"contentType,omitempty"`
+	Hash         string     `json:"hash,omitempty"`
 }
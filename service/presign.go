@@ -0,0 +1,148 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Financial-Times/go-logger/v2"
+	transactionid "github.com/Financial-Times/transactionid-utils-go"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/gorilla/mux"
+)
+
+const (
+	presignGet = "get"
+	presignPut = "put"
+
+	defaultPresignTTL = 15 * time.Minute
+	maxPresignTTL     = 7 * 24 * time.Hour // The longest S3 accepts for signature version 4
+)
+
+// PresignedRequest is a request a client can send to S3 directly until it expires, with the signed headers it has to include.
+type PresignedRequest struct {
+	Method    string            `json:"method"`
+	URL       string            `json:"url"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	ExpiresAt time.Time         `json:"expiresAt"`
+}
+
+// Presigner creates time-limited URLs to read and write items directly in S3, for objects too large to pass through the service.
+type Presigner struct {
+	svc          s3iface.S3API
+	bucketName   string
+	bucketPrefix string
+	now          func() time.Time
+}
+
+func NewPresigner(svc s3iface.S3API, bucketName string, bucketPrefix string) *Presigner {
+	return &Presigner{svc: svc, bucketName: bucketName, bucketPrefix: bucketPrefix, now: time.Now}
+}
+
+func (p *Presigner) PresignGet(uuid string, path string, ttl time.Duration) (PresignedRequest, error) {
+	req, _ := p.svc.GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String(p.bucketName),
+		Key:    aws.String(getKey(p.bucketPrefix, path, uuid)),
+	})
+	expiresAt := p.now().Add(ttl)
+	url, err := req.Presign(ttl)
+	if err != nil {
+		return PresignedRequest{}, err
+	}
+	return PresignedRequest{Method: http.MethodGet, URL: url, ExpiresAt: expiresAt}, nil
+}
+
+// PresignPut signs an upload with the content type and transaction ID metadata the service stores with its own writes.
+// The upload is rejected by S3 unless it is sent with the returned headers.
+func (p *Presigner) PresignPut(uuid string, path string, contentType string, tid string, ttl time.Duration) (PresignedRequest, error) {
+	req, _ := p.svc.PutObjectRequest(&s3.PutObjectInput{
+		Bucket:      aws.String(p.bucketName),
+		Key:         aws.String(getKey(p.bucketPrefix, path, uuid)),
+		ContentType: aws.String(contentType),
+		Metadata:    map[string]*string{transactionid.TransactionIDKey: aws.String(tid)},
+	})
+	expiresAt := p.now().Add(ttl)
+	url, signed, err := req.PresignRequest(ttl)
+	if err != nil {
+		return PresignedRequest{}, err
+	}
+
+	headers := map[string]string{}
+	for k, v := range signed {
+		// The signer keys the headers in lower case, so they are read directly rather than with Get
+		if k = http.CanonicalHeaderKey(k); k != "Host" && len(v) > 0 {
+			headers[k] = v[0]
+		}
+	}
+	return PresignedRequest{Method: http.MethodPut, URL: url, Headers: headers, ExpiresAt: expiresAt}, nil
+}
+
+type PresignHandler struct {
+	presigner     *Presigner
+	bypassedByPut []string
+	log           *logger.UPPLogger
+}
+
+// NewPresignHandler creates a handler presigning reads, and uploads unless the resource has write side effects.
+// Uploads bypass the service, so they are refused when it mirrors, migrates, indexes, aliases or announces the items it writes.
+func NewPresignHandler(presigner *Presigner, rc ResourceConfig, log *logger.UPPLogger) PresignHandler {
+	return PresignHandler{presigner: presigner, bypassedByPut: rc.writeSideEffects(), log: log}
+}
+
+func parsePresignTTL(r *http.Request) (time.Duration, error) {
+	t := r.URL.Query().Get("ttl")
+	if t == "" {
+		return defaultPresignTTL, nil
+	}
+	seconds, err := strconv.Atoi(t)
+	if err != nil || seconds < 1 || time.Duration(seconds)*time.Second > maxPresignTTL {
+		return 0, fmt.Errorf("ttl must be between 1 and %d seconds", int(maxPresignTTL/time.Second))
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+func (ph *PresignHandler) HandlePresign(rw http.ResponseWriter, r *http.Request) {
+	tid := transactionid.GetTransactionIDFromRequest(r)
+	uuid := mux.Vars(r)["uuid"]
+	path := r.URL.Query().Get("path")
+	ttl, err := parsePresignTTL(r)
+	if err != nil {
+		respondBadRequest(err, rw)
+		return
+	}
+
+	var presigned PresignedRequest
+	switch op := r.URL.Query().Get("op"); op {
+	case presignGet:
+		presigned, err = ph.presigner.PresignGet(uuid, path, ttl)
+	case presignPut:
+		if len(ph.bypassedByPut) > 0 {
+			respondBadRequest(fmt.Errorf("op %s is not available for this resource, uploads would bypass its %s", presignPut, strings.Join(ph.bypassedByPut, ", ")), rw)
+			return
+		}
+		ct := r.URL.Query().Get("contentType")
+		if ct == "" {
+			respondBadRequest(fmt.Errorf("contentType is required to presign a %s", presignPut), rw)
+			return
+		}
+		presigned, err = ph.presigner.PresignPut(uuid, path, ct, tid, ttl)
+	default:
+		respondBadRequest(fmt.Errorf("op must be %s or %s", presignGet, presignPut), rw)
+		return
+	}
+	if err != nil {
+		readerServiceUnavailable(r.URL.RequestURI(), err, rw, tid, ph.log)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(rw).Encode(presigned); err != nil {
+		ph.log.WithError(err).WithTransactionID(tid).WithUUID(uuid).Error("Error writing presigned request")
+	}
+}
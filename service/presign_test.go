@@ -0,0 +1,122 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/Financial-Times/go-logger/v2"
+	transactionid "github.com/Financial-Times/transactionid-utils-go"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestPresigner(t *testing.T) *Presigner {
+	sess, err := session.NewSession(&aws.Config{
+		Region:      aws.String("eu-west-1"),
+		Credentials: credentials.NewStaticCredentials("AKID", "SECRET", ""),
+	})
+	assert.NoError(t, err)
+	p := NewPresigner(s3.New(sess), "testBucket", "test/prefix")
+	p.now = func() time.Time { return time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC) }
+	return p
+}
+
+func TestPresignGet(t *testing.T) {
+	req, err := newTestPresigner(t).PresignGet(expectedUUID, "", time.Minute)
+	assert.NoError(t, err)
+
+	u, err := url.Parse(req.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, http.MethodGet, req.Method)
+	assert.Equal(t, "/testBucket/test/prefix/123e4567/e89b/12d3/a456/426655440000", u.Path)
+	assert.Equal(t, "60", u.Query().Get("X-Amz-Expires"))
+	assert.Empty(t, req.Headers)
+	assert.Equal(t, time.Date(2024, 3, 1, 0, 1, 0, 0, time.UTC), req.ExpiresAt)
+}
+
+func TestPresignPutSignsContentTypeAndTransactionID(t *testing.T) {
+	req, err := newTestPresigner(t).PresignPut(expectedUUID, "", "application/json", "tid_presign", time.Hour)
+	assert.NoError(t, err)
+
+	u, err := url.Parse(req.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, http.MethodPut, req.Method)
+	assert.Equal(t, "/testBucket/test/prefix/123e4567/e89b/12d3/a456/426655440000", u.Path)
+	assert.Equal(t, "application/json", req.Headers["Content-Type"])
+	assert.Equal(t, "tid_presign", req.Headers[http.CanonicalHeaderKey("X-Amz-Meta-"+transactionid.TransactionIDKey)])
+	assert.NotContains(t, req.Headers, "Host")
+	assert.Equal(t, "content-type;host;x-amz-meta-"+transactionid.TransactionIDKey, u.Query().Get("X-Amz-SignedHeaders"))
+}
+
+func TestHandlePresign(t *testing.T) {
+	log := logger.NewUPPLogger("presign_test", "Debug")
+	tests := []struct {
+		name         string
+		query        string
+		resource     ResourceConfig
+		expectedCode int
+		method       string
+	}{
+		{name: "get", query: "?op=get&ttl=60", expectedCode: http.StatusOK, method: http.MethodGet},
+		{name: "put", query: "?op=put&contentType=application/json", expectedCode: http.StatusOK, method: http.MethodPut},
+		{name: "put without content type", query: "?op=put", expectedCode: http.StatusBadRequest},
+		{name: "put with a mirror", query: "?op=put&contentType=application/json", resource: ResourceConfig{MirrorBucketName: "mirrorBucket"}, expectedCode: http.StatusBadRequest},
+		{name: "put with change events", query: "?op=put&contentType=application/json", resource: ResourceConfig{ProducerTopic: "topic"}, expectedCode: http.StatusBadRequest},
+		{name: "get with an index", query: "?op=get", resource: ResourceConfig{IndexFields: []string{"prefLabel"}}, expectedCode: http.StatusOK, method: http.MethodGet},
+		{name: "unknown op", query: "?op=delete", expectedCode: http.StatusBadRequest},
+		{name: "ttl too long", query: "?op=get&ttl=604801", expectedCode: http.StatusBadRequest},
+		{name: "invalid ttl", query: "?op=get&ttl=soon", expectedCode: http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := mux.NewRouter()
+			PresignHandlers(r, NewPresignHandler(newTestPresigner(t), test.resource, log), ExpectedResourcePath)
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, newRequest(http.MethodPost, withExpectedResourcePath("/"+expectedUUID+"/__presign"+test.query), ""))
+
+			assert.Equal(t, test.expectedCode, rec.Code)
+			assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+			if test.expectedCode != http.StatusOK {
+				return
+			}
+			var presigned PresignedRequest
+			assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &presigned))
+			assert.Equal(t, test.method, presigned.Method)
+			assert.Contains(t, presigned.URL, "test/prefix/123e4567/e89b/12d3/a456/426655440000")
+		})
+	}
+}
+
+func TestHandlePresignRefusesPutsBypassingSideEffects(t *testing.T) {
+	log := logger.NewUPPLogger("presign_test", "Debug")
+	resources := []ResourceConfig{
+		{MirrorBucketName: "mirrorBucket"},
+		{LegacyBucketName: "legacyBucket"},
+		{IndexFields: []string{"prefLabel"}},
+		{ReferencePaths: []string{"brand.id"}},
+		{AliasesEnabled: true},
+		{ProducerTopic: "topic"},
+	}
+	for _, rc := range resources {
+		assert.Len(t, rc.writeSideEffects(), 1, "%+v", rc)
+	}
+
+	rc := ResourceConfig{ResourcePath: ExpectedResourcePath, BucketName: "testBucket", MirrorBucketName: "mirrorBucket", ProducerTopic: "topic"}
+	r := mux.NewRouter()
+	PresignHandlers(r, NewPresignHandler(newTestPresigner(t), rc, log), ExpectedResourcePath)
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, newRequest(http.MethodPost, withExpectedResourcePath("/"+expectedUUID+"/__presign?op=put&contentType=application/json"), ""))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, "{\"message\":\"op put is not available for this resource, uploads would bypass its mirror, change events\"}\n", rec.Body.String())
+
+	assert.Empty(t, ResourceConfig{ResourcePath: ExpectedResourcePath, BucketName: "testBucket"}.writeSideEffects(), "the cached count is corrected by its next recount")
+}
@@ -3,11 +3,14 @@ package service
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/Financial-Times/go-logger/v2"
 	"github.com/Financial-Times/kafka-client-go/v4"
@@ -91,9 +94,101 @@ func (r *S3QProcessor) ProcessMsg(m kafka.FTMessage) {
 
 type Reader interface {
 	Get(uuid string, path string) (bool, io.ReadCloser, *string, error)
-	Count() (int64, error)
-	Ids() (*io.PipeReader, error)
-	GetAll(path string) (*io.PipeReader, error)
+	Count(path string) (int64, error)
+	Ids(opts IdsOptions) (*io.PipeReader, error)
+	GetAll(opts GetAllOptions) (*io.PipeReader, error)
+	List(opts ListOptions) (ListPage, error)
+}
+
+// ListFilter restricts a listing to the items under a path which were last modified in a time range,
+// starting after the item with the StartAfter UUID when it is set.
+// ModifiedSince is inclusive, ModifiedBefore exclusive and zero times are unbounded.
+type ListFilter struct {
+	Path           string
+	ModifiedSince  time.Time
+	ModifiedBefore time.Time
+	StartAfter     string
+}
+
+func (f ListFilter) matches(o *s3.Object) bool {
+	if f.ModifiedSince.IsZero() && f.ModifiedBefore.IsZero() {
+		return true
+	}
+	if o.LastModified == nil {
+		return false
+	}
+	if !f.ModifiedSince.IsZero() && o.LastModified.Before(f.ModifiedSince) {
+		return false
+	}
+	return f.ModifiedBefore.IsZero() || o.LastModified.Before(f.ModifiedBefore)
+}
+
+func parseListFilter(r *http.Request) (ListFilter, error) {
+	q := r.URL.Query()
+	filter := ListFilter{Path: q.Get("path"), StartAfter: q.Get("startAfter")}
+	for param, t := range map[string]*time.Time{"modifiedSince": &filter.ModifiedSince, "modifiedBefore": &filter.ModifiedBefore} {
+		v := q.Get(param)
+		if v == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return filter, fmt.Errorf("%s must be an RFC3339 time", param)
+		}
+		*t = parsed
+	}
+	return filter, nil
+}
+
+const (
+	fieldLastModified = "lastModified"
+	fieldSize         = "size"
+	fieldETag         = "etag"
+	fieldContentType  = "contentType"
+	fieldHash         = "hash"
+)
+
+var idsFields = []string{fieldLastModified, fieldSize, fieldETag, fieldContentType, fieldHash}
+
+// IdsOptions filters the listed items and selects the object metadata listed alongside each UUID.
+type IdsOptions struct {
+	ListFilter
+	Fields []string
+	StreamOptions
+}
+
+func (o IdsOptions) has(field string) bool {
+	for _, f := range o.Fields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// needsHead reports whether the requested fields are only available from the object's metadata.
+func (o IdsOptions) needsHead() bool {
+	return o.has(fieldContentType) || o.has(fieldHash)
+}
+
+func parseIdsOptions(r *http.Request) (IdsOptions, error) {
+	var opts IdsOptions
+	var err error
+	if opts.ListFilter, err = parseListFilter(r); err != nil {
+		return opts, err
+	}
+	fields := r.URL.Query().Get("fields")
+	if fields == "" {
+		return opts, nil
+	}
+	for _, f := range strings.Split(fields, ",") {
+		f = strings.TrimSpace(f)
+		if !(IdsOptions{Fields: idsFields}).has(f) {
+			return opts, fmt.Errorf("unknown field %q, expected one of %s", f, strings.Join(idsFields, ", "))
+		}
+		opts.Fields = append(opts.Fields, f)
+	}
+	return opts, nil
 }
 
 func NewS3Reader(svc s3iface.S3API, bucketName string, bucketPrefix string, workers int16, log *logger.UPPLogger) Reader {
@@ -132,7 +227,7 @@ func (r *S3Reader) Get(uuid string, path string) (bool, io.ReadCloser, *string,
 	return true, resp.Body, resp.ContentType, err
 }
 
-func (r *S3Reader) Count() (int64, error) {
+func (r *S3Reader) Count(path string) (int64, error) {
 	cc := make(chan *s3.ListObjectsV2Output, 10)
 	rc := make(chan int64, 1)
 
@@ -140,7 +235,7 @@ func (r *S3Reader) Count() (int64, error) {
 		t := int64(0)
 		for i := range cc {
 			for _, o := range i.Contents {
-				if (!strings.HasSuffix(*o.Key, "/") && !strings.HasPrefix(*o.Key, "__")) && (*o.Key != ".") {
+				if isItemKey(*o.Key) {
 					t++
 				}
 			}
@@ -148,7 +243,7 @@ func (r *S3Reader) Count() (int64, error) {
 		rc <- t
 	}()
 
-	err := r.svc.ListObjectsV2Pages(r.getListObjectsV2Input(),
+	err := r.svc.ListObjectsV2Pages(r.getListObjectsV2Input(path),
 		func(page *s3.ListObjectsV2Output, lastPage bool) bool {
 			cc <- page
 
@@ -167,70 +262,45 @@ func (r *S3Reader) Count() (int64, error) {
 	return c, err
 }
 
-func (r *S3Reader) getListObjectsV2Input() *s3.ListObjectsV2Input {
-	if r.bucketPrefix == "" {
+// isItemKey reports whether a listed key is a stored item rather than a folder or reserved object.
+func isItemKey(key string) bool {
+	return !strings.HasSuffix(key, "/") && !strings.HasPrefix(key, "__") && key != "."
+}
+
+func (r *S3Reader) getListObjectsV2Input(path string) *s3.ListObjectsV2Input {
+	prefix := r.listPrefix(path)
+	if prefix == "" {
 		return &s3.ListObjectsV2Input{
 			Bucket: aws.String(r.bucketName),
 		}
 	}
 	return &s3.ListObjectsV2Input{
 		Bucket: aws.String(r.bucketName),
-		Prefix: aws.String(r.bucketPrefix + "/"),
+		Prefix: aws.String(prefix),
 	}
 }
 
-func (r *S3Reader) GetAll(path string) (*io.PipeReader, error) {
-	err := r.checkListOk()
-	pv, pw := io.Pipe()
-	if err != nil {
-		pv.Close()
-		return pv, err
+// filteredListInput lists the items under the filter's path, starting after the filter's StartAfter item.
+func (r *S3Reader) filteredListInput(filter ListFilter) *s3.ListObjectsV2Input {
+	input := r.getListObjectsV2Input(filter.Path)
+	if filter.StartAfter != "" {
+		input.StartAfter = aws.String(r.listPrefix(filter.Path) + strings.Replace(filter.StartAfter, "-", "/", -1))
 	}
-
-	itemSize := float32(r.workers) * 1.5
-	items := make(chan *io.ReadCloser, int(itemSize))
-	keys := make(chan *string, 3000) //  Three times the default Page size
-	go r.processItems(items, pw)
-	var wg sync.WaitGroup
-	tw := int(r.workers)
-	for w := 0; w < tw; w++ {
-		wg.Add(1)
-		go r.getItemWorker(path, &wg, keys, items)
-	}
-
-	go r.listObjects(keys)
-
-	go func(w *sync.WaitGroup, i chan *io.ReadCloser) {
-		w.Wait()
-		close(i)
-	}(&wg, items)
-
-	return pv, err
+	return input
 }
 
-func (r *S3Reader) getItemWorker(path string, wg *sync.WaitGroup, keys <-chan *string, items chan<- *io.ReadCloser) {
-	defer wg.Done()
-	for uuid := range keys {
-		if found, i, _, _ := r.Get(*uuid, path); found {
-			items <- &i
-		}
-	}
-}
-
-func (r *S3Reader) processItems(items <-chan *io.ReadCloser, pw *io.PipeWriter) {
-	for item := range items {
-		if _, err := io.Copy(pw, *item); err != nil {
-			r.log.WithError(err).Error("Error reading from S3")
-		} else {
-			io.WriteString(pw, "\n")
-		}
+// listPrefix returns the prefix of the keys stored under the given path, following getKey.
+func (r *S3Reader) listPrefix(path string) string {
+	prefix := getKey(r.bucketPrefix, path, "")
+	if prefix == "/" {
+		return ""
 	}
-	pw.Close()
+	return prefix
 }
 
-func (r *S3Reader) Ids() (*io.PipeReader, error) {
+func (r *S3Reader) Ids(opts IdsOptions) (*io.PipeReader, error) {
 
-	err := r.checkListOk()
+	err := r.checkListOk(opts.Path)
 	pv, pw := io.Pipe()
 	if err != nil {
 		pv.Close()
@@ -239,59 +309,129 @@ func (r *S3Reader) Ids() (*io.PipeReader, error) {
 
 	go func(p *io.PipeWriter) {
 
-		keys := make(chan *string, 3000) //  Three times the default Page size
-		go func(c <-chan *string, out *io.PipeWriter) {
-			encoder := json.NewEncoder(out)
-			for key := range c {
-				pl := obj{UUID: *key}
-				if err := encoder.Encode(pl); err != nil {
-					r.log.WithError(err).Error("Got error encoding key")
-					break
+		objects := make(chan *s3.Object, 3000) //  Three times the default Page size
+		ids := make(chan obj, 3000)
+		st := newStream(p, opts.StreamOptions)
+		workers := 1
+		if opts.needsHead() {
+			workers = int(r.workers)
+		}
+		var wg sync.WaitGroup
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for o := range objects {
+					if st.aborted() {
+						continue
+					}
+					id, err := r.idsEntry(o, opts)
+					if err != nil {
+						st.fail(err)
+					}
+					ids <- id
 				}
+			}()
+		}
+		go func() {
+			wg.Wait()
+			close(ids)
+		}()
+
+		go func() {
+			defer close(objects)
+			if err := r.listItemObjects(objects, opts.ListFilter, st.done); err != nil {
+				r.log.WithError(err).Error("Got an error reading content of bucket")
+				st.fail(err)
 			}
-			out.Close()
-		}(keys, p)
+		}()
 
-		err := r.listObjects(keys)
-		if err != nil {
-			r.log.WithError(err).Error("Got an error reading content of bucket")
+		// The ids are drained once the stream has been aborted, so the workers and the listing can stop
+		encoder := json.NewEncoder(st)
+		for id := range ids {
+			if st.aborted() {
+				continue
+			}
+			if err := encoder.Encode(id); err != nil {
+				r.log.WithError(err).Error("Got error encoding key")
+				st.Report.addError()
+				st.stop(err)
+				continue
+			}
+			opts.Report.addItem()
 		}
+		p.Close()
 	}(pw)
 	return pv, err
 }
 
-func (r *S3Reader) checkListOk() (err error) {
-	p := r.getListObjectsV2Input()
+// idsEntry builds the entry listing an object. When its metadata cannot be read the entry is returned without it, along with the error.
+func (r *S3Reader) idsEntry(o *s3.Object, opts IdsOptions) (obj, error) {
+	id := obj{UUID: r.keyUUID(*o.Key, opts.Path)}
+	if opts.has(fieldLastModified) {
+		id.LastModified = o.LastModified
+	}
+	if opts.has(fieldSize) {
+		id.Size = o.Size
+	}
+	if opts.has(fieldETag) {
+		id.ETag = strings.Trim(aws.StringValue(o.ETag), `"`)
+	}
+	if !opts.needsHead() {
+		return id, nil
+	}
+
+	head, err := headObject(r.svc, r.bucketName, *o.Key)
+	if err != nil {
+		r.log.WithError(err).WithUUID(id.UUID).Error("Error reading object metadata")
+		return id, err
+	}
+	if opts.has(fieldContentType) {
+		id.ContentType = aws.StringValue(head.ContentType)
+	}
+	if opts.has(fieldHash) {
+		id.Hash = aws.StringValue(head.Metadata["Current-Object-Hash"])
+	}
+	return id, nil
+}
+
+func (r *S3Reader) checkListOk(path string) (err error) {
+	p := r.getListObjectsV2Input(path)
 	p.MaxKeys = aws.Int64(1)
 	_, err = r.svc.ListObjectsV2(p)
 	return err
 }
 
-func (r *S3Reader) listObjects(keys chan<- *string) error {
-	return r.svc.ListObjectsV2Pages(r.getListObjectsV2Input(),
+// listObjects sends every listed item to keys until listing is done or the done channel is closed.
+func (r *S3Reader) listObjects(keys chan<- listedObject, filter ListFilter, done <-chan struct{}) error {
+	return r.svc.ListObjectsV2Pages(r.filteredListInput(filter),
 		func(page *s3.ListObjectsV2Output, lastPage bool) bool {
 			for _, o := range page.Contents {
-				if (!strings.HasSuffix(*o.Key, "/") && !strings.HasPrefix(*o.Key, "__")) && (*o.Key != ".") {
-					var key string
-					if r.bucketPrefix == "" {
-						key = *o.Key
-					} else {
-						k := strings.SplitAfter(*o.Key, r.bucketPrefix+"/")
-						key = k[1]
-					}
-					uuid := strings.Replace(key, "/", "-", -1)
-					keys <- &uuid
+				if isItemKey(*o.Key) && filter.matches(o) {
+					keys <- listedObject{uuid: r.keyUUID(*o.Key, filter.Path), key: *o.Key, lastModified: o.LastModified}
 				}
 			}
+			return !isDone(done)
+		})
+}
 
-			if lastPage {
-				close(keys)
+// listItemObjects sends every listed item to objects until listing is done or the done channel is closed.
+func (r *S3Reader) listItemObjects(objects chan<- *s3.Object, filter ListFilter, done <-chan struct{}) error {
+	return r.svc.ListObjectsV2Pages(r.filteredListInput(filter),
+		func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+			for _, o := range page.Contents {
+				if isItemKey(*o.Key) && filter.matches(o) {
+					objects <- o
+				}
 			}
-
-			return !lastPage
+			return !isDone(done)
 		})
 }
 
+func (r *S3Reader) keyUUID(key string, path string) string {
+	return strings.Replace(strings.TrimPrefix(key, r.listPrefix(path)), "/", "-", -1)
+}
+
 type Writer interface {
 	Write(uuid string, path string, b *[]byte, contentType string, transactionID string, ignoreHash bool) (Status, error)
 	Delete(uuid string, path string, transactionID string) error
@@ -375,40 +515,28 @@ func (w *S3Writer) Write(uuid string, path string, b *[]byte, ct string, tid str
 	return status, nil
 }
 
+// payloadHash returns the hash of a payload, stored as the Current-Object-Hash of its object.
+func payloadHash(b *[]byte) (uint64, error) {
+	return hashstructure.Hash(&b, nil)
+}
+
 func (w *S3Writer) compareObjectToStore(uuid string, path string, b *[]byte, tid string) (Status, uint64, error) {
-	objectHash, err := hashstructure.Hash(&b, nil)
+	objectHash, err := payloadHash(b)
 	if err != nil {
 		w.log.WithError(err).WithTransactionID(tid).WithUUID(uuid).Errorf("Error whilst hashing payload: %v", &b)
 		return INTERNAL_ERROR, 0, err
 	}
 
-	hoi := &s3.HeadObjectInput{
-		Bucket: aws.String(w.bucketName),
-		Key:    aws.String(getKey(w.bucketPrefix, path, uuid)),
-	}
-	hoo, err := w.svc.HeadObject(hoi)
-	if err != nil {
-		e, ok := err.(awserr.Error)
-		if ok && e.Code() == "NotFound" {
-			return CREATED, objectHash, nil
-		}
-		w.log.WithError(err).WithTransactionID(tid).WithUUID(uuid).Error("Error retrieving object metadata")
-		return SERVICE_UNAVAILABLE, 0, err
-	}
-
-	metadataMap := hoo.Metadata
-	var currentHashString string
-
-	if hash, ok := metadataMap["Current-Object-Hash"]; ok {
-		currentHashString = *hash
-	} else {
-		currentHashString = "0"
-	}
-
-	currentHash, err := strconv.ParseUint(currentHashString, 10, 64)
-	if err != nil {
+	currentHash, found, err := w.storedHash(uuid, path)
+	switch {
+	case err != nil && found:
 		w.log.WithError(err).WithTransactionID(tid).WithUUID(uuid).Error("Error whilst parsing current hash")
 		return INTERNAL_ERROR, 0, err
+	case err != nil:
+		w.log.WithError(err).WithTransactionID(tid).WithUUID(uuid).Error("Error retrieving object metadata")
+		return SERVICE_UNAVAILABLE, 0, err
+	case !found:
+		return CREATED, objectHash, nil
 	}
 	w.log.WithTransactionID(tid).WithUUID(uuid).Debugf("Concept payload has hash of: %v", objectHash)
 	w.log.WithTransactionID(tid).WithUUID(uuid).Debugf("Stored concept has hash of: %v", currentHash)
@@ -416,7 +544,30 @@ func (w *S3Writer) compareObjectToStore(uuid string, path string, b *[]byte, tid
 		w.log.WithTransactionID(tid).WithUUID(uuid).Debug("Concept is different to the stored record")
 		return UPDATED, objectHash, nil
 	}
-	return UNCHANGED, 0, nil
+	return UNCHANGED, objectHash, nil
+}
+
+// storedHash returns the Current-Object-Hash of a stored item, reporting whether it exists. Items stored without one
+// have a hash of 0.
+func (w *S3Writer) storedHash(uuid string, path string) (uint64, bool, error) {
+	hoo, err := w.svc.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(w.bucketName),
+		Key:    aws.String(getKey(w.bucketPrefix, path, uuid)),
+	})
+	if err != nil {
+		e, ok := err.(awserr.Error)
+		if ok && e.Code() == "NotFound" {
+			return 0, false, nil
+		}
+		return 0, false, err
+	}
+
+	hash, ok := hoo.Metadata["Current-Object-Hash"]
+	if !ok {
+		return 0, true, nil
+	}
+	currentHash, err := strconv.ParseUint(*hash, 10, 64)
+	return currentHash, true, err
 }
 
 type WriterHandler struct {
@@ -507,9 +658,39 @@ type ReaderHandler struct {
 	log    *logger.UPPLogger
 }
 
+// serveHooker is implemented by readers which serve from multiple buckets, and by the decorators wrapping them.
+// withServeHook returns a copy of the reader which reports the bucket serving each request to the given function.
+type serveHooker interface {
+	withServeHook(onServe func(bucket string)) Reader
+}
+
+// hookServe returns a copy of a reader which reports the bucket serving each request, or the reader itself when it
+// serves from a single bucket. Decorators use it to hook the reader they wrap.
+func hookServe(reader Reader, onServe func(bucket string)) Reader {
+	if h, ok := reader.(serveHooker); ok {
+		return h.withServeHook(onServe)
+	}
+	return reader
+}
+
+// requestReader returns a reader which, when able to serve from multiple buckets, reports the serving bucket as a response header.
+func (rh *ReaderHandler) requestReader(rw http.ResponseWriter) Reader {
+	return hookServe(rh.reader, func(bucket string) {
+		rw.Header().Set(servedByBucketHeader, bucket)
+	})
+}
+
 func (rh *ReaderHandler) HandleIds(rw http.ResponseWriter, r *http.Request) {
 	tid := transactionid.GetTransactionIDFromRequest(r)
-	pv, err := rh.reader.Ids()
+	opts, err := parseIdsOptions(r)
+	if err != nil {
+		respondBadRequest(err, rw)
+		return
+	}
+
+	opts.StreamOptions = parseStreamOptions(r)
+
+	pv, err := rh.requestReader(rw).Ids(opts)
 	defer pv.Close()
 	if err != nil {
 		readerServiceUnavailable(r.URL.RequestURI(), err, rw, tid, rh.log)
@@ -517,17 +698,33 @@ func (rh *ReaderHandler) HandleIds(rw http.ResponseWriter, r *http.Request) {
 	}
 
 	rw.Header().Set("Content-Type", "application/octet-stream")
-	rw.WriteHeader(http.StatusOK)
-	io.Copy(rw, pv)
+	copyStream(rw, pv, opts.Report, tid, rh.log)
 }
 
 func (rh *ReaderHandler) HandleCount(rw http.ResponseWriter, r *http.Request) {
 	tid := transactionid.GetTransactionIDFromRequest(r)
-	i, err := rh.reader.Count()
+	path := r.URL.Query().Get("path")
+	reader := rh.requestReader(rw)
+
+	var i int64
+	var age time.Duration
+	var err error
+	cc, cached := reader.(cachedCounter)
+	cached = cached && path == ""
+	if cached {
+		i, age, err = cc.CachedCount(r.URL.Query().Get("fresh") == "true")
+		cached = !errors.Is(err, errCountNotCached)
+	}
+	if !cached {
+		i, err = reader.Count(path)
+	}
 	if err != nil {
 		readerServiceUnavailable("", err, rw, tid, rh.log)
 		return
 	}
+	if cached {
+		rw.Header().Set("Age", strconv.FormatInt(int64(age/time.Second), 10))
+	}
 	rh.log.WithTransactionID(tid).Infof("Got a count back of '%v'", i)
 	rw.Header().Set("Content-Type", "application/json")
 	rw.WriteHeader(http.StatusOK)
@@ -537,26 +734,84 @@ func (rh *ReaderHandler) HandleCount(rw http.ResponseWriter, r *http.Request) {
 	rw.Write(b)
 }
 
-func (rh *ReaderHandler) HandleGetAll(rw http.ResponseWriter, r *http.Request) {
+func (rh *ReaderHandler) HandleList(rw http.ResponseWriter, r *http.Request) {
 	tid := transactionid.GetTransactionIDFromRequest(r)
-	path := r.URL.Query().Get("path")
-	pv, err := rh.reader.GetAll(path)
+	opts, err := parseListOptions(r)
+	if err != nil {
+		respondBadRequest(err, rw)
+		return
+	}
 
+	page, err := rh.requestReader(rw).List(opts)
+	if errors.Is(err, errInvalidCursor) {
+		respondBadRequest(err, rw)
+		return
+	}
 	if err != nil {
 		readerServiceUnavailable(r.URL.RequestURI(), err, rw, tid, rh.log)
 		return
 	}
 
-	rw.Header().Set("Content-Type", "application/octet-stream")
+	rw.Header().Set("Content-Type", "application/json")
 	rw.WriteHeader(http.StatusOK)
-	io.Copy(rw, pv)
+	if err := json.NewEncoder(rw).Encode(page); err != nil {
+		rh.log.WithError(err).WithTransactionID(tid).Error("Error writing list page")
+	}
+}
+
+func (rh *ReaderHandler) HandleGetAll(rw http.ResponseWriter, r *http.Request) {
+	tid := transactionid.GetTransactionIDFromRequest(r)
+	opts, err := parseGetAllOptions(r)
+	if err != nil {
+		respondBadRequest(err, rw)
+		return
+	}
+
+	pv, err := rh.requestReader(rw).GetAll(opts)
+	if err != nil {
+		readerServiceUnavailable(r.URL.RequestURI(), err, rw, tid, rh.log)
+		return
+	}
+	defer pv.Close()
+
+	rw.Header().Set("Content-Type", getAllContentType(opts.Format))
+	copyStream(rw, pv, opts.Report, tid, rh.log)
+}
+
+func (rh *ReaderHandler) HandleExport(rw http.ResponseWriter, r *http.Request) {
+	tid := transactionid.GetTransactionIDFromRequest(r)
+	opts, err := parseExportOptions(r)
+	if err != nil {
+		respondBadRequest(err, rw)
+		return
+	}
+
+	pv, err := rh.requestReader(rw).GetAll(opts)
+	if err != nil {
+		readerServiceUnavailable(r.URL.RequestURI(), err, rw, tid, rh.log)
+		return
+	}
+	defer pv.Close()
+
+	rw.Header().Set("Content-Type", getAllContentType(opts.Format))
+	rw.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="export.%s"`, opts.Format))
+	copyStream(rw, pv, opts.Report, tid, rh.log)
 }
 
 func (rh *ReaderHandler) HandleGet(rw http.ResponseWriter, r *http.Request) {
 	tid := transactionid.GetTransactionIDFromRequest(r)
 	path := r.URL.Query().Get("path")
 	uuid := uuid(r.URL.Path)
-	f, i, ct, err := rh.reader.Get(uuid, path)
+	fields, err := parseProjection(r)
+	if err != nil {
+		respondBadRequest(err, rw)
+		return
+	}
+	reader := rh.requestReader(rw)
+	f, i, ct, err := reader.Get(uuid, path)
+	if err == nil && !f {
+		f, i, ct, err = getAlias(rw, r, reader, uuid, path)
+	}
 	if err != nil {
 		readerServiceUnavailable(r.URL.RequestURI(), err, rw, tid, rh.log)
 		return
@@ -582,7 +837,7 @@ func (rh *ReaderHandler) HandleGet(rw http.ResponseWriter, r *http.Request) {
 	}
 
 	rw.WriteHeader(http.StatusOK)
-	rw.Write(b)
+	rw.Write(fields.apply(b))
 }
 
 func uuid(path string) string {
@@ -601,6 +856,12 @@ func respondServiceUnavailable(err error, rw http.ResponseWriter, tid string, lo
 	rw.Write([]byte("{\"message\":\"Service currently unavailable\"}"))
 }
 
+func respondBadRequest(err error, rw http.ResponseWriter) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(rw).Encode(map[string]string{"message": err.Error()})
+}
+
 func writerServiceUnavailable(uuid string, err error, rw http.ResponseWriter, tid string, log *logger.UPPLogger) {
 	log.WithError(err).WithTransactionID(tid).WithUUID(uuid).Error("Error writing object")
 	respondServiceUnavailable(err, rw, tid, log)
@@ -8,10 +8,12 @@ import (
 	"io"
 	"math/rand"
 	"net/http/httptest"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/Financial-Times/go-logger/v2"
 	"github.com/Financial-Times/kafka-client-go/v4"
@@ -45,9 +47,13 @@ type mockS3Client struct {
 	deleteObjectOutput   *s3.DeleteObjectOutput
 	listObjectsV2Outputs []*s3.ListObjectsV2Output
 	listObjectsV2Input   []*s3.ListObjectsV2Input
+	copyObjectInput      *s3.CopyObjectInput
+	copyObjectInputs     []*s3.CopyObjectInput
 	count                int
 	getObjectCount       int
 	payload              string
+	payloads             map[string]string
+	getObjectErrors      map[string]error
 	ct                   string
 	log                  *logger.UPPLogger
 }
@@ -93,13 +99,28 @@ func (m *mockS3Client) DeleteObject(doi *s3.DeleteObjectInput) (*s3.DeleteObject
 	return m.deleteObjectOutput, m.s3error
 }
 
+func (m *mockS3Client) CopyObject(coi *s3.CopyObjectInput) (*s3.CopyObjectOutput, error) {
+	m.Lock()
+	defer m.Unlock()
+	m.log.Infof("Copy params: %v", coi)
+	m.copyObjectInput = coi
+	m.copyObjectInputs = append(m.copyObjectInputs, coi)
+	return &s3.CopyObjectOutput{}, m.s3error
+}
+
 func (m *mockS3Client) GetObject(goi *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
 	m.Lock()
 	defer m.Unlock()
 	m.log.Infof("Get params: %v", goi)
 	m.getObjectInput = goi
 	payload := m.payload + strconv.Itoa(m.getObjectCount)
+	if p, ok := m.payloads[*goi.Key]; ok {
+		payload = p
+	}
 	m.getObjectCount++
+	if err, ok := m.getObjectErrors[*goi.Key]; ok {
+		return nil, err
+	}
 	return &s3.GetObjectOutput{
 		Body:        io.NopCloser(strings.NewReader(payload)),
 		ContentType: aws.String(m.ct),
@@ -120,16 +141,23 @@ func (m *mockS3Client) ListObjectsV2(loi *s3.ListObjectsV2Input) (*s3.ListObject
 }
 func (m *mockS3Client) ListObjectsV2Pages(loi *s3.ListObjectsV2Input, fn func(p *s3.ListObjectsV2Output, lastPage bool) (shouldContinue bool)) error {
 	m.Lock()
-	defer m.Unlock()
 	m.log.Debugf("Get ListObjectsV2Pages: %v", loi)
 	m.listObjectsV2Input = append(m.listObjectsV2Input, loi)
+	var pages []*s3.ListObjectsV2Output
+	if m.count < len(m.listObjectsV2Outputs) {
+		pages = m.listObjectsV2Outputs[m.count:]
+	}
+	s3error := m.s3error
+	m.Unlock()
 
-	for i := m.count; i < len(m.listObjectsV2Outputs); i++ {
-		lastPage := i == (len(m.listObjectsV2Outputs) - 1)
-		fn(m.listObjectsV2Outputs[i], lastPage)
+	for i, page := range pages {
+		lastPage := i == (len(pages) - 1)
+		if !fn(page, lastPage) {
+			break
+		}
 	}
 
-	return m.s3error
+	return s3error
 }
 
 func TestGetKey(t *testing.T) {
@@ -350,6 +378,7 @@ func TestWritingToS3WithOnlyUpdatesAllowed_ObjectWrittenForObjectWithExistingHas
 	assert.Equal(t, "testBucket", *s.putObjectInput.Bucket)
 	assert.Equal(t, expectedContentType, *s.putObjectInput.ContentType)
 	assert.Equal(t, UPDATED, writeStatus, "Object should have existed prior to write with hash metadata but still should be updated")
+	assert.Equal(t, existingHashString, *s.putObjectInput.Metadata["Current-Object-Hash"], "the hash of a rewritten object is kept")
 
 	rs := s.putObjectInput.Body
 	assert.NotNil(t, rs)
@@ -487,7 +516,7 @@ func TestGetCountFromS3(t *testing.T) {
 		&lo1,
 		&lo2,
 	}
-	i, err := r.Count()
+	i, err := r.Count("")
 	assert.NoError(t, err)
 	assert.Equal(t, int64(101), i)
 }
@@ -523,18 +552,29 @@ func TestGetCountFromS3WithoutPrefix(t *testing.T) {
 		&lo1,
 		&lo2,
 	}
-	i, err := r.Count()
+	i, err := r.Count("")
 	assert.NoError(t, err)
 	assert.Equal(t, int64(101), i)
 	assert.NotEmpty(t, s.listObjectsV2Input)
 	assert.Nil(t, s.listObjectsV2Input[0].Prefix)
 }
 
+func TestGetCountFromS3WithPath(t *testing.T) {
+	log := logger.NewUPPLogger("processor_test", "Debug")
+	r, s := getReaderNoPrefix(log)
+	lo1 := generateKeys(3, false)
+	s.listObjectsV2Outputs = []*s3.ListObjectsV2Output{&lo1}
+	i, err := r.Count("TestDirectory")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), i)
+	assert.Equal(t, "TestDirectory/", *s.listObjectsV2Input[0].Prefix)
+}
+
 func TestGetCountFromS3Errors(t *testing.T) {
 	log := logger.NewUPPLogger("processor_test", "Debug")
 	r, s := getReader(log)
 	s.s3error = errors.New("Some error")
-	_, err := r.Count()
+	_, err := r.Count("")
 	assert.Error(t, err)
 	assert.Equal(t, s.s3error, err)
 }
@@ -551,7 +591,7 @@ func BenchmarkS3Reader_Count(b *testing.B) {
 			s.listObjectsV2Outputs = append(s.listObjectsV2Outputs, &lo)
 		}
 		b.StartTimer()
-		i, err := r.Count()
+		i, err := r.Count("")
 		assert.NoError(b, err)
 		assert.Equal(b, int64(t*t), i)
 	}
@@ -627,7 +667,7 @@ func TestGetIdsFromS3(t *testing.T) {
 			},
 		},
 	}
-	p, err := r.Ids()
+	p, err := r.Ids(IdsOptions{})
 	assert.NoError(t, err)
 	payload, err := io.ReadAll(p)
 	assert.NoError(t, err)
@@ -670,7 +710,7 @@ func TestGetIdsFromS3NoPrefix(t *testing.T) {
 			},
 		},
 	}
-	p, err := r.Ids()
+	p, err := r.Ids(IdsOptions{})
 	assert.NoError(t, err)
 	payload, err := io.ReadAll(p)
 	assert.NoError(t, err)
@@ -687,11 +727,147 @@ func TestGetIdsFromS3NoPrefix(t *testing.T) {
 `, string(payload[:]))
 }
 
+func TestGetIdsFromS3WithListedFields(t *testing.T) {
+	log := logger.NewUPPLogger("processor_test", "Debug")
+	r, s := getReader(log)
+	modified := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	s.listObjectsV2Outputs = []*s3.ListObjectsV2Output{
+		{KeyCount: aws.Int64(1)},
+		{
+			KeyCount: aws.Int64(1),
+			Contents: []*s3.Object{
+				{Key: aws.String("test/prefix/123e4567/e89b/12d3/a456/426655440001"), LastModified: &modified, Size: aws.Int64(0), ETag: aws.String(`"abc"`)},
+			},
+		},
+	}
+	p, err := r.Ids(IdsOptions{Fields: []string{"lastModified", "size", "etag"}})
+	assert.NoError(t, err)
+	payload, err := io.ReadAll(p)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"ID":"123e4567-e89b-12d3-a456-426655440001","lastModified":"2024-03-01T12:00:00Z","size":0,"etag":"abc"}
+`, string(payload))
+	assert.Nil(t, s.headObjectInput)
+}
+
+func TestGetIdsFromS3WithMetadataFields(t *testing.T) {
+	log := logger.NewUPPLogger("processor_test", "Debug")
+	r, s := getReaderWithMultipleWorkers(log)
+	s.headObjectOutput = &s3.HeadObjectOutput{
+		ContentType: aws.String("application/json"),
+		Metadata:    map[string]*string{"Current-Object-Hash": aws.String("1234")},
+	}
+	s.listObjectsV2Outputs = []*s3.ListObjectsV2Output{
+		{KeyCount: aws.Int64(1)},
+		{
+			KeyCount: aws.Int64(2),
+			Contents: []*s3.Object{
+				{Key: aws.String("test/prefix/123e4567/e89b/12d3/a456/426655440001"), Size: aws.Int64(10)},
+				{Key: aws.String("test/prefix/123e4567/e89b/12d3/a456/426655440002"), Size: aws.Int64(20)},
+			},
+		},
+	}
+	p, err := r.Ids(IdsOptions{Fields: []string{"contentType", "hash"}})
+	assert.NoError(t, err)
+	payload, err := io.ReadAll(p)
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(payload)), "\n")
+	sort.Strings(lines)
+	assert.Equal(t, []string{
+		`{"ID":"123e4567-e89b-12d3-a456-426655440001","contentType":"application/json","hash":"1234"}`,
+		`{"ID":"123e4567-e89b-12d3-a456-426655440002","contentType":"application/json","hash":"1234"}`,
+	}, lines)
+}
+
+func TestGetIdsFromS3ReportsMetadataErrors(t *testing.T) {
+	log := logger.NewUPPLogger("processor_test", "Debug")
+	for _, failFast := range []bool{false, true} {
+		r, s := getReader(log)
+		s.headObjectOutput = &s3.HeadObjectOutput{}
+		s.notFoundError = errors.New("head failed")
+		s.listObjectsV2Outputs = []*s3.ListObjectsV2Output{
+			{KeyCount: aws.Int64(1)},
+			{
+				KeyCount: aws.Int64(2),
+				Contents: []*s3.Object{
+					{Key: aws.String("test/prefix/123e4567/e89b/12d3/a456/426655440001")},
+					{Key: aws.String("test/prefix/123e4567/e89b/12d3/a456/426655440002")},
+				},
+			},
+		}
+		report := &StreamReport{}
+		p, err := r.Ids(IdsOptions{Fields: []string{"hash"}, StreamOptions: StreamOptions{FailFast: failFast, Report: report}})
+		assert.NoError(t, err)
+		payload, err := io.ReadAll(p)
+		if failFast {
+			assert.EqualError(t, err, "head failed")
+			assert.Empty(t, payload)
+			assert.Equal(t, int64(1), report.Errors())
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, "{\"ID\":\"123e4567-e89b-12d3-a456-426655440001\"}\n{\"ID\":\"123e4567-e89b-12d3-a456-426655440002\"}\n", string(payload))
+		assert.Equal(t, int64(2), report.Items())
+		assert.Equal(t, int64(2), report.Errors())
+	}
+}
+
+func TestGetIdsFromS3WithPath(t *testing.T) {
+	log := logger.NewUPPLogger("processor_test", "Debug")
+	r, s := getReaderNoPrefix(log)
+	s.listObjectsV2Outputs = []*s3.ListObjectsV2Output{
+		{KeyCount: aws.Int64(1)},
+		{
+			KeyCount: aws.Int64(2),
+			Contents: []*s3.Object{
+				{Key: aws.String("TestDirectory/123e4567/e89b/12d3/a456/426655440001")},
+				{Key: aws.String("TestDirectory/123e4567/e89b/12d3/a456/426655440002")},
+			},
+		},
+	}
+	p, err := r.Ids(IdsOptions{ListFilter: ListFilter{Path: "TestDirectory"}})
+	assert.NoError(t, err)
+	payload, err := io.ReadAll(p)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"ID":"123e4567-e89b-12d3-a456-426655440001"}
+{"ID":"123e4567-e89b-12d3-a456-426655440002"}
+`, string(payload))
+	for _, loi := range s.listObjectsV2Input {
+		assert.Equal(t, "TestDirectory/", *loi.Prefix)
+	}
+}
+
+func TestGetIdsFromS3ModifiedBetween(t *testing.T) {
+	log := logger.NewUPPLogger("processor_test", "Debug")
+	r, s := getReader(log)
+	day := func(d int) *time.Time {
+		t := time.Date(2024, 3, d, 0, 0, 0, 0, time.UTC)
+		return &t
+	}
+	s.listObjectsV2Outputs = []*s3.ListObjectsV2Output{
+		{KeyCount: aws.Int64(1)},
+		{
+			KeyCount: aws.Int64(4),
+			Contents: []*s3.Object{
+				{Key: aws.String("test/prefix/123e4567/e89b/12d3/a456/426655440001"), LastModified: day(1)},
+				{Key: aws.String("test/prefix/123e4567/e89b/12d3/a456/426655440002"), LastModified: day(2)},
+				{Key: aws.String("test/prefix/123e4567/e89b/12d3/a456/426655440003"), LastModified: day(3)},
+				{Key: aws.String("test/prefix/123e4567/e89b/12d3/a456/426655440004")},
+			},
+		},
+	}
+	p, err := r.Ids(IdsOptions{ListFilter: ListFilter{ModifiedSince: *day(2), ModifiedBefore: *day(3)}})
+	assert.NoError(t, err)
+	payload, err := io.ReadAll(p)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"ID":"123e4567-e89b-12d3-a456-426655440002"}
+`, string(payload))
+}
+
 func TestGetIdsFromS3Fails(t *testing.T) {
 	log := logger.NewUPPLogger("processor_test", "Debug")
 	r, s := getReader(log)
 	s.s3error = errors.New("Some error")
-	_, err := r.Ids()
+	_, err := r.Ids(IdsOptions{})
 	assert.Error(t, err)
 	assert.Equal(t, s.s3error, err)
 }
@@ -726,7 +902,7 @@ func TestReaderHandler_HandleGetAllOK(t *testing.T) {
 			},
 		},
 	}
-	p, err := r.GetAll("")
+	p, err := r.GetAll(GetAllOptions{Raw: true})
 	assert.NoError(t, err)
 	payload, err := io.ReadAll(p)
 	assert.NoError(t, err)
@@ -773,7 +949,7 @@ func TestReaderHandler_HandleGetAllOKSpecificDirectory(t *testing.T) {
 			},
 		},
 	}
-	p, err := r.GetAll("testDirectory")
+	p, err := r.GetAll(GetAllOptions{ListFilter: ListFilter{Path: "testDirectory"}, Raw: true})
 	assert.NoError(t, err)
 	payload, err := io.ReadAll(p)
 	assert.NoError(t, err)
@@ -803,13 +979,90 @@ func TestReaderHandler_HandleGetAllOKWithLotsOfWorkers(t *testing.T) {
 		getListObjectsV2Output(5, 20),
 		getListObjectsV2Output(5, 25),
 	}
-	p, err := r.GetAll("")
+	p, err := r.GetAll(GetAllOptions{Raw: true})
 	assert.NoError(t, err)
 	payload, err := io.ReadAll(p)
 	assert.NoError(t, err)
 	assert.Equal(t, 25, strings.Count(string(payload[:]), "PAYLOAD"))
 }
 
+func TestS3Reader_GetAllModifiedSince(t *testing.T) {
+	log := logger.NewUPPLogger("processor_test", "Debug")
+	r, s := getReader(log)
+	s.payload = "PAYLOAD"
+	since := time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC)
+	s.listObjectsV2Outputs = []*s3.ListObjectsV2Output{
+		{KeyCount: aws.Int64(1)},
+		{
+			KeyCount: aws.Int64(3),
+			Contents: []*s3.Object{
+				{Key: aws.String("test/prefix/UUID-1"), LastModified: aws.Time(since.Add(-time.Second))},
+				{Key: aws.String("test/prefix/UUID-2"), LastModified: aws.Time(since)},
+				{Key: aws.String("test/prefix/UUID-3"), LastModified: aws.Time(since.Add(time.Hour))},
+			},
+		},
+	}
+	p, err := r.GetAll(GetAllOptions{ListFilter: ListFilter{ModifiedSince: since}, Raw: true})
+	assert.NoError(t, err)
+	payload, err := io.ReadAll(p)
+	assert.NoError(t, err)
+	assert.Equal(t, "PAYLOAD0\nPAYLOAD1\n", string(payload))
+}
+
+func TestS3Reader_GetAllStreamsInKeyOrderWithCheckpoints(t *testing.T) {
+	log := logger.NewUPPLogger("processor_test", "Debug")
+	r, s := getReaderWithMultipleWorkers(log)
+	s.payloads = map[string]string{}
+	var contents []*s3.Object
+	var expected strings.Builder
+	for i := 1; i <= 30; i++ {
+		uuid := fmt.Sprintf("123e4567-e89b-12d3-a456-%012d", i)
+		key := getKey("test/prefix", "", uuid)
+		contents = append(contents, &s3.Object{Key: aws.String(key)})
+		s.payloads[key] = fmt.Sprintf(`{"uuid":"%s"}`, uuid)
+		expected.WriteString(s.payloads[key] + "\n")
+		if i%10 == 0 {
+			expected.WriteString(fmt.Sprintf(`{"checkpoint":"%s"}`, uuid) + "\n")
+		}
+	}
+	s.listObjectsV2Outputs = []*s3.ListObjectsV2Output{
+		{KeyCount: aws.Int64(1)},
+		{KeyCount: aws.Int64(30), Contents: contents},
+	}
+
+	p, err := r.GetAll(GetAllOptions{CheckpointEvery: 10})
+	assert.NoError(t, err)
+	payload, err := io.ReadAll(p)
+	assert.NoError(t, err)
+	assert.Equal(t, expected.String(), string(payload))
+}
+
+func TestS3Reader_GetAllStartAfter(t *testing.T) {
+	log := logger.NewUPPLogger("processor_test", "Debug")
+	tests := []struct {
+		name       string
+		prefix     string
+		path       string
+		startAfter string
+	}{
+		{name: "prefix", prefix: "test/prefix", startAfter: "test/prefix/123e4567/e89b/12d3/a456/426655440000"},
+		{name: "path", path: "TestDirectory", startAfter: "TestDirectory/123e4567/e89b/12d3/a456/426655440000"},
+		{name: "no prefix", startAfter: "123e4567/e89b/12d3/a456/426655440000"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := &mockS3Client{log: log, listObjectsV2Outputs: []*s3.ListObjectsV2Output{{KeyCount: aws.Int64(1)}, {}}}
+			r := NewS3Reader(s, "testBucket", test.prefix, 1, log)
+			p, err := r.GetAll(GetAllOptions{ListFilter: ListFilter{Path: test.path, StartAfter: expectedUUID}})
+			assert.NoError(t, err)
+			_, err = io.ReadAll(p)
+			assert.NoError(t, err)
+			assert.Equal(t, test.startAfter, *s.listObjectsV2Input[1].StartAfter)
+		})
+	}
+}
+
 func getListObjectsV2Output(keyCount int64, start int) *s3.ListObjectsV2Output {
 	contents := []*s3.Object{}
 	for i := start; i < start+int(keyCount); i++ {
@@ -825,7 +1078,7 @@ func TestS3Reader_GetAllFails(t *testing.T) {
 	log := logger.NewUPPLogger("processor_test", "Debug")
 	r, s := getReader(log)
 	s.s3error = errors.New("Some error")
-	_, err := r.GetAll("")
+	_, err := r.GetAll(GetAllOptions{})
 	assert.Error(t, err)
 	assert.Equal(t, s.s3error, err)
 }
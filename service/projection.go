@@ -0,0 +1,96 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// projection is the fields of JSON payloads sent to clients, each a path of keys such as prefLabel or type.id.
+type projection [][]string
+
+func parseProjection(r *http.Request) (projection, error) {
+	fields := r.URL.Query().Get("fields")
+	if fields == "" {
+		return nil, nil
+	}
+	var p projection
+	for _, f := range strings.Split(fields, ",") {
+		path := strings.Split(strings.TrimSpace(f), ".")
+		for _, key := range path {
+			if key == "" {
+				return nil, fmt.Errorf("invalid field %q", f)
+			}
+		}
+		p = append(p, path)
+	}
+	return p, nil
+}
+
+// apply returns the projected fields of a JSON object payload. Fields within arrays are projected from each of their
+// elements. Payloads which are not JSON objects cannot be projected and are returned unchanged.
+func (p projection) apply(body []byte) []byte {
+	if len(p) == 0 {
+		return body
+	}
+	d := json.NewDecoder(bytes.NewReader(body))
+	d.UseNumber()
+	var src map[string]interface{}
+	if err := d.Decode(&src); err != nil || src == nil || d.More() {
+		return body
+	}
+
+	dst := map[string]interface{}{}
+	for _, path := range p {
+		project(src, dst, path)
+	}
+
+	var b bytes.Buffer
+	e := json.NewEncoder(&b)
+	e.SetEscapeHTML(false)
+	if err := e.Encode(dst); err != nil {
+		return body
+	}
+	return bytes.TrimSuffix(b.Bytes(), []byte("\n"))
+}
+
+// project copies the value at a path of src into dst, creating the objects and arrays leading to it.
+func project(src map[string]interface{}, dst map[string]interface{}, path []string) {
+	v, ok := src[path[0]]
+	if !ok {
+		return
+	}
+	if len(path) == 1 {
+		dst[path[0]] = v
+		return
+	}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		child, _ := dst[path[0]].(map[string]interface{})
+		if child == nil {
+			child = map[string]interface{}{}
+		}
+		project(v, child, path[1:])
+		dst[path[0]] = child
+	case []interface{}:
+		children, _ := dst[path[0]].([]interface{})
+		if children == nil {
+			children = make([]interface{}, len(v))
+		}
+		for i, e := range v {
+			o, ok := e.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			child, _ := children[i].(map[string]interface{})
+			if child == nil {
+				child = map[string]interface{}{}
+			}
+			project(o, child, path[1:])
+			children[i] = child
+		}
+		dst[path[0]] = children
+	}
+}
@@ -0,0 +1,51 @@
+package service
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseProjection(t *testing.T) {
+	p, err := parseProjection(httptest.NewRequest("GET", "/?fields=prefLabel,%20type.id", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, projection{{"prefLabel"}, {"type", "id"}}, p)
+
+	p, err = parseProjection(httptest.NewRequest("GET", "/", nil))
+	assert.NoError(t, err)
+	assert.Nil(t, p)
+
+	_, err = parseProjection(httptest.NewRequest("GET", "/?fields=type..id", nil))
+	assert.EqualError(t, err, `invalid field "type..id"`)
+}
+
+func TestProjectionApply(t *testing.T) {
+	body := `{
+		"uuid": "2136f8ad-e94e-45cb-b616-336f38533214",
+		"prefLabel": "Pricing & <offers>",
+		"type": {"id": "http://www.ft.com/ontology/Brand", "label": "Brand"},
+		"aliases": [{"value": "FT", "lang": "en"}, "bare", {"lang": "fr"}],
+		"score": 12345678901234567890
+	}`
+	tests := []struct {
+		name     string
+		fields   projection
+		body     string
+		expected string
+	}{
+		{name: "no fields", body: body, expected: body},
+		{name: "top level", fields: projection{{"prefLabel"}, {"score"}}, body: body, expected: `{"prefLabel":"Pricing & <offers>","score":12345678901234567890}`},
+		{name: "nested", fields: projection{{"type", "id"}, {"uuid"}}, body: body, expected: `{"type":{"id":"http://www.ft.com/ontology/Brand"},"uuid":"2136f8ad-e94e-45cb-b616-336f38533214"}`},
+		{name: "within arrays", fields: projection{{"aliases", "value"}}, body: body, expected: `{"aliases":[{"value":"FT"},null,{}]}`},
+		{name: "missing", fields: projection{{"broader", "id"}, {"type", "missing"}}, body: body, expected: `{"type":{}}`},
+		{name: "not an object", fields: projection{{"id"}}, body: `[{"id": 1}]`, expected: `[{"id": 1}]`},
+		{name: "not json", fields: projection{{"id"}}, body: "<concept/>", expected: "<concept/>"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, string(test.fields.apply([]byte(test.body))))
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/Financial-Times/go-logger/v2"
+	transactionid "github.com/Financial-Times/transactionid-utils-go"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/gorilla/mux"
+)
+
+const referencesPrefix = "__references/"
+
+// referencePattern matches UUIDs anywhere in a value, such as in the URI of a concept.
+var referencePattern = regexp.MustCompile(`[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}`)
+
+// References is a reverse index of the UUIDs the items' payloads refer to at configured JSON paths, so the items
+// referring to a UUID can be found. It is stored under the reserved __references/ prefix: an empty object per referenced
+// and referring UUID, __references/<scope>/v/<referenced>/<referrer>, and a record of the UUIDs each item refers to,
+// __references/<scope>/k/<referrer>.
+type References struct {
+	valueIndex
+	paths [][]string
+}
+
+func NewReferences(svc s3iface.S3API, bucketName string, bucketPrefix string, paths []string) (*References, error) {
+	if len(paths) == 0 {
+		return nil, errors.New("no reference paths configured")
+	}
+	refs := &References{valueIndex: newValueIndex(svc, bucketName, bucketPrefix, referencesPrefix)}
+	for _, p := range paths {
+		path := strings.Split(p, ".")
+		for _, key := range path {
+			if key == "" {
+				return nil, fmt.Errorf("invalid reference path %q", p)
+			}
+		}
+		refs.paths = append(refs.paths, path)
+	}
+	return refs, nil
+}
+
+// referencedUUIDs returns the distinct UUIDs found in the values at the reference paths of a payload, other than its own.
+func (refs *References) referencedUUIDs(uuid string, body []byte) []string {
+	doc := decodeObject(body)
+	var uuids []string
+	seen := map[string]bool{uuid: true}
+	for _, path := range refs.paths {
+		for _, v := range fieldValues(doc, path) {
+			for _, ref := range referencePattern.FindAllString(v, -1) {
+				if !seen[ref] {
+					seen[ref] = true
+					uuids = append(uuids, ref)
+				}
+			}
+		}
+	}
+	sort.Strings(uuids)
+	return uuids
+}
+
+// Update records the UUIDs an item's payload refers to, removing those it referred to before.
+func (refs *References) Update(uuid string, path string, body []byte) error {
+	return refs.set(uuid, path, "", refs.referencedUUIDs(uuid, body))
+}
+
+// Remove removes the references of a deleted item.
+func (refs *References) Remove(uuid string, path string) error {
+	return refs.set(uuid, path, "", nil)
+}
+
+// ReferencedBy returns the UUIDs of the items stored under a path which refer to a UUID.
+func (refs *References) ReferencedBy(uuid string, path string) ([]string, error) {
+	return refs.lookup(path, "", uuid)
+}
+
+// Rebuild clears the references of the items stored under a path and records those of every item again.
+func (refs *References) Rebuild(path string, workers int, log *logger.UPPLogger) (IndexSummary, error) {
+	return refs.rebuild(path, []string{""}, workers, refs.Update, log)
+}
+
+// RebuildSummary reports the outcome of rebuilding the index and the references, when each was rebuilt.
+type RebuildSummary struct {
+	Index      *IndexSummary `json:"index,omitempty"`
+	References *IndexSummary `json:"references,omitempty"`
+}
+
+// Failed counts the items which failed to be indexed or to have their references recorded.
+func (s RebuildSummary) Failed() int64 {
+	var failed int64
+	for _, summary := range []*IndexSummary{s.Index, s.References} {
+		if summary != nil {
+			failed += summary.Failed
+		}
+	}
+	return failed
+}
+
+// RebuildIndexes rebuilds the index of the fields and the references at the reference paths of the items stored under
+// a path, skipping either when none are given. It stops at the first which cannot be rebuilt.
+func RebuildIndexes(svc s3iface.S3API, bucketName string, bucketPrefix string, path string, fields []string, referencePaths []string, workers int, log *logger.UPPLogger) (RebuildSummary, error) {
+	var summaries RebuildSummary
+	if len(fields) == 0 && len(referencePaths) == 0 {
+		return summaries, errors.New("no index fields or reference paths to rebuild")
+	}
+
+	if len(fields) > 0 {
+		idx, err := NewIndex(svc, bucketName, bucketPrefix, fields)
+		if err != nil {
+			return summaries, err
+		}
+		summary, err := idx.Rebuild(path, workers, log)
+		summaries.Index = &summary
+		if err != nil {
+			return summaries, fmt.Errorf("rebuilding the index failed: %w", err)
+		}
+	}
+	if len(referencePaths) > 0 {
+		refs, err := NewReferences(svc, bucketName, bucketPrefix, referencePaths)
+		if err != nil {
+			return summaries, err
+		}
+		summary, err := refs.Rebuild(path, workers, log)
+		summaries.References = &summary
+		if err != nil {
+			return summaries, fmt.Errorf("rebuilding the references failed: %w", err)
+		}
+	}
+	return summaries, nil
+}
+
+type ReferenceHandler struct {
+	references *References
+	log        *logger.UPPLogger
+}
+
+func NewReferenceHandler(references *References, log *logger.UPPLogger) ReferenceHandler {
+	return ReferenceHandler{references: references, log: log}
+}
+
+// HandleReferencedBy returns the UUIDs of the items referring to a UUID.
+func (rh *ReferenceHandler) HandleReferencedBy(rw http.ResponseWriter, r *http.Request) {
+	tid := transactionid.GetTransactionIDFromRequest(r)
+	uuid := mux.Vars(r)["uuid"]
+	uuids, err := rh.references.ReferencedBy(uuid, r.URL.Query().Get("path"))
+	if err != nil {
+		readerServiceUnavailable(r.URL.RequestURI(), err, rw, tid, rh.log)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(rw).Encode(uuids); err != nil {
+		rh.log.WithError(err).WithTransactionID(tid).WithUUID(uuid).Error("Error writing references")
+	}
+}
@@ -0,0 +1,163 @@
+package service
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Financial-Times/go-logger/v2"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+const referencedUUID = "dbb0bdae-1f0c-11e4-b0cb-b2227cce2b54"
+
+func newTestReferences(t *testing.T, svc s3iface.S3API, bucketPrefix string) *References {
+	refs, err := NewReferences(svc, "bucketName", bucketPrefix, []string{"brands.id", "parentUUID"})
+	assert.NoError(t, err)
+	return refs
+}
+
+func TestNewReferencesInvalidPaths(t *testing.T) {
+	_, err := NewReferences(newBucketMock(nil), "bucketName", "", nil)
+	assert.EqualError(t, err, "no reference paths configured")
+	_, err = NewReferences(newBucketMock(nil), "bucketName", "", []string{"brands..id"})
+	assert.EqualError(t, err, `invalid reference path "brands..id"`)
+}
+
+func TestReferencedUUIDs(t *testing.T) {
+	refs := newTestReferences(t, newBucketMock(nil), "")
+
+	body := []byte(`{
+		"brands": [{"id": "http://api.ft.com/things/` + referencedUUID + `"}, {"id": "` + otherIndexedUUID + `"}, {"id": "not a uuid"}],
+		"parentUUID": "` + indexedUUID + `",
+		"ignored": "` + referencedUUID + `"
+	}`)
+	assert.Equal(t, []string{otherIndexedUUID, referencedUUID}, refs.referencedUUIDs(indexedUUID, body), "self references are excluded")
+	assert.Empty(t, refs.referencedUUIDs(indexedUUID, []byte("not json")))
+}
+
+func TestReferencesUpdateAndReferencedBy(t *testing.T) {
+	s := newBucketMock(nil)
+	refs := newTestReferences(t, s, "content")
+
+	assert.NoError(t, refs.Update(indexedUUID, "", []byte(`{"brands": [{"id": "`+referencedUUID+`"}]}`)))
+	assert.NoError(t, refs.Update(otherIndexedUUID, "", []byte(`{"parentUUID": "`+referencedUUID+`"}`)))
+	assert.Equal(t, []string{
+		"__references/content/k/" + indexedUUID,
+		"__references/content/k/" + otherIndexedUUID,
+		"__references/content/v/" + referencedUUID + "/" + indexedUUID,
+		"__references/content/v/" + referencedUUID + "/" + otherIndexedUUID,
+	}, s.keys(""))
+
+	uuids, err := refs.ReferencedBy(referencedUUID, "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{indexedUUID, otherIndexedUUID}, uuids)
+
+	assert.NoError(t, refs.Update(otherIndexedUUID, "", []byte(`{"parentUUID": "`+indexedUUID+`"}`)))
+	uuids, err = refs.ReferencedBy(referencedUUID, "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{indexedUUID}, uuids)
+	uuids, err = refs.ReferencedBy(indexedUUID, "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{otherIndexedUUID}, uuids)
+
+	assert.NoError(t, refs.Remove(indexedUUID, ""))
+	assert.NoError(t, refs.Remove(otherIndexedUUID, ""))
+	assert.Empty(t, s.keys(""))
+	uuids, err = refs.ReferencedBy(referencedUUID, "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{}, uuids)
+}
+
+func TestReferencesRebuild(t *testing.T) {
+	s := newBucketMock(map[string]string{
+		"2136f8ad/e94e/45cb/b616/336f38533214":                               `{"parentUUID": "` + referencedUUID + `"}`,
+		"__references/v/" + otherIndexedUUID + "/" + indexedUUID:             "",
+		"__references/k/" + indexedUUID:                                      `["` + otherIndexedUUID + `"]`,
+		"__index/prefLabel/v/Financial%20Times/" + indexedUUID:               "",
+		"__references/TestDirectory/v/" + referencedUUID + "/" + indexedUUID: "",
+	})
+	refs := newTestReferences(t, s, "")
+
+	summary, err := refs.Rebuild("TestDirectory", 2, logger.NewUPPLogger("references_test", "Debug"))
+	assert.NoError(t, err)
+	assert.Equal(t, IndexSummary{Cleared: 1}, summary)
+	assert.Empty(t, s.keys("__references/TestDirectory/"))
+
+	s.objects["__references/TestDirectory/v/"+referencedUUID+"/"+otherIndexedUUID] = ""
+
+	summary, err = refs.Rebuild("", 2, logger.NewUPPLogger("references_test", "Debug"))
+	assert.NoError(t, err)
+	assert.Equal(t, IndexSummary{Cleared: 2, Indexed: 1}, summary)
+	assert.Equal(t, []string{
+		"__index/prefLabel/v/Financial%20Times/" + indexedUUID,
+		"__references/TestDirectory/v/" + referencedUUID + "/" + otherIndexedUUID,
+		"__references/k/" + indexedUUID,
+		"__references/v/" + referencedUUID + "/" + indexedUUID,
+	}, s.keys("__"))
+}
+
+func TestRebuildIndexes(t *testing.T) {
+	log := logger.NewUPPLogger("references_test", "Debug")
+	item := `{"prefLabel": "Financial Times", "parentUUID": "` + referencedUUID + `"}`
+
+	s := newBucketMock(map[string]string{"2136f8ad/e94e/45cb/b616/336f38533214": item})
+	summary, err := RebuildIndexes(s, "bucketName", "", "", nil, []string{"parentUUID"}, 2, log)
+	assert.NoError(t, err)
+	assert.Equal(t, RebuildSummary{References: &IndexSummary{Indexed: 1}}, summary, "only the references are rebuilt without fields")
+	assert.Equal(t, []string{
+		"__references/k/" + indexedUUID,
+		"__references/v/" + referencedUUID + "/" + indexedUUID,
+	}, s.keys("__"))
+
+	summary, err = RebuildIndexes(s, "bucketName", "", "", []string{"prefLabel"}, []string{"parentUUID"}, 2, log)
+	assert.NoError(t, err)
+	assert.Equal(t, RebuildSummary{Index: &IndexSummary{Indexed: 1}, References: &IndexSummary{Cleared: 2, Indexed: 1}}, summary)
+	assert.Len(t, s.keys("__index/"), 2)
+	assert.Equal(t, int64(0), summary.Failed())
+
+	_, err = RebuildIndexes(s, "bucketName", "", "", nil, []string{"brands..id"}, 2, log)
+	assert.EqualError(t, err, `invalid reference path "brands..id"`)
+	_, err = RebuildIndexes(s, "bucketName", "", "", nil, nil, 2, log)
+	assert.EqualError(t, err, "no index fields or reference paths to rebuild")
+
+	s.err = errors.New("S3 unavailable")
+	summary, err = RebuildIndexes(s, "bucketName", "", "", nil, []string{"parentUUID"}, 2, log)
+	assert.EqualError(t, err, "rebuilding the references failed: S3 unavailable")
+	assert.NotNil(t, summary.References)
+}
+
+func TestReferencesWriter(t *testing.T) {
+	s := newBucketMock(nil)
+	w := NewIndexWriter(&mockWriter{writeStatus: CREATED}, newTestReferences(t, s, ""), logger.NewUPPLogger("references_test", "Debug"))
+
+	b := []byte(`{"parentUUID": "` + referencedUUID + `"}`)
+	status, err := w.Write(indexedUUID, "", &b, "application/json", "tid_test", false)
+	assert.NoError(t, err)
+	assert.Equal(t, CREATED, status)
+	assert.Equal(t, []string{"__references/k/" + indexedUUID, "__references/v/" + referencedUUID + "/" + indexedUUID}, s.keys(""))
+
+	assert.NoError(t, w.Delete(indexedUUID, "", "tid_test"))
+	assert.Empty(t, s.keys(""))
+}
+
+func TestHandleReferencedBy(t *testing.T) {
+	log := logger.NewUPPLogger("references_test", "Debug")
+	s := newBucketMock(nil)
+	refs := newTestReferences(t, s, "")
+	assert.NoError(t, refs.Update(indexedUUID, "", []byte(`{"parentUUID": "`+referencedUUID+`"}`)))
+	r := mux.NewRouter()
+	ReferenceHandlers(r, NewReferenceHandler(refs, log), ExpectedResourcePath)
+
+	assertRequestAndResponseFromRouter(t, r, withExpectedResourcePath("/"+referencedUUID+"/__referencedBy"), http.StatusOK, `["`+indexedUUID+`"]`+"\n", "application/json")
+	assertRequestAndResponseFromRouter(t, r, withExpectedResourcePath("/"+indexedUUID+"/__referencedBy"), http.StatusOK, "[]\n", "application/json")
+	assertRequestAndResponseFromRouter(t, r, withExpectedResourcePath("/"+referencedUUID+"/__referencedBy?path=TestDirectory"), http.StatusOK, "[]\n", "application/json")
+
+	s.err = errors.New("S3 unavailable")
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, newRequest("GET", withExpectedResourcePath("/"+referencedUUID+"/__referencedBy"), ""))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+}
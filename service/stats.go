@@ -0,0 +1,197 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Financial-Times/go-logger/v2"
+	transactionid "github.com/Financial-Times/transactionid-utils-go"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+const rootPath = "/"
+
+// GroupStats counts the items and bytes of a path.
+type GroupStats struct {
+	Count      int64 `json:"count"`
+	TotalBytes int64 `json:"totalBytes"`
+}
+
+// BucketStats describes the items stored under a bucket prefix, from what listing them returns.
+type BucketStats struct {
+	Count          int64                  `json:"count"`
+	TotalBytes     int64                  `json:"totalBytes"`
+	AverageSize    float64                `json:"averageSize"`
+	MaxSize        int64                  `json:"maxSize"`
+	OldestModified *time.Time             `json:"oldestModified,omitempty"`
+	NewestModified *time.Time             `json:"newestModified,omitempty"`
+	ByPath         map[string]*GroupStats `json:"byPath"`
+	ComputedAt     time.Time              `json:"computedAt"`
+}
+
+func (s *BucketStats) add(path string, o *s3.Object) {
+	size := aws.Int64Value(o.Size)
+	s.Count++
+	s.TotalBytes += size
+	if size > s.MaxSize {
+		s.MaxSize = size
+	}
+	if m := o.LastModified; m != nil {
+		if s.OldestModified == nil || m.Before(*s.OldestModified) {
+			s.OldestModified = m
+		}
+		if s.NewestModified == nil || m.After(*s.NewestModified) {
+			s.NewestModified = m
+		}
+	}
+	addGroup(s.ByPath, path, size)
+}
+
+func addGroup(groups map[string]*GroupStats, key string, size int64) {
+	g, ok := groups[key]
+	if !ok {
+		g = &GroupStats{}
+		groups[key] = g
+	}
+	g.Count++
+	g.TotalBytes += size
+}
+
+var errStatsNotComputed = errors.New("statistics have not been computed yet")
+
+// StatsCollector computes BucketStats in a single listing pass, without reading the items, in the background every
+// refresh interval, and serves the last statistics computed.
+type StatsCollector struct {
+	svc             s3iface.S3API
+	bucketName      string
+	bucketPrefix    string
+	refreshInterval time.Duration
+	now             func() time.Time
+	mu              sync.Mutex
+	stats           *BucketStats
+	log             *logger.UPPLogger
+}
+
+func NewStatsCollector(svc s3iface.S3API, bucketName string, bucketPrefix string, refreshInterval time.Duration, log *logger.UPPLogger) *StatsCollector {
+	return &StatsCollector{
+		svc:             svc,
+		bucketName:      bucketName,
+		bucketPrefix:    bucketPrefix,
+		refreshInterval: refreshInterval,
+		now:             time.Now,
+		log:             log,
+	}
+}
+
+// Stats returns the last statistics computed, or errStatsNotComputed until they have been computed once.
+func (c *StatsCollector) Stats() (BucketStats, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stats == nil {
+		return BucketStats{}, errStatsNotComputed
+	}
+	return *c.stats, nil
+}
+
+// Refresh computes the statistics and replaces the last ones. The last statistics are kept when it fails.
+func (c *StatsCollector) Refresh() (BucketStats, error) {
+	stats, err := c.compute()
+	if err != nil {
+		return BucketStats{}, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stats = &stats
+	return stats, nil
+}
+
+// Start computes the statistics immediately and then every refresh interval until the stop channel is closed.
+// Without a refresh interval they are only computed once.
+func (c *StatsCollector) Start(stop <-chan struct{}) {
+	c.refresh()
+	if c.refreshInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(c.refreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.refresh()
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (c *StatsCollector) refresh() {
+	if _, err := c.Refresh(); err != nil {
+		c.log.WithError(err).Error("Failed to compute bucket statistics")
+	}
+}
+
+func (c *StatsCollector) compute() (BucketStats, error) {
+	stats := BucketStats{ByPath: map[string]*GroupStats{}}
+	location := BucketLocation{Bucket: c.bucketName, Prefix: c.bucketPrefix}
+	err := c.svc.ListObjectsV2Pages(location.listInput(), func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, o := range page.Contents {
+			if isItemKey(*o.Key) {
+				stats.add(c.itemPath(*o.Key), o)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return BucketStats{}, err
+	}
+
+	if stats.Count > 0 {
+		stats.AverageSize = float64(stats.TotalBytes) / float64(stats.Count)
+	}
+	stats.ComputedAt = c.now()
+	return stats, nil
+}
+
+// itemPath returns the path an item was written to, the part of its key before the partitioned UUID.
+func (c *StatsCollector) itemPath(key string) string {
+	if c.bucketPrefix != "" {
+		key = strings.TrimPrefix(key, c.bucketPrefix+"/")
+	}
+	parts := strings.Split(key, "/")
+	if len(parts) <= 5 {
+		return rootPath
+	}
+	return strings.Join(parts[:len(parts)-5], "/")
+}
+
+type StatsHandler struct {
+	collector *StatsCollector
+	log       *logger.UPPLogger
+}
+
+func NewStatsHandler(collector *StatsCollector, log *logger.UPPLogger) StatsHandler {
+	return StatsHandler{collector: collector, log: log}
+}
+
+func (sh *StatsHandler) HandleStats(rw http.ResponseWriter, r *http.Request) {
+	tid := transactionid.GetTransactionIDFromRequest(r)
+	stats, err := sh.collector.Stats()
+	if err != nil {
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(rw).Encode(map[string]string{"message": err.Error()})
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(rw).Encode(stats); err != nil {
+		sh.log.WithError(err).WithTransactionID(tid).Error("Error writing stats")
+	}
+}
@@ -0,0 +1,123 @@
+package service
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Financial-Times/go-logger/v2"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestStatsCollector(log *logger.UPPLogger) (*StatsCollector, *mockS3Client) {
+	s := &mockS3Client{
+		log: log,
+		listObjectsV2Outputs: []*s3.ListObjectsV2Output{
+			{
+				Contents: []*s3.Object{
+					{Key: aws.String("123e4567/e89b/12d3/a456/426655440001"), Size: aws.Int64(10), LastModified: aws.Time(time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC))},
+					{Key: aws.String("123e4567/e89b/12d3/a456/426655440002"), Size: aws.Int64(30)},
+					{Key: aws.String("__gtg")}, // ignored as starts with '__'
+				},
+			},
+			{
+				Contents: []*s3.Object{
+					{Key: aws.String("TestDirectory/123e4567/e89b/12d3/a456/426655440003"), Size: aws.Int64(80), LastModified: aws.Time(time.Date(2024, 2, 3, 0, 0, 0, 0, time.UTC))},
+				},
+			},
+		},
+	}
+	return NewStatsCollector(s, "testBucket", "", time.Hour, log), s
+}
+
+func TestStatsCollectorComputesStats(t *testing.T) {
+	log := logger.NewUPPLogger("stats_test", "Debug")
+	c, s := newTestStatsCollector(log)
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	c.now = func() time.Time { return now }
+
+	stats, err := c.Refresh()
+	assert.NoError(t, err)
+	assert.Equal(t, BucketStats{
+		Count:          3,
+		TotalBytes:     120,
+		AverageSize:    40,
+		MaxSize:        80,
+		OldestModified: aws.Time(time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)),
+		NewestModified: aws.Time(time.Date(2024, 2, 3, 0, 0, 0, 0, time.UTC)),
+		ByPath: map[string]*GroupStats{
+			"/":             {Count: 2, TotalBytes: 40},
+			"TestDirectory": {Count: 1, TotalBytes: 80},
+		},
+		ComputedAt: now,
+	}, stats)
+	assert.Nil(t, s.headObjectInput, "items are not read")
+}
+
+func TestStatsCollectorServesLastStats(t *testing.T) {
+	log := logger.NewUPPLogger("stats_test", "Debug")
+	c, s := newTestStatsCollector(log)
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	c.now = func() time.Time { return now }
+
+	_, err := c.Stats()
+	assert.ErrorIs(t, err, errStatsNotComputed)
+	assert.Empty(t, s.listObjectsV2Input, "requests never compute the statistics")
+
+	_, err = c.Refresh()
+	assert.NoError(t, err)
+	now = now.Add(2 * time.Hour)
+	stats, err := c.Stats()
+	assert.NoError(t, err)
+	assert.Len(t, s.listObjectsV2Input, 1)
+	assert.Equal(t, now.Add(-2*time.Hour), stats.ComputedAt)
+
+	s.s3error = errors.New("list failed")
+	_, err = c.Refresh()
+	assert.EqualError(t, err, "list failed")
+	stats, err = c.Stats()
+	assert.NoError(t, err, "the last statistics are kept when computing them fails")
+	assert.Equal(t, int64(3), stats.Count)
+}
+
+func TestStatsCollectorStartWithoutRefreshInterval(t *testing.T) {
+	log := logger.NewUPPLogger("stats_test", "Debug")
+	c, s := newTestStatsCollector(log)
+	c.refreshInterval = 0
+
+	c.Start(make(chan struct{}))
+	assert.Len(t, s.listObjectsV2Input, 1)
+	stats, err := c.Stats()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), stats.Count)
+}
+
+func TestStatsCollectorItemPath(t *testing.T) {
+	c := &StatsCollector{bucketPrefix: "test/prefix"}
+	assert.Equal(t, rootPath, c.itemPath("test/prefix/123e4567/e89b/12d3/a456/426655440001"))
+	assert.Equal(t, "a/b", c.itemPath("test/prefix/a/b/123e4567/e89b/12d3/a456/426655440001"))
+}
+
+func TestHandleStats(t *testing.T) {
+	log := logger.NewUPPLogger("stats_test", "Debug")
+	c, _ := newTestStatsCollector(log)
+	sh := NewStatsHandler(c, log)
+
+	rec := httptest.NewRecorder()
+	sh.HandleStats(rec, httptest.NewRequest(http.MethodGet, "/__stats", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	assert.Equal(t, "{\"message\":\"statistics have not been computed yet\"}\n", rec.Body.String())
+
+	_, err := c.Refresh()
+	assert.NoError(t, err)
+	rec = httptest.NewRecorder()
+	sh.HandleStats(rec, httptest.NewRequest(http.MethodGet, "/__stats", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	assert.Contains(t, rec.Body.String(), `"count":3,"totalBytes":120,"averageSize":40,"maxSize":80`)
+}
@@ -0,0 +1,124 @@
+package service
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/Financial-Times/go-logger/v2"
+)
+
+const (
+	trailerItemCount     = "X-Item-Count"
+	trailerErrorCount    = "X-Error-Count"
+	trailerStreamAborted = "X-Stream-Aborted"
+)
+
+// StreamOptions sets how a streamed listing handles errors. With FailFast the stream is aborted on the first error,
+// otherwise failing items are skipped. The outcome is counted in the Report, if there is one.
+type StreamOptions struct {
+	FailFast bool
+	Report   *StreamReport
+}
+
+func parseStreamOptions(r *http.Request) StreamOptions {
+	return StreamOptions{FailFast: r.URL.Query().Get("failFast") == "true", Report: &StreamReport{}}
+}
+
+// StreamReport counts the items streamed and the errors met while streaming, it is complete once the stream has ended.
+type StreamReport struct {
+	items  int64
+	errors int64
+}
+
+func (s *StreamReport) Items() int64 {
+	if s == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&s.items)
+}
+
+func (s *StreamReport) Errors() int64 {
+	if s == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&s.errors)
+}
+
+func (s *StreamReport) addItem() {
+	if s != nil {
+		atomic.AddInt64(&s.items, 1)
+	}
+}
+
+func (s *StreamReport) addError() {
+	if s != nil {
+		atomic.AddInt64(&s.errors, 1)
+	}
+}
+
+// stream writes a streamed listing to a pipe, aborting it on the first error when failing fast, and on the first failed
+// write whether failing fast or not, as the pipe has then been closed by its reader.
+type stream struct {
+	StreamOptions
+	pw    *io.PipeWriter
+	done  chan struct{}
+	abort sync.Once
+}
+
+func newStream(pw *io.PipeWriter, opts StreamOptions) *stream {
+	return &stream{StreamOptions: opts, pw: pw, done: make(chan struct{})}
+}
+
+// fail counts an error, closing the pipe with it when failing fast.
+func (s *stream) fail(err error) {
+	s.Report.addError()
+	if !s.FailFast {
+		return
+	}
+	s.stop(err)
+}
+
+func (s *stream) stop(err error) {
+	s.abort.Do(func() {
+		close(s.done)
+		s.pw.CloseWithError(err)
+	})
+}
+
+// Write writes to the pipe, aborting the stream if the write fails.
+func (s *stream) Write(p []byte) (int, error) {
+	n, err := s.pw.Write(p)
+	if err != nil {
+		s.stop(err)
+	}
+	return n, err
+}
+
+func (s *stream) aborted() bool {
+	return isDone(s.done)
+}
+
+func isDone(done <-chan struct{}) bool {
+	select {
+	case <-done:
+		return true
+	default:
+		return false
+	}
+}
+
+// copyStream sends a stream as the body of a response, reporting its outcome in trailers once it has been sent.
+func copyStream(rw http.ResponseWriter, pv *io.PipeReader, report *StreamReport, tid string, log *logger.UPPLogger) {
+	rw.Header().Set("Trailer", trailerItemCount+", "+trailerErrorCount+", "+trailerStreamAborted)
+	rw.WriteHeader(http.StatusOK)
+	_, err := io.Copy(rw, pv)
+	if err != nil {
+		log.WithError(err).WithTransactionID(tid).Error("Stream was aborted")
+	}
+	rw.Header().Set(trailerItemCount, strconv.FormatInt(report.Items(), 10))
+	rw.Header().Set(trailerErrorCount, strconv.FormatInt(report.Errors(), 10))
+	rw.Header().Set(trailerStreamAborted, strconv.FormatBool(err != nil))
+}
@@ -0,0 +1,51 @@
+package service
+
+import (
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStreamFailCountsErrors(t *testing.T) {
+	_, pw := io.Pipe()
+	report := &StreamReport{}
+	st := newStream(pw, StreamOptions{Report: report})
+	st.fail(errors.New("first"))
+	st.fail(errors.New("second"))
+
+	assert.False(t, st.aborted())
+	assert.Equal(t, int64(2), report.Errors())
+}
+
+func TestStreamFailFastAbortsOnFirstError(t *testing.T) {
+	pv, pw := io.Pipe()
+	st := newStream(pw, StreamOptions{FailFast: true})
+	st.fail(errors.New("first"))
+	st.fail(errors.New("second"))
+
+	assert.True(t, st.aborted())
+	_, err := io.ReadAll(pv)
+	assert.EqualError(t, err, "first")
+}
+
+func TestStreamAbortsWhenWriteFails(t *testing.T) {
+	pv, pw := io.Pipe()
+	report := &StreamReport{}
+	st := newStream(pw, StreamOptions{Report: report})
+	pv.Close()
+
+	_, err := st.Write([]byte("item\n"))
+	assert.ErrorIs(t, err, io.ErrClosedPipe)
+	assert.True(t, st.aborted(), "a stream whose reader has gone is aborted without failing fast")
+	assert.Equal(t, int64(0), report.Errors())
+}
+
+func TestNilStreamReport(t *testing.T) {
+	var report *StreamReport
+	report.addItem()
+	report.addError()
+	assert.Equal(t, int64(0), report.Items())
+	assert.Equal(t, int64(0), report.Errors())
+}